@@ -0,0 +1,7 @@
+package main
+
+// Database drivers available to the database commands. Only Postgres is
+// linked, so heap migrate serves Postgres heap databases; SQLite ones are
+// migrated by the application with heapstore.NewSQLite(db).Migrate, using the
+// driver it registers.
+import _ "github.com/lib/pq"
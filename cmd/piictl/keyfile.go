@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dyaksa/encryption-pii/crypto/config"
+	"github.com/dyaksa/encryption-pii/crypto/core"
+	"github.com/dyaksa/encryption-pii/crypto/keyfile"
+)
+
+func runKeyfile(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: piictl keyfile <create|inspect|rewrap> [flags]")
+	}
+
+	switch args[0] {
+	case "create":
+		return runKeyfileCreate(args[1:])
+	case "inspect":
+		return runKeyfileInspect(args[1:])
+	case "rewrap":
+		return runKeyfileRewrap(args[1:])
+	default:
+		return fmt.Errorf("unknown keyfile command %q", args[0])
+	}
+}
+
+func runKeyfileCreate(args []string) error {
+	fs := flag.NewFlagSet("keyfile create", flag.ContinueOnError)
+	out := fs.String("out", "", "path of the keyfile to create")
+	kdf := fs.String("kdf", keyfile.KDFArgon2id, "key derivation function: argon2id or scrypt")
+	aesSize := fs.Int("aes-size", 32, "size in bytes of the generated AES key: 16, 24 or 32")
+	fromEnv := fs.Bool("from-env", false, "wrap CRYPTO_AES_KEY and CRYPTO_HMAC_KEY instead of generating new keys")
+	pass := passphraseFlags(fs, "passphrase")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return errors.New("-out is required")
+	}
+
+	params, err := kdfParams(*kdf)
+	if err != nil {
+		return err
+	}
+
+	var ks keyfile.Keyset
+	if *fromEnv {
+		_ = config.LoadDotEnv()
+		cfg, err := config.FromEnv(config.DefaultEnvPrefix)
+		if err != nil {
+			return err
+		}
+
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+
+		aesKey, err := core.DecodeKey(cfg.AesKey)
+		if err != nil {
+			return fmt.Errorf("%s: %w", config.AesKey, err)
+		}
+
+		hmacKey, err := core.DecodeKey(cfg.HmacKey)
+		if err != nil {
+			return fmt.Errorf("%s: %w", config.HmacKey, err)
+		}
+
+		ks = keyfile.Keyset{
+			AES:  keyfile.Ring{Primary: 1, Keys: []keyfile.Key{{ID: 1, Material: aesKey}}},
+			HMAC: keyfile.Ring{Primary: 1, Keys: []keyfile.Key{{ID: 1, Material: hmacKey}}},
+		}
+	} else if ks, err = keyfile.Generate(*aesSize); err != nil {
+		return err
+	}
+
+	passphrase, err := pass()()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%s already exists", *out)
+	}
+
+	return keyfile.WriteFile(*out, ks, passphrase, params)
+}
+
+func runKeyfileInspect(args []string) error {
+	fs := flag.NewFlagSet("keyfile inspect", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: piictl keyfile inspect <keyfile>")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	header, err := keyfile.Inspect(data)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(header)
+}
+
+func runKeyfileRewrap(args []string) error {
+	fs := flag.NewFlagSet("keyfile rewrap", flag.ContinueOnError)
+	in := fs.String("in", "", "path of the existing keyfile")
+	out := fs.String("out", "", "path of the rewrapped keyfile (default: replace -in)")
+	kdf := fs.String("kdf", keyfile.KDFArgon2id, "key derivation function for the new keyfile: argon2id or scrypt")
+	oldPass := passphraseFlags(fs, "passphrase")
+	newPass := passphraseFlags(fs, "new-passphrase")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *in == "" {
+		return errors.New("-in is required")
+	}
+
+	if *out == "" {
+		*out = *in
+	}
+
+	params, err := kdfParams(*kdf)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+
+	oldPassphrase, err := oldPass()()
+	if err != nil {
+		return err
+	}
+
+	newPassphrase, err := newPass()()
+	if err != nil {
+		return err
+	}
+
+	rewrapped, err := keyfile.Rewrap(data, oldPassphrase, newPassphrase, params)
+	if err != nil {
+		return err
+	}
+
+	tmp := *out + ".tmp"
+	if err := os.WriteFile(tmp, rewrapped, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, *out)
+}
+
+// stdin is shared by the passphrase sources, so -passphrase and
+// -new-passphrase read the first and second line of it.
+var stdin = bufio.NewReader(os.Stdin)
+
+// passphraseFlags registers -<name>-env and -<name>-file. When neither is set
+// the passphrase is read from the next line of stdin.
+func passphraseFlags(fs *flag.FlagSet, name string) func() keyfile.PassphraseSource {
+	env := fs.String(name+"-env", "", "environment variable holding the "+name)
+	file := fs.String(name+"-file", "", "file holding the "+name)
+
+	return func() keyfile.PassphraseSource {
+		switch {
+		case *env != "":
+			return keyfile.PassphraseFromEnv(*env)
+		case *file != "":
+			return keyfile.PassphraseFromFile(*file)
+		default:
+			return keyfile.PassphraseFromReader(stdin)
+		}
+	}
+}
+
+func kdfParams(name string) (keyfile.KDFParams, error) {
+	switch name {
+	case keyfile.KDFArgon2id:
+		return keyfile.DefaultArgon2id(), nil
+	case keyfile.KDFScrypt:
+		return keyfile.DefaultScrypt(), nil
+	default:
+		return keyfile.KDFParams{}, fmt.Errorf("unsupported kdf %q", name)
+	}
+}
@@ -0,0 +1,59 @@
+// Command piictl runs operational tasks for encryption-pii deployments such as
+// key ceremonies and keyfile management.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "split", usage: "split a key into Shamir shares", run: runSplit},
+	{name: "combine", usage: "rebuild a key from Shamir shares", run: runCombine},
+	{name: "keyfile", usage: "create, inspect or rewrap passphrase protected keyfiles", run: runKeyfile},
+	{name: "heap", usage: "create and upgrade heap tables", run: runHeap},
+	{name: "encrypt-column", usage: "encrypt a plaintext column in place or into a shadow column", run: runEncryptColumn},
+	{name: "reencrypt", usage: "move encrypted columns to another AES key or algorithm", run: runReencrypt},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+
+		if err := cmd.run(os.Args[2:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "piictl %s: %v\n", cmd.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: piictl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", cmd.name, cmd.usage)
+	}
+}
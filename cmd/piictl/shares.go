@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dyaksa/encryption-pii/crypto/secretshare"
+)
+
+func runSplit(args []string) error {
+	fs := flag.NewFlagSet("split", flag.ContinueOnError)
+	parts := fs.Int("n", 5, "number of shares to create")
+	threshold := fs.Int("k", 3, "number of shares required to rebuild the key")
+	in := fs.String("in", "", "file holding the key (default stdin)")
+	out := fs.String("out", "", "directory to write share-<i>.txt files to (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	secret, err := readSecret(*in)
+	if err != nil {
+		return err
+	}
+
+	shares, err := secretshare.Split(secret, *parts, *threshold)
+	if err != nil {
+		return err
+	}
+
+	for i, share := range shares {
+		line := fmt.Sprintf("# share %d of %d, threshold %d\n%s\n", i+1, *parts, *threshold, secretshare.Encode(share))
+		if *out == "" {
+			fmt.Print(line)
+			continue
+		}
+
+		path := filepath.Join(*out, fmt.Sprintf("share-%d.txt", i+1))
+		if err := os.WriteFile(path, []byte(line), 0o600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runCombine(args []string) error {
+	fs := flag.NewFlagSet("combine", flag.ContinueOnError)
+	out := fs.String("out", "", "file to write the key to (default stdout)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: piictl combine [-out file] [share files...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	source := secretshare.FromReader(os.Stdin)
+	if fs.NArg() > 0 {
+		source = secretshare.FromFiles(fs.Args()...)
+	}
+
+	secret, err := secretshare.CombineFrom(source)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err = fmt.Println(string(secret))
+		return err
+	}
+
+	return os.WriteFile(*out, secret, 0o600)
+}
+
+func readSecret(path string) ([]byte, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if path == "" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(b, "\r\n"), nil
+}
@@ -10,7 +10,10 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"time"
 
 	"github.com/dyaksa/encryption-pii/crypto/core"
 )
@@ -19,6 +22,12 @@ type (
 	AesAlg string
 )
 
+var (
+	ErrInvalidKeySize = core.ErrInvalidKeySize
+	ErrDecryptFailed  = core.ErrDecryptFailed
+	ErrAuthFailed     = core.ErrAuthFailed
+)
+
 const (
 	AesCBC AesAlg = "cbc"
 
@@ -35,14 +44,34 @@ func PKCS5Padding(plainText []byte) []byte {
 
 func PKCS5UnPadding(src []byte) ([]byte, error) {
 	length := len(src)
-	unpadding := int(src[length-1])
-	unpadding = length - unpadding
-	if unpadding < 0 {
-		return nil, errors.New("invalid encrypted data or key")
+	if length == 0 {
+		return nil, fmt.Errorf("%w: invalid encrypted data or key", ErrDecryptFailed)
+	}
+
+	padding := int(src[length-1])
+	unpadding := length - padding
+	if padding == 0 || padding > aes.BlockSize || unpadding < 0 {
+		return nil, fmt.Errorf("%w: invalid encrypted data or key", ErrDecryptFailed)
 	}
 	return src[:unpadding], nil
 }
 
+func decryptError(err error) error {
+	return fmt.Errorf("%w: %w", ErrDecryptFailed, err)
+}
+
+func authError(err error) error {
+	return fmt.Errorf("%w: %w: %w", ErrDecryptFailed, ErrAuthFailed, err)
+}
+
+func newCipher(key []byte) (cipher.Block, error) {
+	if err := core.CheckAESKey(key); err != nil {
+		return nil, err
+	}
+
+	return aes.NewCipher(key)
+}
+
 func GenerateRandomIV(b []byte) error {
 	if _, err := io.ReadFull(rand.Reader, b); err != nil {
 		return err
@@ -67,9 +96,26 @@ type AES[T interface{ *struct{} | any }, A cipher.Block] struct {
 
 	v   T
 	alg AesAlg
+
+	done func(error)
+}
+
+// OnDone returns a copy of s calling done with the result of every Value and
+// Scan, after the value was encrypted or decrypted.
+func (s AES[T, A]) OnDone(done func(err error)) AES[T, A] {
+	s.done = done
+	return s
 }
 
 func (s AES[T, A]) Value() (driver.Value, error) {
+	v, err := s.value()
+	if s.done != nil {
+		s.done(err)
+	}
+	return v, err
+}
+
+func (s AES[T, A]) value() (driver.Value, error) {
 	a, err := s.aesFunc()
 	if err != nil {
 		return nil, err
@@ -136,7 +182,15 @@ func (s AES[T, A]) Value() (driver.Value, error) {
 	return nil, errors.New("invalid algorithm")
 }
 
-func (s *AES[T, A]) Scan(src any) (err error) {
+func (s *AES[T, A]) Scan(src any) error {
+	err := s.scan(src)
+	if s.done != nil {
+		s.done(err)
+	}
+	return err
+}
+
+func (s *AES[T, A]) scan(src any) (err error) {
 	if src == nil {
 		s.v, err = s.vtob([]byte{})
 		if err != nil {
@@ -147,7 +201,7 @@ func (s *AES[T, A]) Scan(src any) (err error) {
 
 	b, ok := src.([]byte)
 	if !ok {
-		return errors.New("not an encrypted byte")
+		return fmt.Errorf("%w: not an encrypted byte", ErrDecryptFailed)
 	}
 
 	a, err := s.aesFunc()
@@ -160,14 +214,18 @@ func (s *AES[T, A]) Scan(src any) (err error) {
 		cipherDataBytes := make([]byte, hex.DecodedLen(len(b)))
 		_, err = hex.Decode(cipherDataBytes, b)
 		if err != nil {
-			return err
+			return decryptError(err)
+		}
+
+		if len(cipherDataBytes) < aes.BlockSize {
+			return fmt.Errorf("%w: encrypted data too short", ErrDecryptFailed)
 		}
 
 		iv := cipherDataBytes[:aes.BlockSize]
 		cipherData := cipherDataBytes[aes.BlockSize:]
 
 		if len(cipherData)%aes.BlockSize != 0 {
-			return errors.New("cipher data is not a multiple of the block size")
+			return fmt.Errorf("%w: cipher data is not a multiple of the block size", ErrDecryptFailed)
 		}
 
 		mode := cipher.NewCBCDecrypter(a, iv)
@@ -183,7 +241,11 @@ func (s *AES[T, A]) Scan(src any) (err error) {
 		cipherDataBytes := make([]byte, hex.DecodedLen(len(b)))
 		_, err = hex.Decode(cipherDataBytes, b)
 		if err != nil {
-			return err
+			return decryptError(err)
+		}
+
+		if len(cipherDataBytes) < a.BlockSize() {
+			return fmt.Errorf("%w: encrypted data too short", ErrDecryptFailed)
 		}
 
 		iv := cipherDataBytes[:a.BlockSize()]
@@ -199,7 +261,7 @@ func (s *AES[T, A]) Scan(src any) (err error) {
 
 		_, err = hex.Decode(cipherDataBytes, b)
 		if err != nil {
-			return err
+			return decryptError(err)
 		}
 
 		aesGCM, err := cipher.NewGCM(a)
@@ -209,22 +271,52 @@ func (s *AES[T, A]) Scan(src any) (err error) {
 
 		nonceSize := aesGCM.NonceSize()
 		if len(cipherDataBytes) < nonceSize {
-			return errors.New("ciphertext too short")
+			return fmt.Errorf("%w: ciphertext too short", ErrDecryptFailed)
 		}
 
 		nonce, cipherData := cipherDataBytes[:nonceSize], cipherDataBytes[nonceSize:]
 		plainData, err := aesGCM.Open(nil, nonce, cipherData, nil)
 		if err != nil {
-			return err
+			if plainData, err = openFallbacks(a, nonce, cipherData, err); err != nil {
+				return authError(err)
+			}
 		}
 
 		s.v, err = s.vtob(plainData)
 		return err
 	default:
-		return errors.New("invalid algorithm")
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrDecryptFailed, s.alg)
 	}
 }
 
+// openFallbacks opens a GCM ciphertext that a failed to authenticate with the
+// other keys of the keyset of a, returning err when none does. Ciphertexts
+// carry no key ID, so every key is tried.
+func openFallbacks(a cipher.Block, nonce, cipherData []byte, err error) ([]byte, error) {
+	f, ok := a.(interface {
+		Fallbacks() ([]cipher.Block, error)
+	})
+	if !ok {
+		return nil, err
+	}
+
+	blocks, ferr := f.Fallbacks()
+	if ferr != nil {
+		return nil, ferr
+	}
+
+	for _, b := range blocks {
+		aesGCM, gerr := cipher.NewGCM(b)
+		if gerr != nil {
+			return nil, gerr
+		}
+		if plainData, oerr := aesGCM.Open(nil, nonce, cipherData, nil); oerr == nil {
+			return plainData, nil
+		}
+	}
+	return nil, err
+}
+
 func (s AES[T, A]) To() T {
 	return s.v
 }
@@ -280,8 +372,44 @@ func AESChiper[A cipher.Block](aesFunc AESFunc[A], data string, alg AesAlg) AES[
 	}
 }
 
+// AESInt64 encrypts an integer, stored as its decimal text.
+func AESInt64[A cipher.Block](aesFunc AESFunc[A], data int64, alg AesAlg) AES[int64, A] {
+	return AES[int64, A]{
+		aesFunc: aesFunc,
+		btov: func(n int64) ([]byte, error) {
+			return strconv.AppendInt(nil, n, 10), nil
+		},
+		vtob: func(b []byte) (int64, error) {
+			if len(b) == 0 {
+				return 0, nil
+			}
+			return strconv.ParseInt(string(b), 10, 64)
+		},
+		alg: alg,
+		v:   data,
+	}
+}
+
+// AESTime encrypts a time, stored in RFC 3339 with nanoseconds.
+func AESTime[A cipher.Block](aesFunc AESFunc[A], data time.Time, alg AesAlg) AES[time.Time, A] {
+	return AES[time.Time, A]{
+		aesFunc: aesFunc,
+		btov: func(t time.Time) ([]byte, error) {
+			return t.AppendFormat(nil, time.RFC3339Nano), nil
+		},
+		vtob: func(b []byte) (time.Time, error) {
+			if len(b) == 0 {
+				return time.Time{}, nil
+			}
+			return time.Parse(time.RFC3339Nano, string(b))
+		},
+		alg: alg,
+		v:   data,
+	}
+}
+
 func Encrypt(alg AesAlg, key []byte, plainData []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
+	block, err := newCipher(key)
 	if err != nil {
 		return nil, err
 	}
@@ -344,10 +472,10 @@ func Decrypt(alg AesAlg, key []byte, encryptedData []byte) ([]byte, error) {
 	encryptedDataOut := make([]byte, hex.DecodedLen(len(encryptedData)))
 	encryptedDataOutN, err := hex.Decode(encryptedDataOut, encryptedData)
 	if err != nil {
-		return nil, err
+		return nil, decryptError(err)
 	}
 
-	block, err := aes.NewCipher(key)
+	block, err := newCipher(key)
 	if err != nil {
 		return nil, err
 	}
@@ -355,12 +483,12 @@ func Decrypt(alg AesAlg, key []byte, encryptedData []byte) ([]byte, error) {
 	switch alg {
 	case AesCBC:
 		if len(encryptedDataOut) < aes.BlockSize {
-			return nil, errors.New("encrypted data too short")
+			return nil, fmt.Errorf("%w: encrypted data too short", ErrDecryptFailed)
 		}
 
 		cipherDataBytes := encryptedDataOut[:encryptedDataOutN][aes.BlockSize:]
 		if len(cipherDataBytes)%aes.BlockSize != 0 {
-			return nil, errors.New("invalid padding: encrypted data is not a multiple of the block size")
+			return nil, fmt.Errorf("%w: invalid padding: encrypted data is not a multiple of the block size", ErrDecryptFailed)
 		}
 
 		nonceBytes := encryptedDataOut[:encryptedDataOutN][:aes.BlockSize]
@@ -370,10 +498,14 @@ func Decrypt(alg AesAlg, key []byte, encryptedData []byte) ([]byte, error) {
 
 		cipherDataBytes, err = PKCS5UnPadding(cipherDataBytes)
 		if err != nil {
-			return nil, errors.New("invalid encrypted data or key")
+			return nil, err
 		}
 		return cipherDataBytes, nil
 	case AesCFB:
+		if encryptedDataOutN < aes.BlockSize {
+			return nil, fmt.Errorf("%w: encrypted data too short", ErrDecryptFailed)
+		}
+
 		cipherDataBytes := encryptedDataOut[:encryptedDataOutN][aes.BlockSize:]
 		nonceBytes := encryptedDataOut[:encryptedDataOutN][:aes.BlockSize]
 
@@ -386,22 +518,26 @@ func Decrypt(alg AesAlg, key []byte, encryptedData []byte) ([]byte, error) {
 			return nil, err
 		}
 
+		if encryptedDataOutN < aesGCM.NonceSize() {
+			return nil, fmt.Errorf("%w: ciphertext too short", ErrDecryptFailed)
+		}
+
 		cipherDataBytes := encryptedDataOut[:encryptedDataOutN][aesGCM.NonceSize():]
 		nonceBytes := encryptedDataOut[:encryptedDataOutN][:aesGCM.NonceSize()]
 
 		plainDataBytes, err := aesGCM.Open(nil, nonceBytes, cipherDataBytes, nil)
 		if err != nil {
-			return nil, err
+			return nil, authError(err)
 		}
 
 		return plainDataBytes, nil
 	}
 
-	return nil, errors.New("decrypt process failed")
+	return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrDecryptFailed, alg)
 }
 
 func Encrypted(alg AesAlg, key string, plainData string) ([]byte, error) {
-	block, err := aes.NewCipher([]byte(key))
+	block, err := newCipher([]byte(key))
 	if err != nil {
 		return nil, err
 	}
@@ -464,10 +600,10 @@ func Decrypted(alg AesAlg, key string, encryptedData []byte) (string, error) {
 	encryptedDataOut := make([]byte, hex.DecodedLen(len(encryptedData)))
 	encryptedDataOutN, err := hex.Decode(encryptedDataOut, encryptedData)
 	if err != nil {
-		return "", err
+		return "", decryptError(err)
 	}
 
-	block, err := aes.NewCipher([]byte(key))
+	block, err := newCipher([]byte(key))
 	if err != nil {
 		return "", err
 	}
@@ -475,12 +611,12 @@ func Decrypted(alg AesAlg, key string, encryptedData []byte) (string, error) {
 	switch alg {
 	case AesCBC:
 		if len(encryptedDataOut) < aes.BlockSize {
-			return "", errors.New("encrypted data too short")
+			return "", fmt.Errorf("%w: encrypted data too short", ErrDecryptFailed)
 		}
 
 		cipherDataBytes := encryptedDataOut[:encryptedDataOutN][aes.BlockSize:]
 		if len(cipherDataBytes)%aes.BlockSize != 0 {
-			return "", errors.New("invalid padding: encrypted data is not a multiple of the block size")
+			return "", fmt.Errorf("%w: invalid padding: encrypted data is not a multiple of the block size", ErrDecryptFailed)
 		}
 
 		nonceBytes := encryptedDataOut[:encryptedDataOutN][:aes.BlockSize]
@@ -490,10 +626,14 @@ func Decrypted(alg AesAlg, key string, encryptedData []byte) (string, error) {
 
 		cipherDataBytes, err = PKCS5UnPadding(cipherDataBytes)
 		if err != nil {
-			return "", errors.New("invalid encrypted data or key")
+			return "", err
 		}
 		return string(cipherDataBytes), nil
 	case AesCFB:
+		if encryptedDataOutN < aes.BlockSize {
+			return "", fmt.Errorf("%w: encrypted data too short", ErrDecryptFailed)
+		}
+
 		cipherDataBytes := encryptedDataOut[:encryptedDataOutN][aes.BlockSize:]
 		nonceBytes := encryptedDataOut[:encryptedDataOutN][:aes.BlockSize]
 
@@ -506,16 +646,20 @@ func Decrypted(alg AesAlg, key string, encryptedData []byte) (string, error) {
 			return "", err
 		}
 
+		if encryptedDataOutN < aesGCM.NonceSize() {
+			return "", fmt.Errorf("%w: ciphertext too short", ErrDecryptFailed)
+		}
+
 		cipherDataBytes := encryptedDataOut[:encryptedDataOutN][aesGCM.NonceSize():]
 		nonceBytes := encryptedDataOut[:encryptedDataOutN][:aesGCM.NonceSize()]
 
 		plainDataBytes, err := aesGCM.Open(nil, nonceBytes, cipherDataBytes, nil)
 		if err != nil {
-			return "", err
+			return "", authError(err)
 		}
 
 		return string(plainDataBytes), nil
 	}
 
-	return "", errors.New("decrypt process failed")
+	return "", fmt.Errorf("%w: unsupported algorithm %q", ErrDecryptFailed, alg)
 }
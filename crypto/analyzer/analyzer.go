@@ -0,0 +1,150 @@
+// Package analyzer turns values into the tokens of blind indexes.
+//
+// The write side (building heap tokens) and the query side (searching them)
+// must analyze values the same way, or equal values hash differently. Pick an
+// analyzer per column with the analyzer struct tag and pass the same name
+// when searching.
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrUnknownAnalyzer = errors.New("unknown analyzer")
+
+// Analyzer normalizes a value, splits it into tokens and filters the tokens.
+type Analyzer struct {
+	// Normalize runs in order on the whole value.
+	Normalize []func(string) string
+
+	// Split cuts the normalized value into tokens, Words when nil.
+	Split func(string) []string
+
+	// Filter runs in order on every token; a filter returning "" drops it.
+	Filter []func(string) string
+}
+
+// Normalized returns s after the Normalize functions, the form tokens are
+// split from.
+func (a *Analyzer) Normalized(s string) string {
+	for _, normalize := range a.Normalize {
+		s = normalize(s)
+	}
+	return s
+}
+
+// Analyze returns the distinct tokens of s in order of first occurrence.
+func (a *Analyzer) Analyze(s string) []string {
+	s = a.Normalized(s)
+
+	split := a.Split
+	if split == nil {
+		split = Words
+	}
+
+	var tokens []string
+	seen := make(map[string]struct{})
+	for _, token := range split(s) {
+		for _, filter := range a.Filter {
+			if token = filter(token); token == "" {
+				break
+			}
+		}
+
+		if token == "" {
+			continue
+		}
+		if _, ok := seen[token]; ok {
+			continue
+		}
+		seen[token] = struct{}{}
+		tokens = append(tokens, token)
+	}
+
+	return tokens
+}
+
+// NFKC applies Unicode compatibility composition, so e.g. full-width letters
+// and ligatures match their plain forms.
+func NFKC(s string) string {
+	return norm.NFKC.String(s)
+}
+
+var folder = cases.Fold()
+
+// Fold applies Unicode case folding, a locale independent lower-casing that
+// also maps forms such as ß to ss.
+func Fold(s string) string {
+	return folder.String(s)
+}
+
+// Words splits s at every rune that is neither a letter, a mark nor a digit,
+// in any script.
+func Words(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsDigit(r)
+	})
+}
+
+// StopWords returns a filter dropping the given words, compared after
+// folding.
+func StopWords(words ...string) func(string) string {
+	stop := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		stop[Fold(NFKC(w))] = struct{}{}
+	}
+
+	return func(token string) string {
+		if _, ok := stop[token]; ok {
+			return ""
+		}
+		return token
+	}
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]*Analyzer)
+)
+
+// Register makes an analyzer available under name to the analyzer struct tag.
+// Registering an existing name replaces it.
+func Register(name string, a *Analyzer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	registry[name] = a
+}
+
+// Get returns the analyzer registered under name.
+func Get(name string) (*Analyzer, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	a, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAnalyzer, name)
+	}
+	return a, nil
+}
+
+// Names returns the registered analyzer names in sorted order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
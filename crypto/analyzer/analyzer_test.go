@@ -0,0 +1,48 @@
+package analyzer
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestBuiltins(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{Standard, "Zoë Ñúñez", []string{"zoë", "ñúñez"}},
+		{Standard, "Budi  budi BUDI", []string{"budi"}},
+		{Standard, "ＡＢＣ 123", []string{"abc", "123"}},
+		{Email, "Budi.S@Mail.com", []string{"budi", "s", "mail", "com"}},
+		{Phone, "0812-3456-7890", []string{"+6281234567890"}},
+		{Phone, "+62 812 3456 7890", []string{"+6281234567890"}},
+		{Digits, "12.345.678.9-012.345", []string{"123456789012345"}},
+	} {
+		a, err := Get(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Analyze(tt.value); !slices.Equal(got, tt.want) {
+			t.Errorf("%s(%q) = %q, want %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	a := &Analyzer{
+		Normalize: []func(string) string{Fold},
+		Filter:    []func(string) string{StopWords("bin", "binti")},
+	}
+
+	if got, want := a.Analyze("Ahmad bin Ali"), []string{"ahmad", "ali"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGetUnknown(t *testing.T) {
+	if _, err := Get("no-such-analyzer"); !errors.Is(err, ErrUnknownAnalyzer) {
+		t.Errorf("got %v, want %v", err, ErrUnknownAnalyzer)
+	}
+}
@@ -0,0 +1,78 @@
+package analyzer
+
+import "strings"
+
+// Built-in analyzer names.
+const (
+	// Standard folds case after NFKC and splits at anything but letters and
+	// digits of any script, e.g. "Zoë Ñúñez" gives zoë, ñúñez.
+	Standard = "standard"
+
+	// Email is Standard, keeping "budi.s@mail.com" as budi, s, mail, com.
+	Email = "email"
+
+	// Phone canonicalizes numbers to E.164 with Indonesia as default country,
+	// so 0812-3456-7890 and +62 812 3456 7890 give the single token
+	// +6281234567890.
+	Phone = "phone"
+
+	// Digits keeps only the digits of the value as a single token, for
+	// identifiers such as NIK and NPWP written with separators.
+	Digits = "digits"
+)
+
+func init() {
+	standard := &Analyzer{Normalize: []func(string) string{NFKC, Fold}}
+
+	Register(Standard, standard)
+	Register(Email, standard)
+	// Phone and Digits canonicalize in Normalize rather than Split, so
+	// prefixes of a value are normalized to prefixes of its token.
+	Register(Phone, &Analyzer{
+		Normalize: []func(string) string{NFKC, E164("62")},
+		Split:     whole,
+	})
+	Register(Digits, &Analyzer{
+		Normalize: []func(string) string{NFKC, digitsOnly},
+		Split:     whole,
+	})
+}
+
+// whole is a splitter yielding the value as the only token.
+func whole(s string) []string {
+	return []string{s}
+}
+
+func digitsOnly(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// E164 returns a normalizer canonicalizing phone numbers to E.164, prefixing
+// national numbers starting with 0 with the calling code country. Values
+// without digits become "".
+func E164(country string) func(string) string {
+	return func(s string) string {
+		s = strings.TrimSpace(s)
+		international := strings.HasPrefix(s, "+")
+
+		digits := digitsOnly(s)
+		switch {
+		case digits == "":
+			return ""
+		case international:
+		case strings.HasPrefix(digits, "00"):
+			digits = digits[2:]
+		case strings.HasPrefix(digits, "0"):
+			digits = country + digits[1:]
+		case !strings.HasPrefix(digits, country):
+			digits = country + digits
+		}
+
+		return "+" + digits
+	}
+}
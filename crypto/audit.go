@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"context"
+
+	"github.com/dyaksa/encryption-pii/crypto/metadata"
+)
+
+const (
+	AuditEncrypt        = "encrypt"
+	AuditDecrypt        = "decrypt"
+	AuditBindHeap       = "bind_heap"
+	AuditSearchContents = "search_contents"
+	AuditSearchPrefix   = "search_prefix"
+	AuditGCHeap         = "gc_heap"
+	AuditPurgeSubject   = "purge_subject"
+	AuditReindex        = "reindex"
+	AuditEncryptColumn  = "encrypt_column"
+)
+
+// AuditEvent describes one PII operation together with the metadata carried
+// by the context it ran with.
+type AuditEvent struct {
+	Operation string
+	Table     string
+	Metadata  metadata.Metadata
+	Err       error
+}
+
+// Auditor receives an event for every context aware operation.
+type Auditor func(ctx context.Context, event AuditEvent)
+
+// WithAuditor registers an Auditor called by the context aware APIs.
+func WithAuditor(auditor Auditor) Opts {
+	return func(c *Crypto) error {
+		c.auditor = auditor
+		return nil
+	}
+}
+
+func (c *Crypto) audit(ctx context.Context, operation, table string, err error) {
+	if c.auditor == nil {
+		return
+	}
+
+	c.auditor(ctx, AuditEvent{
+		Operation: operation,
+		Table:     table,
+		Metadata:  metadata.FromContext(ctx),
+		Err:       err,
+	})
+}
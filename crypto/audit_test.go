@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/metadata"
+)
+
+func TestAuditReportsCipherOperations(t *testing.T) {
+	var events []AuditEvent
+	c := newTestCrypto(t, WithAuditor(func(ctx context.Context, e AuditEvent) {
+		events = append(events, e)
+	}))
+
+	ctx := metadata.NewContext(context.Background(), metadata.Metadata{Tenant: "acme", Purpose: "kyc", Subject: "42"})
+
+	enc := c.EncryptContext(ctx, "budi@mail.com", aesx.AesGCM)
+	if len(events) != 0 {
+		t.Fatalf("%d events before the value was encrypted", len(events))
+	}
+
+	stored, err := enc.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := c.DecryptContext(ctx, aesx.AesGCM)
+	if err := dec.Scan(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	// change the last hex digit of the GCM tag
+	tampered := append([]byte(nil), stored.([]byte)...)
+	if tampered[len(tampered)-1] == '0' {
+		tampered[len(tampered)-1] = '1'
+	} else {
+		tampered[len(tampered)-1] = '0'
+	}
+	scanErr := dec.Scan(tampered)
+	if !errors.Is(scanErr, ErrAuthFailed) {
+		t.Fatalf("scanning a tampered value: %v, want ErrAuthFailed", scanErr)
+	}
+
+	want := []struct {
+		operation string
+		err       error
+	}{
+		{AuditEncrypt, nil},
+		{AuditDecrypt, nil},
+		{AuditDecrypt, scanErr},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Operation != w.operation || e.Err != w.err {
+			t.Errorf("event %d: %s with %v, want %s with %v", i, e.Operation, e.Err, w.operation, w.err)
+		}
+		if e.Metadata != (metadata.Metadata{Tenant: "acme", Purpose: "kyc", Subject: "42"}) {
+			t.Errorf("event %d: metadata %+v", i, e.Metadata)
+		}
+	}
+}
+
+func TestMetadataContext(t *testing.T) {
+	ctx := context.Background()
+	if md := metadata.FromContext(ctx); md != (metadata.Metadata{}) {
+		t.Fatalf("metadata %+v without any set", md)
+	}
+
+	ctx = metadata.NewContext(ctx, metadata.Metadata{Tenant: "acme", Purpose: "kyc"})
+	ctx = metadata.WithSubject(ctx, "42")
+	ctx = metadata.WithPurpose(ctx, "support")
+
+	want := metadata.Metadata{Tenant: "acme", Purpose: "support", Subject: "42"}
+	if md := metadata.FromContext(ctx); md != want {
+		t.Fatalf("metadata %+v, want %+v", md, want)
+	}
+}
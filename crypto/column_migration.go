@@ -0,0 +1,486 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/job"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+)
+
+// Column migrations
+//
+// ColumnMigration moves the values of a plaintext column to an encrypted
+// one. In place the ciphertext replaces the plaintext, which needs
+// aesx.AesGCM to tell migrated rows apart, a bytea column, as ciphertexts
+// are stored as bytes like every encrypted column, and a maintenance window,
+// as the application cannot read both forms. With a shadow column the move
+// runs without downtime:
+//
+//  1. add the shadow and bidx columns and deploy with PhaseDualWrite, so
+//     new writes fill both columns through DualWrite;
+//  2. backfill the older rows with EncryptColumn and check them with
+//     VerifyColumn;
+//  3. deploy with PhaseDualRead, reading the shadow column;
+//  4. deploy with PhaseEncrypted and drop the plaintext column.
+
+// DefaultVerifySample is the number of rows VerifyColumn checks by default.
+const DefaultVerifySample = 100
+
+// ErrVerifyFailed is returned by VerifyColumn for rows that do not round-trip.
+var ErrVerifyFailed = errors.New("encrypted column does not match")
+
+// ColumnMigration describes a plaintext column to encrypt. Table, Key,
+// BatchSize, Checkpoints, DryRun and Progress drive the backfill.
+type ColumnMigration struct {
+	job.Config
+
+	// Column holds the plaintext. Target receives the ciphertext, Column
+	// itself when empty.
+	Column string
+	Target string
+	Alg    aesx.AesAlg
+
+	// BidxColumn receives the heap tokens of the value written to
+	// HeapTable, as for a field tagged txt_heap_table. Both are optional.
+	BidxColumn string
+	HeapTable  string
+	Analyzer   string
+}
+
+func (m ColumnMigration) target() string {
+	if m.Target == "" {
+		return m.Column
+	}
+	return m.Target
+}
+
+func (m ColumnMigration) inPlace() bool {
+	return m.target() == m.Column
+}
+
+func (m ColumnMigration) alg() aesx.AesAlg {
+	if m.Alg == "" {
+		return aesx.AesGCM
+	}
+	return m.Alg
+}
+
+func (m ColumnMigration) key() string {
+	if m.Key == "" {
+		return job.DefaultKey
+	}
+	return m.Key
+}
+
+func (m ColumnMigration) validate() error {
+	if m.Column == "" {
+		return errors.New("column migration needs a column")
+	}
+
+	if m.inPlace() && m.alg() != aesx.AesGCM {
+		return fmt.Errorf("in place column migrations need %s to tell migrated rows apart", aesx.AesGCM)
+	}
+
+	if (m.BidxColumn == "") != (m.HeapTable == "") {
+		return errors.New("column migration needs both a bidx column and a heap table, or neither")
+	}
+
+	return nil
+}
+
+// writeColumns returns the columns written for an encrypted value.
+func (m ColumnMigration) writeColumns() []string {
+	columns := []string{m.target()}
+	if m.BidxColumn != "" {
+		columns = append(columns, m.BidxColumn)
+	}
+	return columns
+}
+
+// encryptValue returns the arguments of the writeColumns of value and its
+// heap tokens.
+func (c *Crypto) encryptValue(m ColumnMigration, value sql.NullString) ([]interface{}, []TextHeap, error) {
+	if !value.Valid {
+		if m.BidxColumn != "" {
+			return []interface{}{nil, nil}, nil, nil
+		}
+		return []interface{}{nil}, nil, nil
+	}
+
+	encrypted, err := c.Encrypt(value.String, m.alg()).Value()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+
+	if m.BidxColumn == "" {
+		return []interface{}{encrypted}, nil, nil
+	}
+
+	if _, err := c.heapTable(m.HeapTable); err != nil {
+		return nil, nil, err
+	}
+
+	bidx, heaps, err := c.buildHeap(value.String, m.HeapTable, m.Analyzer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build heap: %w", err)
+	}
+
+	return []interface{}{encrypted, bidx}, heaps, nil
+}
+
+// saveColumnHeap writes the heap tokens of a row and, with WithHeapRefs,
+// its references.
+func (c *Crypto) saveColumnHeap(ctx context.Context, tx *sql.Tx, m ColumnMigration, rowID string, heaps []TextHeap) error {
+	if m.BidxColumn == "" {
+		return nil
+	}
+
+	if c.heapRefs && rowID != "" {
+		if err := c.saveHeapRefs(ctx, tx, m.Table, rowID, []heapColumn{{name: m.BidxColumn, heaps: heaps}}); err != nil {
+			return err
+		}
+	}
+
+	return c.writeHeapTx(ctx, tx, heaps)
+}
+
+// EncryptColumn encrypts the plaintext of every row of m.Table into the
+// target column and fills the bidx column and heap table. Rows whose shadow
+// column is already set, e.g. by DualWrite, are left alone.
+func (c *Crypto) EncryptColumn(ctx context.Context, db *sql.DB, m ColumnMigration) (stats job.Stats, err error) {
+	defer func() { c.audit(ctx, AuditEncryptColumn, m.Table, err) }()
+
+	if err := m.validate(); err != nil {
+		return stats, err
+	}
+
+	columns := []string{m.key(), m.Column}
+	if !m.inPlace() {
+		columns = append(columns, m.target())
+	}
+
+	update, err := job.Update(m.Table, m.key(), m.writeColumns())
+	if err != nil {
+		return stats, err
+	}
+
+	if m.inPlace() {
+		if err := checkColumnType(ctx, db, m.Table, m.Column, "bytea"); err != nil {
+			return stats, fmt.Errorf("in place column migrations need a bytea column, converted first with ALTER COLUMN ... TYPE bytea USING convert_to(%s, 'UTF8'): %w", m.Column, err)
+		}
+	}
+
+	return job.Run(ctx, db, m.Config, func(ctx context.Context, tx *sql.Tx, cursor string, limit int) (string, int, error) {
+		query, args, err := job.Page(m.Table, m.key(), columns, cursor, limit)
+		if err != nil {
+			return "", 0, err
+		}
+
+		rows, err := c.pendingColumnRows(ctx, tx, m, query, args)
+		if err != nil || len(rows) == 0 {
+			return "", 0, err
+		}
+
+		for _, r := range rows {
+			if r.done {
+				continue
+			}
+
+			args, heaps, err := c.encryptValue(m, r.value)
+			if err != nil {
+				return "", 0, fmt.Errorf("row %s: %w", r.key, err)
+			}
+
+			if m.DryRun {
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, update, append(args, r.key)...); err != nil {
+				return "", 0, fmt.Errorf("failed to update row %s: %w", r.key, err)
+			}
+
+			if err := c.saveColumnHeap(ctx, tx, m, r.key, heaps); err != nil {
+				return "", 0, fmt.Errorf("row %s: %w", r.key, err)
+			}
+		}
+
+		return rows[len(rows)-1].key, len(rows), nil
+	})
+}
+
+// checkColumnType returns an error unless column of table is of type want.
+func checkColumnType(ctx context.Context, db *sql.DB, table, column, want string) error {
+	quoted, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return err
+	}
+
+	var got string
+	err = db.QueryRowContext(ctx, `SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+WHERE attrelid = to_regclass($1) AND attname = $2 AND attnum > 0 AND NOT attisdropped`, quoted, strings.ToLower(column)).Scan(&got)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no column %s.%s", table, column)
+	}
+	if err != nil {
+		return err
+	}
+
+	if got != want {
+		return fmt.Errorf("%s.%s is %s", table, column, got)
+	}
+	return nil
+}
+
+type columnRow struct {
+	key   string
+	value sql.NullString
+	done  bool
+}
+
+// pendingColumnRows reads a page of rows, marking the ones already
+// encrypted as done.
+func (c *Crypto) pendingColumnRows(ctx context.Context, tx *sql.Tx, m ColumnMigration, query string, args []interface{}) ([]columnRow, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []columnRow
+	for rows.Next() {
+		var (
+			r      columnRow
+			target []byte
+		)
+		dest := []interface{}{&r.key, &r.value}
+		if !m.inPlace() {
+			dest = append(dest, &target)
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		if m.inPlace() {
+			probe := c.Decrypt(aesx.AesGCM)
+			r.done = r.value.Valid && probe.Scan([]byte(r.value.String)) == nil
+		} else {
+			r.done = target != nil
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// VerifyColumn decrypts the target column of sample random rows, by default
+// DefaultVerifySample, and checks it against the plaintext column, when
+// kept, and the bidx column against the value. It returns the number of
+// rows checked; mismatches are reported as ErrVerifyFailed with the keys of
+// the rows.
+func (c *Crypto) VerifyColumn(ctx context.Context, db *sql.DB, m ColumnMigration, sample int) (checked int, err error) {
+	if err := m.validate(); err != nil {
+		return 0, err
+	}
+
+	if sample <= 0 {
+		sample = DefaultVerifySample
+	}
+
+	columns := []string{m.key(), m.target()}
+	if !m.inPlace() {
+		columns = append(columns, m.Column)
+	}
+	if m.BidxColumn != "" {
+		columns = append(columns, m.BidxColumn)
+	}
+
+	table, err := sqlsafe.QuoteIdent(m.Table)
+	if err != nil {
+		return 0, err
+	}
+
+	quoted, err := sqlsafe.QuoteIdents(columns...)
+	if err != nil {
+		return 0, err
+	}
+
+	query := "SELECT " + strings.Join(quoted, ", ") + " FROM " + table + " WHERE " + quoted[1] + " IS NOT NULL ORDER BY random() LIMIT $1"
+	rows, err := db.QueryContext(ctx, query, sample)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var bad []string
+	for rows.Next() {
+		checked++
+
+		var (
+			key         string
+			plain, bidx sql.NullString
+		)
+		encrypted := c.Decrypt(m.alg())
+		dest := []interface{}{&key, &encrypted}
+		if !m.inPlace() {
+			dest = append(dest, &plain)
+		}
+		if m.BidxColumn != "" {
+			dest = append(dest, &bidx)
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			if errors.Is(err, ErrDecryptFailed) {
+				bad = append(bad, key)
+				continue
+			}
+			return checked, err
+		}
+
+		if !m.inPlace() && plain.Valid && plain.String != encrypted.To() {
+			bad = append(bad, key)
+			continue
+		}
+
+		if m.BidxColumn != "" {
+			want, _, err := c.buildHeap(encrypted.To(), m.HeapTable, m.Analyzer)
+			if err != nil {
+				return checked, err
+			}
+			if bidx.String != want {
+				bad = append(bad, key)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return checked, err
+	}
+
+	if len(bad) > 0 {
+		return checked, fmt.Errorf("%w: %d of the sampled rows of %s.%s, keys %s", ErrVerifyFailed, len(bad), m.Table, m.target(), strings.Join(bad, ", "))
+	}
+	return checked, nil
+}
+
+// ColumnPhase is the stage an application is in while a plaintext column
+// moves to a shadow column.
+type ColumnPhase int
+
+const (
+	// PhaseDualWrite writes both columns and reads the plaintext one.
+	PhaseDualWrite ColumnPhase = iota + 1
+
+	// PhaseDualRead writes both columns and reads the shadow one, falling
+	// back to the plaintext for rows not migrated yet.
+	PhaseDualRead
+
+	// PhaseEncrypted only writes and reads the shadow column.
+	PhaseEncrypted
+)
+
+func (p ColumnPhase) validate(m ColumnMigration) error {
+	switch p {
+	case PhaseDualWrite, PhaseDualRead, PhaseEncrypted:
+	default:
+		return fmt.Errorf("invalid column phase %d", p)
+	}
+
+	if m.inPlace() {
+		return errors.New("dual writes and reads need a shadow column")
+	}
+	return nil
+}
+
+// DualWrite returns the columns of m to write value to in phase and their
+// arguments, for an INSERT or UPDATE of the application, and writes the
+// heap tokens of value like BindHeapTx. rowID is needed for the heap
+// references kept with WithHeapRefs.
+func (c *Crypto) DualWrite(ctx context.Context, tx *sql.Tx, m ColumnMigration, phase ColumnPhase, rowID string, value sql.NullString) (columns []string, args []interface{}, err error) {
+	if err := phase.validate(m); err != nil {
+		return nil, nil, err
+	}
+
+	args, heaps, err := c.encryptValue(m, value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := c.saveColumnHeap(ctx, tx, m, rowID, heaps); err != nil {
+		return nil, nil, err
+	}
+
+	columns = m.writeColumns()
+	if phase != PhaseEncrypted {
+		columns = append(columns, m.Column)
+		args = append(args, value)
+	}
+	return columns, args, nil
+}
+
+// DualValue reads a column of m in a phase: select ReadColumns and scan
+// into Dest, then take Get. It may be reused across rows as long as Dest is
+// called for each of them.
+type DualValue struct {
+	phase  ColumnPhase
+	m      ColumnMigration
+	cipher types.AESCipher
+
+	Plain     sql.NullString
+	Encrypted sql.Null[types.AESCipher]
+}
+
+// DualValue returns a DualValue reading m in phase.
+func (c *Crypto) DualValue(m ColumnMigration, phase ColumnPhase) (*DualValue, error) {
+	if err := phase.validate(m); err != nil {
+		return nil, err
+	}
+
+	return &DualValue{
+		phase:  phase,
+		m:      m,
+		cipher: c.Decrypt(m.alg()),
+	}, nil
+}
+
+// ReadColumns returns the columns to select, in the order of Dest.
+func (d *DualValue) ReadColumns() []string {
+	switch d.phase {
+	case PhaseDualWrite:
+		return []string{d.m.Column}
+	case PhaseDualRead:
+		return []string{d.m.target(), d.m.Column}
+	default:
+		return []string{d.m.target()}
+	}
+}
+
+// Dest returns the scan destinations of ReadColumns. Scanning a NULL resets
+// Encrypted to a cipher without keys, so it is bound again here.
+func (d *DualValue) Dest() []interface{} {
+	d.Plain = sql.NullString{}
+	d.Encrypted = sql.Null[types.AESCipher]{V: d.cipher}
+
+	switch d.phase {
+	case PhaseDualWrite:
+		return []interface{}{&d.Plain}
+	case PhaseDualRead:
+		return []interface{}{&d.Encrypted, &d.Plain}
+	default:
+		return []interface{}{&d.Encrypted}
+	}
+}
+
+// Get returns the value read.
+func (d *DualValue) Get() sql.NullString {
+	if d.phase == PhaseDualWrite || (d.phase == PhaseDualRead && !d.Encrypted.Valid) {
+		return d.Plain
+	}
+
+	return sql.NullString{String: d.Encrypted.V.To(), Valid: d.Encrypted.Valid}
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/job"
+)
+
+func TestDualValueReuse(t *testing.T) {
+	c := newTestCrypto(t)
+	m := ColumnMigration{Column: "email", Target: "email_enc"}
+
+	d, err := c.DualValue(m, PhaseDualRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := c.Encrypt("budi@example.com", aesx.AesGCM).Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, row := range []struct {
+		encrypted any
+		plain     any
+		want      sql.NullString
+	}{
+		{nil, "old@example.com", sql.NullString{String: "old@example.com", Valid: true}},
+		{encrypted, "budi@example.com", sql.NullString{String: "budi@example.com", Valid: true}},
+		{nil, nil, sql.NullString{}},
+		{encrypted, nil, sql.NullString{String: "budi@example.com", Valid: true}},
+	} {
+		dest := d.Dest()
+		if err := dest[0].(sql.Scanner).Scan(row.encrypted); err != nil {
+			t.Fatalf("row %d: %v", i, err)
+		}
+		if err := dest[1].(sql.Scanner).Scan(row.plain); err != nil {
+			t.Fatalf("row %d: %v", i, err)
+		}
+
+		if got := d.Get(); got != row.want {
+			t.Fatalf("row %d: got %+v, want %+v", i, got, row.want)
+		}
+	}
+}
+
+// typeDriver answers every query with the single value columnTypeRow, or no
+// row when it is empty.
+type typeDriver struct{}
+
+var columnTypeRow string
+
+type typeConn struct{}
+type typeStmt struct{}
+type typeRows struct{ done bool }
+
+func (typeDriver) Open(string) (driver.Conn, error)  { return typeConn{}, nil }
+func (typeConn) Prepare(string) (driver.Stmt, error) { return typeStmt{}, nil }
+func (typeConn) Close() error                        { return nil }
+func (typeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+func (typeStmt) Close() error                        { return nil }
+func (typeStmt) NumInput() int                       { return -1 }
+func (typeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (typeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &typeRows{done: columnTypeRow == ""}, nil
+}
+func (*typeRows) Columns() []string { return []string{"format_type"} }
+func (*typeRows) Close() error      { return nil }
+func (r *typeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	dest[0], r.done = columnTypeRow, true
+	return nil
+}
+
+func init() { sql.Register("crypto_type_test", typeDriver{}) }
+
+func TestInPlaceNeedsBytea(t *testing.T) {
+	c := newTestCrypto(t)
+
+	db, err := sql.Open("crypto_type_test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		columnType string
+		want       string
+	}{
+		{"text", "people.email is text"},
+		{"character varying(255)", "people.email is character varying(255)"},
+		{"", "no column people.email"},
+	} {
+		columnTypeRow = tt.columnType
+		_, err := c.EncryptColumn(context.Background(), db, ColumnMigration{Config: job.Config{Table: "people"}, Column: "email"})
+		if err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+			t.Fatalf("column of type %q: got error %v, want one ending in %q", tt.columnType, err, tt.want)
+		}
+	}
+
+	columnTypeRow = "bytea"
+	if err := checkColumnType(context.Background(), db, "people", "email", "bytea"); err != nil {
+		t.Fatal(err)
+	}
+}
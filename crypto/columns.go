@@ -0,0 +1,208 @@
+package crypto
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// Column mapping
+//
+// QueryContext, QueryLike and Repository scan the columns of a result set
+// into the struct fields of the same name, in any order:
+//
+//	type Person struct {
+//		Audit                       // fields of embedded structs are mapped too
+//		ID    int64           `db:"id"`
+//		Name  types.AESCipher `db:"name"`
+//		Notes string          `db:"-"` // never mapped
+//		Email types.AESCipher         // column email
+//	}
+//
+// A field without db tag maps to its lower-cased name. Columns are matched
+// regardless of case, and a column without field fails the query with
+// ErrUnmappedColumns rather than being dropped. Structs without any db tag
+// whose columns do not all match keep the former positional scan, column i
+// into field i, when there are as many columns as fields.
+
+// ErrUnmappedColumns is returned when a result set has columns no field of
+// the entity maps to.
+var ErrUnmappedColumns = errors.New("columns have no field")
+
+// structColumn is a field of an entity mapped to a column. The Index of field
+// is the path from the entity, through embedded structs.
+type structColumn struct {
+	name   string
+	field  reflect.StructField
+	tagged bool
+}
+
+type structColumns struct {
+	columns []structColumn
+	byName  map[string]int
+	err     error
+
+	// tagged tells whether any field has a db tag.
+	tagged bool
+}
+
+var structColumnsCache sync.Map
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// columnsOf returns the columns of the struct type t, in field order.
+func columnsOf(t reflect.Type) (*structColumns, error) {
+	if cached, ok := structColumnsCache.Load(t); ok {
+		sc := cached.(*structColumns)
+		return sc, sc.err
+	}
+
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("entity must be a struct, got %s", t)
+	}
+
+	type candidate struct {
+		structColumn
+		depth int
+	}
+	var (
+		candidates []candidate
+		tagged     bool
+	)
+	shallowest := make(map[string]int)
+
+	var walk func(t reflect.Type, index []int, depth int)
+	walk = func(t reflect.Type, index []int, depth int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("db")
+			tagged = tagged || tag != ""
+			if tag == "-" {
+				continue
+			}
+
+			field.Index = append(append([]int(nil), index...), i)
+			if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct && !reflect.PointerTo(field.Type).Implements(scannerType) {
+				walk(field.Type, field.Index, depth+1)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+
+			name := strings.ToLower(columnName(field))
+			if d, ok := shallowest[name]; !ok || depth < d {
+				shallowest[name] = depth
+			}
+			candidates = append(candidates, candidate{structColumn{name: name, field: field, tagged: tag != ""}, depth})
+		}
+	}
+	walk(t, nil, 0)
+
+	// Like encoding/json, the shallowest field wins and fields at the same
+	// depth are ambiguous.
+	sc := &structColumns{byName: make(map[string]int), tagged: tagged}
+	for _, c := range candidates {
+		if c.depth != shallowest[c.name] {
+			continue
+		}
+		if j, ok := sc.byName[c.name]; ok {
+			if sc.err == nil {
+				sc.err = fmt.Errorf("column %s maps to fields %s and %s of %s", c.name, fieldPath(t, sc.columns[j].field.Index), fieldPath(t, c.field.Index), t)
+			}
+			continue
+		}
+		sc.byName[c.name] = len(sc.columns)
+		sc.columns = append(sc.columns, c.structColumn)
+	}
+
+	structColumnsCache.Store(t, sc)
+	return sc, sc.err
+}
+
+// fieldPath returns the selector of the field at index of t, like A.B.Name.
+func fieldPath(t reflect.Type, index []int) string {
+	names := make([]string, len(index))
+	for i := range index {
+		names[i] = t.FieldByIndex(index[:i+1]).Name
+	}
+	return strings.Join(names, ".")
+}
+
+// ColumnMap maps the columns of a result set to the fields of an entity.
+type ColumnMap [][]int
+
+// MapColumns maps the columns of rows to the fields of T, failing with
+// ErrUnmappedColumns naming the columns without field.
+func MapColumns[T Entity](rows *sql.Rows) (ColumnMap, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	return mapColumns(reflect.TypeOf((*T)(nil)).Elem(), columns)
+}
+
+func mapColumns(t reflect.Type, columns []string) (ColumnMap, error) {
+	sc, err := columnsOf(t)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(ColumnMap, len(columns))
+	var unmapped []string
+	for i, column := range columns {
+		j, ok := sc.byName[strings.ToLower(column)]
+		if !ok {
+			unmapped = append(unmapped, column)
+			continue
+		}
+		m[i] = sc.columns[j].field.Index
+	}
+
+	if len(unmapped) > 0 {
+		if !sc.tagged && len(columns) == t.NumField() {
+			return positionalColumns(t)
+		}
+		return nil, fmt.Errorf("%w in %s: %s", ErrUnmappedColumns, t, strings.Join(unmapped, ", "))
+	}
+	return m, nil
+}
+
+// positionalColumns maps column i to field i of t, as StructToInterfaceScan
+// did.
+func positionalColumns(t reflect.Type) (ColumnMap, error) {
+	m := make(ColumnMap, t.NumField())
+	for i := range m {
+		if !t.Field(i).IsExported() {
+			return nil, fmt.Errorf("%w in %s: field %s is unexported", ErrUnmappedColumns, t, t.Field(i).Name)
+		}
+		m[i] = []int{i}
+	}
+	return m, nil
+}
+
+// Scan scans the current row of rows into entity, a pointer to a struct.
+func (m ColumnMap) Scan(rows *sql.Rows, entity interface{}) error {
+	v := reflect.ValueOf(entity).Elem()
+
+	dest := make([]interface{}, len(m))
+	for i, index := range m {
+		dest[i] = scanDest(v.FieldByIndex(index))
+	}
+
+	return rows.Scan(dest...)
+}
+
+// scanDest returns where to scan the column of field f.
+func scanDest(f reflect.Value) interface{} {
+	if _, ok := f.Addr().Interface().(sql.Scanner); !ok && f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String {
+		return pq.Array(f.Addr().Interface())
+	}
+	return f.Addr().Interface()
+}
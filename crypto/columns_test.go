@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/types"
+)
+
+type untaggedPerson struct {
+	ID       int64
+	FullName types.AESCipher
+}
+
+type taggedPerson struct {
+	ID       int64           `db:"id"`
+	FullName types.AESCipher `db:"full_name"`
+	Notes    string          `db:"-"`
+}
+
+type embeddedPerson struct {
+	taggedPerson
+	Email types.AESCipher
+}
+
+func TestMapColumns(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		entity  any
+		columns []string
+		want    ColumnMap
+	}{
+		{"by name", taggedPerson{}, []string{"FULL_NAME", "id"}, ColumnMap{{1}, {0}}},
+		{"subset", taggedPerson{}, []string{"full_name"}, ColumnMap{{1}}},
+		{"embedded", embeddedPerson{}, []string{"email", "id"}, ColumnMap{{1}, {0, 0}}},
+		{"untagged by name", untaggedPerson{}, []string{"fullname", "id"}, ColumnMap{{1}, {0}}},
+		{"untagged by position", untaggedPerson{}, []string{"id", "full_name"}, ColumnMap{{0}, {1}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mapColumns(reflect.TypeOf(tt.entity), tt.columns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapColumnsUnmapped(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		entity  any
+		columns []string
+	}{
+		{"tagged", taggedPerson{}, []string{"id", "fullname"}},
+		{"skipped field", taggedPerson{}, []string{"notes"}},
+		{"untagged, too few columns", untaggedPerson{}, []string{"full_name"}},
+		{"untagged, too many columns", untaggedPerson{}, []string{"id", "full_name", "email"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := mapColumns(reflect.TypeOf(tt.entity), tt.columns); !errors.Is(err, ErrUnmappedColumns) {
+				t.Fatalf("got %v, want ErrUnmappedColumns", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dyaksa/encryption-pii/crypto/analyzer"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+)
+
+// Compound blind indexes
+//
+// A field tagged compound receives from BindHeap one HMAC over several fields
+// of the entity, for lookups on the combination such as duplicate detection:
+//
+//	Name         types.AESCipher `db:"name"`
+//	BirthDate    types.AESTime   `db:"birth_date"`
+//	NameDobBidx  string          `db:"name_dob_bidx" compound:"Name,BirthDate"`
+//
+// Every part is encoded with a presence byte and its length before hashing,
+// so ("ab", "c") and ("a", "bc") differ and a NULL differs from "". Strings
+// are NFKC normalized, case folded and trimmed. A time at midnight in its own
+// location is a calendar date and hashed as 2006-01-02, so a birth date read
+// in local time matches one searched in UTC; other times are instants taken
+// in UTC. The hash is keyed by the column name, so the same values give
+// unrelated hashes in different compound columns. CompoundHash builds the
+// same hash from search parameters.
+
+// CompoundHash returns the compound index hash of values for column. values
+// are given in the order of the compound tag.
+func (c *Crypto) CompoundHash(column string, values ...any) (string, error) {
+	b := []byte("\x00compound:" + strings.ToLower(column) + "\x00")
+	for i, v := range values {
+		part, ok, err := compoundPart(v)
+		if err != nil {
+			return "", fmt.Errorf("compound %s part %d: %w", column, i, err)
+		}
+
+		if !ok {
+			b = append(b, 0)
+			continue
+		}
+		b = append(b, 1)
+		b = binary.AppendUvarint(b, uint64(len(part)))
+		b = append(b, part...)
+	}
+
+	return c.HashStringErr(string(b))
+}
+
+// CompoundCondition returns a condition matching rows whose compound column
+// holds the hash of values, with its argument numbered from startAt.
+func (c *Crypto) CompoundCondition(column string, startAt int, values ...any) (string, []interface{}, error) {
+	keys, err := c.lookupKeys()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(keys) > 1 {
+		return anyKey(keys, startAt, func(k *Crypto, startAt int) (string, []interface{}, error) {
+			return k.CompoundCondition(column, startAt, values...)
+		})
+	}
+
+	quoted, err := sqlsafe.QuoteIdent(column)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hash, err := c.CompoundHash(column, values...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return quoted + " = " + sqlsafe.Placeholder(startAt), []interface{}{hash}, nil
+}
+
+// compoundPart returns the canonical form of v and whether it is non-NULL.
+func compoundPart(v any) (string, bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", false, nil
+	case types.AESCipher:
+		return canonicalString(v.To()), true, nil
+	case *types.AESCipher:
+		if v == nil {
+			return "", false, nil
+		}
+		return canonicalString(v.To()), true, nil
+	case types.AESInt64:
+		return fmt.Sprint(v.To()), true, nil
+	case *types.AESInt64:
+		if v == nil {
+			return "", false, nil
+		}
+		return fmt.Sprint(v.To()), true, nil
+	case types.AESTime:
+		return timePart(v.To()), true, nil
+	case *types.AESTime:
+		if v == nil {
+			return "", false, nil
+		}
+		return timePart(v.To()), true, nil
+	case types.AESCipherJSON:
+		return jsonPart(v.To())
+	case *types.AESCipherJSON:
+		if v == nil {
+			return "", false, nil
+		}
+		return jsonPart(v.To())
+	case string:
+		return canonicalString(v), true, nil
+	case *string:
+		if v == nil {
+			return "", false, nil
+		}
+		return canonicalString(*v), true, nil
+	case time.Time:
+		return timePart(v), true, nil
+	case *time.Time:
+		if v == nil {
+			return "", false, nil
+		}
+		return timePart(*v), true, nil
+	case types.NullString:
+		return canonicalString(v.String), v.Valid, nil
+	case types.NullTime:
+		return timePart(v.Time), v.Valid, nil
+	case types.NullInt64:
+		return fmt.Sprint(v.Int64), v.Valid, nil
+	case types.NullFloat64:
+		return fmt.Sprint(v.Float64), v.Valid, nil
+	case types.NullBool:
+		return fmt.Sprint(v.Bool), v.Valid, nil
+	case types.NullUuid:
+		return v.UUID.String(), v.Valid, nil
+	case fmt.Stringer:
+		return v.String(), true, nil
+	}
+
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v), true, nil
+	}
+
+	return "", false, fmt.Errorf("unsupported type %T", v)
+}
+
+// timePart returns t as a calendar date when it is midnight in its own
+// location, else as an instant in UTC.
+func timePart(t time.Time) string {
+	if h, m, s := t.Clock(); h == 0 && m == 0 && s == 0 && t.Nanosecond() == 0 {
+		return t.Format(time.DateOnly)
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// jsonPart returns v as JSON, whose object keys encoding/json sorts.
+func jsonPart(v map[string]interface{}) (string, bool, error) {
+	if v == nil {
+		return "", false, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false, err
+	}
+	return string(b), true, nil
+}
+
+func canonicalString(s string) string {
+	return strings.TrimSpace(analyzer.Fold(analyzer.NFKC(s)))
+}
+
+// bindCompound fills the compound tagged field of entity.
+func (c *Crypto) bindCompound(entity reflect.Value, field reflect.StructField) error {
+	target := entity.FieldByName(field.Name)
+	if target.Kind() != reflect.String {
+		return fmt.Errorf("field %s: compound fields must be strings", field.Name)
+	}
+
+	var values []any
+	for _, name := range strings.Split(field.Tag.Get("compound"), ",") {
+		source := entity.FieldByName(strings.TrimSpace(name))
+		if !source.IsValid() {
+			return fmt.Errorf("field %s: no field %q to index", field.Name, name)
+		}
+		values = append(values, source.Interface())
+	}
+
+	hash, err := c.CompoundHash(columnName(field), values...)
+	if err != nil {
+		return fmt.Errorf("failed to hash compound: %w", err)
+	}
+
+	target.SetString(hash)
+	return nil
+}
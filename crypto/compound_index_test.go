@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+)
+
+type compoundPerson struct {
+	Name        types.AESCipher `db:"name"`
+	BirthDate   types.AESTime   `db:"birth_date"`
+	Salary      types.AESInt64  `db:"salary"`
+	NameDobBidx string          `db:"name_dob_bidx" compound:"Name,BirthDate"`
+	NameSalBidx string          `db:"name_sal_bidx" compound:"Name,Salary"`
+}
+
+func TestCompoundIndexOfEncryptedFields(t *testing.T) {
+	c := newTestCrypto(t)
+
+	jakarta := time.FixedZone("WIB", 7*60*60)
+	p := compoundPerson{
+		Name:      c.Encrypt("Budi Santoso", aesx.AesGCM),
+		BirthDate: c.EncryptTime(time.Date(1990, 5, 17, 0, 0, 0, 0, jakarta), aesx.AesGCM),
+		Salary:    c.EncryptInt64(15000000, aesx.AesGCM),
+	}
+	if err := c.BindHeap(&p); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		column string
+		values []any
+		want   string
+	}{
+		{"utc date", "name_dob_bidx", []any{" BUDI santoso", time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)}, p.NameDobBidx},
+		{"local date", "name_dob_bidx", []any{"budi santoso", time.Date(1990, 5, 17, 0, 0, 0, 0, jakarta)}, p.NameDobBidx},
+		{"encrypted values", "name_dob_bidx", []any{p.Name, &p.BirthDate}, p.NameDobBidx},
+		{"int64", "name_sal_bidx", []any{"budi santoso", 15000000}, p.NameSalBidx},
+	}
+	for _, tt := range tests {
+		got, err := c.CompoundHash(tt.column, tt.values...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: hash %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	for _, values := range [][]any{
+		{"budi santoso", time.Date(1990, 5, 18, 0, 0, 0, 0, time.UTC)},
+		{"budi santoso", time.Date(1990, 5, 17, 0, 0, 0, 0, jakarta).UTC()},
+		{"budi santoso", nil},
+	} {
+		got, err := c.CompoundHash("name_dob_bidx", values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == p.NameDobBidx {
+			t.Errorf("%v hashes like 1990-05-17", values)
+		}
+	}
+}
+
+func TestTimePart(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		want string
+	}{
+		{time.Date(1990, 5, 17, 0, 0, 0, 0, time.FixedZone("WIB", 7*60*60)), "1990-05-17"},
+		{time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC), "1990-05-17"},
+		{time.Date(1990, 5, 17, 8, 30, 0, 0, time.FixedZone("WIB", 7*60*60)), "1990-05-17T01:30:00Z"},
+		{time.Date(1990, 5, 17, 0, 0, 0, 1, time.UTC), "1990-05-17T00:00:00.000000001Z"},
+	}
+	for _, tt := range tests {
+		if got := timePart(tt.t); got != tt.want {
+			t.Errorf("timePart(%s) = %s, want %s", tt.t, got, tt.want)
+		}
+	}
+}
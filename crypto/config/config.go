@@ -1,58 +1,235 @@
+// Package config holds the settings used by crypto.New.
+//
+// A Config can be built in code, read from the environment with FromEnv or
+// read from a JSON or YAML file with FromFile. Nothing is loaded implicitly;
+// call LoadDotEnv first to keep reading a .env file.
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/dyaksa/encryption-pii/crypto/core"
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
-func init() {
-	godotenv.Load()
-}
+const DefaultEnvPrefix = "CRYPTO_"
 
+// Environment variable names without prefix.
 const (
-	AesKey  = "CRYPTO_AES_KEY"
-	HmacKey = "CRYPTO_HMAC_KEY"
-
-	Host = "CRYPTO_HEAP_DB_HOST"
-	Port = "CRYPTO_HEAP_DB_PORT"
-	User = "CRYPTO_HEAP_DB_USER"
-	Pass = "CRYPTO_HEAP_DB_PASS"
-	Name = "CRYPTO_HEAP_DB_NAME"
+	EnvAesKey     = "AES_KEY"
+	EnvHmacKey    = "HMAC_KEY"
+	EnvAesKeySize = "AES_KEY_SIZE"
+
+	EnvHost = "HEAP_DB_HOST"
+	EnvPort = "HEAP_DB_PORT"
+	EnvUser = "HEAP_DB_USER"
+	EnvPass = "HEAP_DB_PASS"
+	EnvName = "HEAP_DB_NAME"
 )
 
+// Environment variable names with the default prefix.
+const (
+	AesKey  = DefaultEnvPrefix + EnvAesKey
+	HmacKey = DefaultEnvPrefix + EnvHmacKey
+
+	Host = DefaultEnvPrefix + EnvHost
+	Port = DefaultEnvPrefix + EnvPort
+	User = DefaultEnvPrefix + EnvUser
+	Pass = DefaultEnvPrefix + EnvPass
+	Name = DefaultEnvPrefix + EnvName
+)
+
+// Keys are taken as raw text unless prefixed with "hex:" or "base64:".
 type Config struct {
-	AesKey  string
-	HmacKey string
+	AesKey  string `json:"aes_key" yaml:"aes_key"`
+	HmacKey string `json:"hmac_key" yaml:"hmac_key"`
+
+	// AesKeySize is the expected AES key size in bytes (16, 24 or 32).
+	// Zero accepts any valid size.
+	AesKeySize int `json:"aes_key_size" yaml:"aes_key_size"`
 
-	Host string
-	Port string
-	User string
-	Pass string
-	Name string
+	Host string `json:"heap_db_host" yaml:"heap_db_host"`
+	Port string `json:"heap_db_port" yaml:"heap_db_port"`
+	User string `json:"heap_db_user" yaml:"heap_db_user"`
+	Pass string `json:"heap_db_pass" yaml:"heap_db_pass"`
+	Name string `json:"heap_db_name" yaml:"heap_db_name"`
 }
 
-func getEnv(key string) string {
-	return os.Getenv(key)
+// ValidationError lists every problem found in a Config. It unwraps to the
+// individual problems, so errors.Is matches sentinels such as
+// core.ErrInvalidKeySize.
+type ValidationError struct {
+	Errors []error
 }
 
-func InitConfig() *Config {
-	return &Config{
-		AesKey:  getEnv(AesKey),
-		HmacKey: getEnv(HmacKey),
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		problems[i] = err.Error()
+	}
+	return "invalid crypto config: " + strings.Join(problems, "; ")
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return e.Errors
+}
+
+func (e *ValidationError) add(format string, args ...any) {
+	e.Errors = append(e.Errors, fmt.Errorf(format, args...))
+}
 
-		Host: getEnv(Host),
-		Port: getEnv(Port),
-		User: getEnv(User),
-		Pass: getEnv(Pass),
-		Name: getEnv(Name),
+func (e *ValidationError) err() error {
+	if len(e.Errors) == 0 {
+		return nil
 	}
+	return e
+}
+
+// LoadDotEnv loads environment variables from the given files, ".env" when
+// none are given. Existing variables are not overridden.
+func LoadDotEnv(filenames ...string) error {
+	return godotenv.Load(filenames...)
+}
+
+// FromEnv reads a Config from environment variables named prefix + Env*,
+// e.g. CRYPTO_AES_KEY for the default prefix. Only malformed values are
+// reported here; missing settings are reported by Validate, which crypto.New
+// runs once options had the chance to supply the keys.
+func FromEnv(prefix string) (*Config, error) {
+	cfg, verr := fromEnv(prefix)
+	return cfg, verr.err()
+}
+
+func fromEnv(prefix string) (*Config, *ValidationError) {
+	verr := new(ValidationError)
+	cfg := &Config{
+		AesKey:  os.Getenv(prefix + EnvAesKey),
+		HmacKey: os.Getenv(prefix + EnvHmacKey),
+
+		Host: os.Getenv(prefix + EnvHost),
+		Port: os.Getenv(prefix + EnvPort),
+		User: os.Getenv(prefix + EnvUser),
+		Pass: os.Getenv(prefix + EnvPass),
+		Name: os.Getenv(prefix + EnvName),
+	}
+
+	if size := os.Getenv(prefix + EnvAesKeySize); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			verr.add("%s%s: %q is not a number", prefix, EnvAesKeySize, size)
+		}
+		cfg.AesKeySize = n
+	}
+
+	return cfg, verr
+}
+
+// FromFile reads a Config from a JSON or YAML file, chosen by extension. Like
+// FromEnv it does not validate the result.
+func FromFile(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := new(Config)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(b, cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, cfg)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Validate reports every missing or bad setting. The heap database settings
+// are optional, but once one of them is set the others are required.
+func (c *Config) Validate() error {
+	verr := new(ValidationError)
+
+	if c.AesKey == "" {
+		verr.add("aes key is required")
+	} else if key, err := core.DecodeKey(c.AesKey); err != nil {
+		verr.add("aes key: %w", err)
+	} else if err := core.CheckAESKey(key); err != nil {
+		verr.add("aes key: %w", err)
+	} else if c.AesKeySize != 0 && len(key) != c.AesKeySize {
+		verr.add("aes key: %w: expected %d bytes, got %d", core.ErrInvalidKeySize, c.AesKeySize, len(key))
+	}
+
+	if c.HmacKey == "" {
+		verr.add("hmac key is required")
+	} else if key, err := core.DecodeKey(c.HmacKey); err != nil {
+		verr.add("hmac key: %w", err)
+	} else if err := core.CheckHMACKey(key); err != nil {
+		verr.add("hmac key: %w", err)
+	}
+
+	switch c.AesKeySize {
+	case 0, 16, 24, 32:
+	default:
+		verr.add("aes key size: %w: must be 16, 24 or 32, got %d", core.ErrInvalidKeySize, c.AesKeySize)
+	}
+
+	if c.Host != "" || c.Port != "" || c.User != "" || c.Pass != "" || c.Name != "" {
+		c.validateHeap(verr)
+	}
+
+	return verr.err()
+}
+
+// ValidateHeap reports every missing or bad heap database setting.
+func (c *Config) ValidateHeap() error {
+	verr := new(ValidationError)
+	c.validateHeap(verr)
+	return verr.err()
+}
+
+func (c *Config) validateHeap(verr *ValidationError) {
+	for _, s := range []struct{ name, value string }{
+		{"heap db host", c.Host},
+		{"heap db port", c.Port},
+		{"heap db user", c.User},
+		{"heap db name", c.Name},
+	} {
+		if s.value == "" {
+			verr.add("%s is required", s.name)
+		}
+	}
+
+	if c.Port != "" {
+		if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+			verr.add("heap db port %q is not a valid port", c.Port)
+		}
+	}
+}
+
+// InitConfig reads a Config from the environment with the default prefix,
+// ignoring malformed values.
+//
+// Deprecated: use FromEnv, which reports malformed values, and Validate. Like
+// FromEnv it does not load .env implicitly.
+func InitConfig() *Config {
+	cfg, _ := fromEnv(DefaultEnvPrefix)
+	return cfg
 }
 
 func GetAESKey() string {
-	return getEnv(AesKey)
+	return os.Getenv(AesKey)
 }
 
 func GetHMACKey() string {
-	return getEnv(HmacKey)
+	return os.Getenv(HmacKey)
 }
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/core"
+)
+
+const (
+	testAESKey  = "0123456789abcdef0123456789abcdef"
+	testHMACKey = "hmac-key-of-at-least-thirty-two-bytes"
+)
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("PII_AES_KEY", "hex:000102030405060708090a0b0c0d0e0f")
+	t.Setenv("PII_HMAC_KEY", testHMACKey)
+	t.Setenv("PII_AES_KEY_SIZE", "16")
+	t.Setenv("PII_HEAP_DB_HOST", "localhost")
+	t.Setenv("PII_HEAP_DB_PORT", "5432")
+	t.Setenv("CRYPTO_AES_KEY", "ignored")
+
+	cfg, err := FromEnv("PII_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Config{
+		AesKey:     "hex:000102030405060708090a0b0c0d0e0f",
+		HmacKey:    testHMACKey,
+		AesKeySize: 16,
+		Host:       "localhost",
+		Port:       "5432",
+	}
+	if *cfg != want {
+		t.Fatalf("got %+v, want %+v", *cfg, want)
+	}
+
+	// missing settings are left to Validate
+	if _, err := FromEnv("NONE_"); err != nil {
+		t.Fatalf("empty environment: %v", err)
+	}
+
+	t.Setenv("PII_AES_KEY_SIZE", "sixteen")
+	var verr *ValidationError
+	if _, err := FromEnv("PII_"); !errors.As(err, &verr) || len(verr.Errors) != 1 {
+		t.Fatalf("malformed key size: got %v", err)
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	want := Config{AesKey: testAESKey, HmacKey: testHMACKey, AesKeySize: 32, Host: "db", Port: "5432"}
+
+	for _, tt := range []struct {
+		name    string
+		content string
+		err     bool
+	}{
+		{"crypto.json", `{"aes_key": "` + testAESKey + `", "hmac_key": "` + testHMACKey + `", "aes_key_size": 32, "heap_db_host": "db", "heap_db_port": "5432"}`, false},
+		{"crypto.yaml", "aes_key: " + testAESKey + "\nhmac_key: " + testHMACKey + "\naes_key_size: 32\nheap_db_host: db\nheap_db_port: \"5432\"\n", false},
+		{"crypto.YML", "aes_key: " + testAESKey + "\nhmac_key: " + testHMACKey + "\naes_key_size: 32\nheap_db_host: db\nheap_db_port: \"5432\"\n", false},
+		{"crypto.toml", `aes_key = "x"`, true},
+		{"broken.json", `{"aes_key": `, true},
+	} {
+		path := filepath.Join(t.TempDir(), tt.name)
+		if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := FromFile(path)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: accepted", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if *cfg != want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *cfg, want)
+		}
+	}
+
+	if _, err := FromFile(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Config{AesKey: testAESKey, HmacKey: testHMACKey}
+
+	for _, tt := range []struct {
+		name     string
+		cfg      func(Config) Config
+		problems int
+		is       []error
+	}{
+		{"valid", func(c Config) Config { return c }, 0, nil},
+		{"hex and base64 keys", func(c Config) Config {
+			c.AesKey = "hex:000102030405060708090a0b0c0d0e0f"
+			c.HmacKey = "base64:aG1hYy1rZXktb2YtYXQtbGVhc3QtdGhpcnR5LXR3by1ieXRlcw=="
+			c.AesKeySize = 16
+			return c
+		}, 0, nil},
+		{"missing keys", func(Config) Config { return Config{} }, 2, nil},
+		{"short aes key", func(c Config) Config { c.AesKey = "short"; return c }, 1, []error{core.ErrInvalidKeySize}},
+		{"aes key of another size", func(c Config) Config { c.AesKeySize = 16; return c }, 1, []error{core.ErrInvalidKeySize}},
+		{"bad key size", func(c Config) Config { c.AesKeySize = 20; return c }, 2, []error{core.ErrInvalidKeySize}},
+		{"short hmac key", func(c Config) Config { c.HmacKey = "short"; return c }, 1, []error{core.ErrHMACKeyTooShort}},
+		{"bad hex", func(c Config) Config { c.AesKey = "hex:zz"; return c }, 1, nil},
+		{"every problem at once", func(Config) Config {
+			return Config{AesKey: "short", HmacKey: "short", Host: "db"}
+		}, 5, []error{core.ErrInvalidKeySize, core.ErrHMACKeyTooShort}},
+		{"partial heap settings", func(c Config) Config { c.Host = "db"; c.Port = "99999"; return c }, 3, nil},
+		{"complete heap settings", func(c Config) Config {
+			c.Host, c.Port, c.User, c.Name = "db", "5432", "pii", "pii"
+			return c
+		}, 0, nil},
+	} {
+		cfg := tt.cfg(valid)
+		err := cfg.Validate()
+
+		if tt.problems == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: got %v, want a ValidationError", tt.name, err)
+			continue
+		}
+		if len(verr.Errors) != tt.problems {
+			t.Errorf("%s: %d problems, want %d: %v", tt.name, len(verr.Errors), tt.problems, err)
+		}
+		for _, target := range tt.is {
+			if !errors.Is(err, target) {
+				t.Errorf("%s: %v does not match %v", tt.name, err, target)
+			}
+		}
+	}
+}
+
+func TestValidateHeap(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		cfg      Config
+		problems int
+	}{
+		{"complete", Config{Host: "db", Port: "5432", User: "pii", Name: "pii"}, 0},
+		{"without password", Config{Host: "db", Port: "5432", User: "pii", Name: "pii", Pass: ""}, 0},
+		{"empty", Config{}, 4},
+		{"port not a number", Config{Host: "db", Port: "pg", User: "pii", Name: "pii"}, 1},
+		{"port out of range", Config{Host: "db", Port: "0", User: "pii", Name: "pii"}, 1},
+	} {
+		err := tt.cfg.ValidateHeap()
+		if tt.problems == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+
+		var verr *ValidationError
+		if !errors.As(err, &verr) || len(verr.Errors) != tt.problems {
+			t.Errorf("%s: got %v, want %d problems", tt.name, err, tt.problems)
+		}
+	}
+}
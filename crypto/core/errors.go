@@ -0,0 +1,11 @@
+package core
+
+import "errors"
+
+// Sentinel errors shared by aesx, hmacx and crypto, match them with errors.Is.
+var (
+	ErrInvalidKeySize  = errors.New("invalid key size")
+	ErrHMACKeyTooShort = errors.New("hmac key too short")
+	ErrDecryptFailed   = errors.New("decrypt failed")
+	ErrAuthFailed      = errors.New("message authentication failed")
+)
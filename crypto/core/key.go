@@ -0,0 +1,55 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	KeyPrefixHex    = "hex:"
+	KeyPrefixBase64 = "base64:"
+)
+
+// DecodeKey returns the key material of s. Keys prefixed with "hex:" or
+// "base64:" are decoded, anything else is used as raw text.
+func DecodeKey(s string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(s, KeyPrefixHex):
+		b, err := hex.DecodeString(strings.TrimPrefix(s, KeyPrefixHex))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex key: %w", err)
+		}
+		return b, nil
+	case strings.HasPrefix(s, KeyPrefixBase64):
+		v := strings.TrimPrefix(s, KeyPrefixBase64)
+		for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+			if b, err := enc.DecodeString(v); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid base64 key")
+	default:
+		return []byte(s), nil
+	}
+}
+
+// CheckAESKey reports ErrInvalidKeySize unless key is 16, 24 or 32 bytes long.
+func CheckAESKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("%w: aes key must be 16, 24 or 32 bytes, got %d", ErrInvalidKeySize, len(key))
+	}
+}
+
+// CheckHMACKey reports ErrHMACKeyTooShort for keys shorter than MinHMACKeySize.
+func CheckHMACKey(key []byte) error {
+	if len(key) < MinHMACKeySize {
+		return fmt.Errorf("%w: hmac key must be at least %d bytes, got %d", ErrHMACKeyTooShort, MinHMACKeySize, len(key))
+	}
+
+	return nil
+}
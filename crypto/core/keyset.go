@@ -6,8 +6,9 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/sha512"
-	"errors"
+	"fmt"
 	"hash"
+	"slices"
 )
 
 type (
@@ -28,9 +29,26 @@ type Primitive interface {
 
 type NewPrimitive[T Primitive] func([]byte) (T, error)
 
-type PrimitiveAES struct{ cipher.Block }
+type PrimitiveAES struct {
+	cipher.Block
+
+	fallbacks func() ([]cipher.Block, error)
+}
+
+// Fallbacks returns the ciphers of the other keys of the keyset the primitive
+// was taken from, primary excluded. GCM decryption tries them in turn when
+// the primary key fails authentication.
+func (p PrimitiveAES) Fallbacks() ([]cipher.Block, error) {
+	if p.fallbacks == nil {
+		return nil, nil
+	}
+	return p.fallbacks()
+}
 
 func NewAEAS(key []byte) (p PrimitiveAES, err error) {
+	if err = CheckAESKey(key); err != nil {
+		return PrimitiveAES{}, err
+	}
 	p.Block, err = aes.NewCipher(key)
 	return
 }
@@ -38,34 +56,33 @@ func NewAEAS(key []byte) (p PrimitiveAES, err error) {
 type PrimitiveHMAC struct{ hash.Hash }
 
 func NewHMAC(key []byte) (p PrimitiveHMAC, err error) {
-	if err = checkKeyLen(key); err != nil {
+	if err = CheckHMACKey(key); err != nil {
 		return PrimitiveHMAC{}, err
 	}
 	p.Hash = hmac.New(sha256.New, key)
 	return
 }
 
-const (
-	minHmacKey                 = 32
-	errorHmacKeyLenLessThanMin = "hmac key length not valid"
-)
-
-func checkKeyLen(key []byte) error {
-	if len(key) < minHmacKey {
-		return errors.New(errorHmacKeyLenLessThanMin)
-	}
-
-	return nil
-}
+// MinHMACKeySize is the shortest HMAC key accepted, matching the SHA-256 output size.
+const MinHMACKeySize = 32
 
 type KeySet[T Primitive] struct {
 	key         []byte
+	primary     uint32
+	keys        map[uint32][]byte
 	constructur NewPrimitive[T]
 }
 
+// Key is one entry of a multi-key KeySet.
+type Key struct {
+	ID       uint32
+	Material []byte
+}
+
 func NewKeySet[T Primitive](key []byte, constructor NewPrimitive[T]) KeySet[T] {
 	return KeySet[T]{
 		key:         key,
+		keys:        map[uint32][]byte{0: key},
 		constructur: constructor,
 	}
 }
@@ -74,6 +91,35 @@ func NewInsecureKeyset[T Primitive](key []byte, constructor NewPrimitive[T]) Key
 	return NewKeySet(key, constructor)
 }
 
+// NewMultiKeySet builds a KeySet holding several keys. The primary key is used
+// for new encryptions and hashes, the others remain available by ID, e.g. to
+// read data written before a rotation.
+func NewMultiKeySet[T Primitive](primary uint32, keys []Key, constructor NewPrimitive[T]) (KeySet[T], error) {
+	ks := KeySet[T]{
+		primary:     primary,
+		keys:        make(map[uint32][]byte, len(keys)),
+		constructur: constructor,
+	}
+
+	for _, k := range keys {
+		if _, ok := ks.keys[k.ID]; ok {
+			return KeySet[T]{}, fmt.Errorf("duplicate key id %d", k.ID)
+		}
+		if _, err := constructor(k.Material); err != nil {
+			return KeySet[T]{}, fmt.Errorf("key id %d: %w", k.ID, err)
+		}
+		ks.keys[k.ID] = k.Material
+	}
+
+	key, ok := ks.keys[primary]
+	if !ok {
+		return KeySet[T]{}, fmt.Errorf("primary key id %d not found in keyset", primary)
+	}
+	ks.key = key
+
+	return ks, nil
+}
+
 func (k *KeySet[T]) GetPrimitiveFunc() func() (T, error) {
 	return func() (T, error) {
 		return k.GetPrimitive()
@@ -81,7 +127,29 @@ func (k *KeySet[T]) GetPrimitiveFunc() func() (T, error) {
 }
 
 func (k *KeySet[T]) GetPrimitive() (T, error) {
-	return k.constructur(k.key)
+	t, err := k.constructur(k.key)
+	if err != nil || len(k.keys) < 2 {
+		return t, err
+	}
+
+	if p, ok := any(&t).(*PrimitiveAES); ok {
+		p.fallbacks = func() ([]cipher.Block, error) {
+			ids := k.KeyIDs()
+			blocks := make([]cipher.Block, 0, len(ids)-1)
+			for _, id := range ids {
+				if id == k.primary {
+					continue
+				}
+				b, err := aes.NewCipher(k.keys[id])
+				if err != nil {
+					return nil, fmt.Errorf("key id %d: %w", id, err)
+				}
+				blocks = append(blocks, b)
+			}
+			return blocks, nil
+		}
+	}
+	return t, nil
 }
 
 func (k *KeySet[T]) GetPrimitiveWithKeyFunc(key []byte) func() (T, error) {
@@ -93,3 +161,55 @@ func (k *KeySet[T]) GetPrimitiveWithKeyFunc(key []byte) func() (T, error) {
 func (k *KeySet[T]) GetPrimitiveWithKey(key []byte) (T, error) {
 	return k.constructur(key)
 }
+
+func (k *KeySet[T]) GetPrimitiveByIDFunc(id uint32) func() (T, error) {
+	return func() (T, error) {
+		return k.GetPrimitiveByID(id)
+	}
+}
+
+func (k *KeySet[T]) GetPrimitiveByID(id uint32) (t T, err error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return t, fmt.Errorf("key id %d not found in keyset", id)
+	}
+
+	return k.constructur(key)
+}
+
+// PrimaryID returns the ID of the key used for new data.
+func (k *KeySet[T]) PrimaryID() uint32 {
+	return k.primary
+}
+
+// KeyIDs returns the IDs of all keys, primary first and the rest in ascending order.
+func (k *KeySet[T]) KeyIDs() []uint32 {
+	ids := make([]uint32, 0, len(k.keys))
+	for id := range k.keys {
+		if id != k.primary {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	return append([]uint32{k.primary}, ids...)
+}
+
+// Key returns the raw material of the key with the given ID.
+func (k *KeySet[T]) Key(id uint32) ([]byte, bool) {
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// WithPrimary returns a copy of the keyset using the key with the given ID for
+// new data.
+func (k *KeySet[T]) WithPrimary(id uint32) (KeySet[T], error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return KeySet[T]{}, fmt.Errorf("key id %d not found in keyset", id)
+	}
+
+	ks := *k
+	ks.primary, ks.key = id, key
+	return ks, nil
+}
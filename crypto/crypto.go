@@ -1,14 +1,21 @@
 package crypto
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/dyaksa/encryption-pii/crypto/aesx"
 	"github.com/dyaksa/encryption-pii/crypto/config"
 	"github.com/dyaksa/encryption-pii/crypto/core"
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
 	"github.com/dyaksa/encryption-pii/crypto/hmacx"
+	"github.com/dyaksa/encryption-pii/crypto/keyfile"
+	"github.com/dyaksa/encryption-pii/crypto/ngram"
+	"github.com/dyaksa/encryption-pii/crypto/secretshare"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
 	_ "github.com/lib/pq"
 )
 
@@ -24,70 +31,166 @@ const (
 	Aes256KeySize AesKeySize = 32
 )
 
-func isValidKeySize(key []byte) bool {
-	keySizes := []AesKeySize{Aes128KeySize, Aes192KeySize, Aes256KeySize}
-	for _, keySize := range keySizes {
-		if len(key) == int(keySize) {
-			return true
-		}
+// Errors returned by New, the cipher types and the hashing APIs, match them with errors.Is.
+var (
+	ErrInvalidKeySize  = core.ErrInvalidKeySize
+	ErrHMACKeyTooShort = core.ErrHMACKeyTooShort
+	ErrDecryptFailed   = core.ErrDecryptFailed
+	ErrAuthFailed      = core.ErrAuthFailed
+)
+
+type Opts func(*Crypto) error
+
+func WithInitHeapConnection() Opts {
+	return WithInitHeapConnectionContext(context.Background())
+}
+
+// WithInitHeapConnectionContext is like WithInitHeapConnection but bounds the
+// connection check by ctx.
+func WithInitHeapConnectionContext(ctx context.Context) Opts {
+	return func(c *Crypto) error {
+		_, err := c.InitHeapDatabaseContext(ctx)
+		return err
 	}
+}
+
+// WithAESKeyShares rebuilds the AES key from k-of-n Shamir shares instead of
+// reading it from CRYPTO_AES_KEY.
+func WithAESKeyShares(sources ...secretshare.Source) Opts {
+	return func(c *Crypto) error {
+		key, err := secretshare.CombineFrom(sources...)
+		if err != nil {
+			return fmt.Errorf("failed to combine aes key shares: %w", err)
+		}
 
-	return false
+		aesKey := string(key)
+		c.AESKey = &aesKey
+		return nil
+	}
 }
 
-type Opts func(*Crypto) error
+// WithHMACKeyShares rebuilds the HMAC key from k-of-n Shamir shares instead of
+// reading it from CRYPTO_HMAC_KEY.
+func WithHMACKeyShares(sources ...secretshare.Source) Opts {
+	return func(c *Crypto) error {
+		key, err := secretshare.CombineFrom(sources...)
+		if err != nil {
+			return fmt.Errorf("failed to combine hmac key shares: %w", err)
+		}
 
-func WithInitHeapConnection() Opts {
+		hmacKey := string(key)
+		c.HMACKey = &hmacKey
+		return nil
+	}
+}
+
+// WithKeyFile loads the AES and HMAC keysets from a passphrase protected
+// keyfile. The primary keys are used for new data. GCM decryption falls back
+// to the other AES keys, as ciphertexts carry no key ID; CBC and CFB cannot
+// tell a wrong key and only use the primary one. The other HMAC keys serve
+// WithDualKeyLookup. Every key is validated like a configured one.
+func WithKeyFile(path string, passphrase keyfile.PassphraseSource) Opts {
 	return func(c *Crypto) error {
-		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-			*c.Host, *c.Port, *c.User, *c.Pass, *c.Name)
-		db, err := sql.Open("postgres", dsn)
+		ks, err := keyfile.ReadFile(path, passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to read keyfile: %w", err)
+		}
+
+		aes, err := core.NewMultiKeySet(ks.AES.Primary, ks.AES.CoreKeys(), core.NewAEAS)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to load aes keyset: %w", err)
 		}
 
-		if err := db.Ping(); err != nil {
-			return err
+		if c.keySize != 0 {
+			for _, k := range ks.AES.Keys {
+				if len(k.Material) != int(c.keySize) {
+					return fmt.Errorf("aes key id %d: %w: expected %d bytes, got %d", k.ID, ErrInvalidKeySize, c.keySize, len(k.Material))
+				}
+			}
 		}
 
-		c.dbHeapPsql = db
+		hmac, err := core.NewMultiKeySet(ks.HMAC.Primary, ks.HMAC.CoreKeys(), core.NewHMAC)
+		if err != nil {
+			return fmt.Errorf("failed to load hmac keyset: %w", err)
+		}
+
+		aesKey, _ := ks.AES.PrimaryKey()
+		hmacKey, _ := ks.HMAC.PrimaryKey()
+		aesKeyStr, hmacKeyStr := string(aesKey), string(hmacKey)
+
+		c.AESKey, c.HMACKey = &aesKeyStr, &hmacKeyStr
+		c.aes, c.hmac = &aes, &hmac
 		return nil
 	}
 }
 
+// WithHeapTables restricts heap table names taken from struct tags and
+// arguments to the given allow-list.
+func WithHeapTables(names ...string) Opts {
+	return func(c *Crypto) error {
+		if c.heapTables == nil {
+			c.heapTables = new(sqlsafe.Registry)
+		}
+		return c.heapTables.Register(names...)
+	}
+}
+
 type Crypto struct {
-	AESKey  *string `env:"AES_KEY,expand" json:"aes_key"`
-	HMACKey *string `env:"HMAC_KEY,expand" json:"hmac_key"`
+	AESKey  *string `json:"aes_key"`
+	HMACKey *string `json:"hmac_key"`
 
 	aes  *core.KeySet[core.PrimitiveAES]
 	hmac *core.KeySet[core.PrimitiveHMAC]
 
-	Host *string `env:"HEAP_DB_HOST" envDefault:"localhost" json:"db_host"`
-	Port *string `env:"HEAP_DB_PORT" envDefault:"5432" json:"db_port"`
-	User *string `env:"HEAP_DB_USER" envDefault:"user" json:"db_user"`
-	Pass *string `env:"HEAP_DB_PASS" envDefault:"password" json:"db_pass"`
-	Name *string `env:"HEAP_DB_NAME" envDefault:"dbname" json:"db_name"`
+	Host *string `json:"db_host"`
+	Port *string `json:"db_port"`
+	User *string `json:"db_user"`
+	Pass *string `json:"db_pass"`
+	Name *string `json:"db_name"`
 
 	dbHeapPsql *sql.DB
+	heap       heapstore.Store
+	heapTables *sqlsafe.Registry
+	auditor    Auditor
+
+	heapAnalyzers   *heapAnalyzers
+	prefixAnalyzers *heapAnalyzers
 
+	heapWriteMode   HeapWriteMode
+	heapOutboxTable string
+	heapRefs        bool
+	dualKeyLookup   bool
+	heapMode        HeapMode
+	prefix          ngram.Tokenizer
+
+	cfg     config.Config
 	keySize AesKeySize
 }
 
-func New(keySize AesKeySize, opts ...Opts) (c *Crypto, err error) {
-	config := config.InitConfig()
+// New builds a Crypto from cfg. Options run before validation, so keys supplied
+// by options such as WithKeyFile or WithAESKeyShares may be left out of cfg.
+// Keys may be raw text or prefixed with "hex:" or "base64:"; invalid key
+// material is reported here as ErrInvalidKeySize or ErrHMACKeyTooShort.
+func New(cfg *config.Config, opts ...Opts) (c *Crypto, err error) {
+	if cfg == nil {
+		return nil, errors.New("config is required")
+	}
 
 	c = &Crypto{
-		Host: &config.Host,
-		Port: &config.Port,
-		User: &config.User,
-		Pass: &config.Pass,
-		Name: &config.Name,
+		cfg:             *cfg,
+		keySize:         AesKeySize(cfg.AesKeySize),
+		heapAnalyzers:   &heapAnalyzers{kind: "heap table"},
+		prefixAnalyzers: &heapAnalyzers{kind: "prefix column"},
+	}
 
-		AESKey:  &config.AesKey,
-		HMACKey: &config.HmacKey,
+	c.Host = &c.cfg.Host
+	c.Port = &c.cfg.Port
+	c.User = &c.cfg.User
+	c.Pass = &c.cfg.Pass
+	c.Name = &c.cfg.Name
 
-		keySize: keySize,
-	}
+	c.AESKey = &c.cfg.AesKey
+	c.HMACKey = &c.cfg.HmacKey
 
 	for _, opt := range opts {
 		if err = opt(c); err != nil {
@@ -95,17 +198,36 @@ func New(keySize AesKeySize, opts ...Opts) (c *Crypto, err error) {
 		}
 	}
 
-	if c.AESKey == nil || c.HMACKey == nil {
-		return nil, errors.New("key is required")
+	effective := c.cfg
+	effective.AesKey, effective.HmacKey = *c.AESKey, *c.HMACKey
+	if err = effective.Validate(); err != nil {
+		return nil, err
+	}
+
+	if c.aes == nil {
+		if err = c.initAES(); err != nil {
+			return nil, err
+		}
 	}
 
-	c.initAES()
-	c.initHMAC()
+	if c.hmac == nil {
+		if err = c.initHMAC(); err != nil {
+			return nil, err
+		}
+	}
 
 	return c, nil
 }
 
 func (c *Crypto) InitHeapDatabase() (*sql.DB, error) {
+	return c.InitHeapDatabaseContext(context.Background())
+}
+
+func (c *Crypto) InitHeapDatabaseContext(ctx context.Context) (*sql.DB, error) {
+	if err := c.cfg.ValidateHeap(); err != nil {
+		return nil, err
+	}
+
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		*c.Host, *c.Port, *c.User, *c.Pass, *c.Name)
 	db, err := sql.Open("postgres", dsn)
@@ -113,46 +235,58 @@ func (c *Crypto) InitHeapDatabase() (*sql.DB, error) {
 		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	c.dbHeapPsql = db
+	c.heap = heapstore.NewPostgres(db)
 	return db, nil
 }
 
-func (c *Crypto) initAES() {
-	if c.AESKey == nil {
-		c.aes = nil
+func (c *Crypto) initAES() error {
+	key, err := core.DecodeKey(*c.AESKey)
+	if err != nil {
+		return fmt.Errorf("aes key: %w", err)
+	}
+
+	if err := core.CheckAESKey(key); err != nil {
+		return err
+	}
+
+	if c.keySize != 0 && len(key) != int(c.keySize) {
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidKeySize, c.keySize, len(key))
 	}
 
-	a := core.NewInsecureKeyset([]byte(*c.AESKey), core.NewAEAS)
+	a := core.NewInsecureKeyset(key, core.NewAEAS)
 	c.aes = &a
+	return nil
 }
 
-func (c *Crypto) initHMAC() {
-	if c.HMACKey == nil {
-		c.hmac = nil
+func (c *Crypto) initHMAC() error {
+	key, err := core.DecodeKey(*c.HMACKey)
+	if err != nil {
+		return fmt.Errorf("hmac key: %w", err)
+	}
+
+	if err := core.CheckHMACKey(key); err != nil {
+		return err
 	}
 
-	h := core.NewInsecureKeyset([]byte(*c.HMACKey), core.NewHMAC)
+	h := core.NewInsecureKeyset(key, core.NewHMAC)
 	c.hmac = &h
+	return nil
 }
 
 func (c *Crypto) AESFunc() func() (core.PrimitiveAES, error) {
-	if !isValidKeySize([]byte(*c.AESKey)) {
-		return func() (core.PrimitiveAES, error) {
-			return core.PrimitiveAES{}, errors.New("invalid key size")
-		}
-	}
-
 	return c.aes.GetPrimitiveFunc()
 }
 
 func (c *Crypto) AESWithKeyFunc(key []byte) func() (core.PrimitiveAES, error) {
-	if !isValidKeySize(key) {
+	if err := core.CheckAESKey(key); err != nil {
 		return func() (core.PrimitiveAES, error) {
-			return core.PrimitiveAES{}, errors.New("invalid key size")
+			return core.PrimitiveAES{}, err
 		}
 	}
 
@@ -167,14 +301,73 @@ func (c *Crypto) Decrypt(alg aesx.AesAlg) aesx.AES[string, core.PrimitiveAES] {
 	return aesx.AESChiper(c.AESFunc(), "", alg)
 }
 
+func (c *Crypto) EncryptInt64(data int64, alg aesx.AesAlg) aesx.AES[int64, core.PrimitiveAES] {
+	return aesx.AESInt64(c.AESFunc(), data, alg)
+}
+
+func (c *Crypto) DecryptInt64(alg aesx.AesAlg) aesx.AES[int64, core.PrimitiveAES] {
+	return aesx.AESInt64(c.AESFunc(), 0, alg)
+}
+
+func (c *Crypto) EncryptTime(data time.Time, alg aesx.AesAlg) aesx.AES[time.Time, core.PrimitiveAES] {
+	return aesx.AESTime(c.AESFunc(), data, alg)
+}
+
+func (c *Crypto) DecryptTime(alg aesx.AesAlg) aesx.AES[time.Time, core.PrimitiveAES] {
+	return aesx.AESTime(c.AESFunc(), time.Time{}, alg)
+}
+
+// EncryptContext is like Encrypt and reports every encryption, when the value
+// is written to the database, to the auditor together with its error and the
+// metadata carried by ctx.
+func (c *Crypto) EncryptContext(ctx context.Context, data string, alg aesx.AesAlg) aesx.AES[string, core.PrimitiveAES] {
+	return c.Encrypt(data, alg).OnDone(func(err error) {
+		c.audit(ctx, AuditEncrypt, "", err)
+	})
+}
+
+// DecryptContext is like Decrypt and reports every decryption, when a value is
+// scanned, to the auditor together with its error and the metadata carried by
+// ctx.
+func (c *Crypto) DecryptContext(ctx context.Context, alg aesx.AesAlg) aesx.AES[string, core.PrimitiveAES] {
+	return c.Decrypt(alg).OnDone(func(err error) {
+		c.audit(ctx, AuditDecrypt, "", err)
+	})
+}
+
 func (c *Crypto) HMACFunc() func() (core.PrimitiveHMAC, error) {
 	return c.hmac.GetPrimitiveFunc()
 }
 
+// Hash returns the last 8 hex digits of the HMAC of data.
+//
+// Deprecated: a misconfigured key yields "", which matches every other failed
+// hash; use HashErr.
 func (c *Crypto) Hash(data string) string {
-	return hmacx.HMACHash(c.HMACFunc(), data).HashString()
+	h, _ := c.HashErr(data)
+	return h
+}
+
+// HashErr returns the last 8 hex digits of the HMAC of data.
+func (c *Crypto) HashErr(data string) (string, error) {
+	return hmacx.HMACHash(c.HMACFunc(), data).HashStringErr()
 }
 
+// HashString returns the full hex encoded HMAC of data.
+//
+// Deprecated: a misconfigured key yields "", which matches every other failed
+// hash; use HashStringErr.
 func (c *Crypto) HashString(data string) string {
-	return hmacx.HMACHash(c.HMACFunc(), data).Hash().ToString()
+	h, _ := c.HashStringErr(data)
+	return h
+}
+
+// HashStringErr returns the full hex encoded HMAC of data.
+func (c *Crypto) HashStringErr(data string) (string, error) {
+	h, err := hmacx.HMACHash(c.HMACFunc(), data).HashErr()
+	if err != nil {
+		return "", err
+	}
+
+	return h.ToString(), nil
 }
@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
+)
+
+// ErrHeapNotConnected is returned by heap operations when no heap store was
+// configured with WithHeapStore, WithInitHeapConnection or InitHeapDatabase.
+var ErrHeapNotConnected = errors.New("heap database is not connected")
+
+// WithHeapStore stores heap tokens in store instead of the Postgres database
+// opened by WithInitHeapConnection, e.g. heapstore.NewSQLite or
+// heapstore.NewMemory in tests. See package heapstore for the table layout.
+func WithHeapStore(store heapstore.Store) Opts {
+	return func(c *Crypto) error {
+		if store == nil {
+			return errors.New("heap store is nil")
+		}
+
+		c.heap = store
+		return nil
+	}
+}
+
+func toTokens(textHeaps []TextHeap) []heapstore.Token {
+	tokens := make([]heapstore.Token, len(textHeaps))
+	for i, th := range textHeaps {
+		tokens[i] = heapstore.Token{Table: th.Type, Content: th.Content, Hash: th.Hash}
+	}
+	return tokens
+}
+
+// InsertHeaps writes textHeaps to Postgres heap tables through db, which may
+// be a transaction, with one INSERT ... ON CONFLICT (hash) DO NOTHING per
+// heap table.
+func InsertHeaps(ctx context.Context, db heapstore.DB, textHeaps []TextHeap) error {
+	return heapstore.NewPostgres(db).Save(ctx, toTokens(textHeaps))
+}
+
+// heapStore returns the configured heap store.
+func (c *Crypto) heapStore() (heapstore.Store, error) {
+	if c.heap == nil {
+		return nil, ErrHeapNotConnected
+	}
+	return c.heap, nil
+}
+
+func (c *Crypto) saveToHeap(ctx context.Context, store heapstore.Store, textHeaps []TextHeap) error {
+	if err := c.checkHeapTables(textHeaps); err != nil {
+		return err
+	}
+
+	return store.Save(ctx, toTokens(textHeaps))
+}
+
+// SaveHeapBulk writes large amounts of heap tokens, e.g. during a backfill.
+// Stores implementing heapstore.BulkSaver use their bulk path, COPY for
+// Postgres.
+func (c *Crypto) SaveHeapBulk(ctx context.Context, textHeaps []TextHeap) error {
+	store, err := c.heapStore()
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkHeapTables(textHeaps); err != nil {
+		return err
+	}
+
+	if bulk, ok := store.(heapstore.BulkSaver); ok {
+		return bulk.SaveBulk(ctx, toTokens(textHeaps))
+	}
+	return store.Save(ctx, toTokens(textHeaps))
+}
+
+func (c *Crypto) checkHeapTables(textHeaps []TextHeap) error {
+	for _, th := range textHeaps {
+		if _, err := c.heapTable(th.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateHeap creates and upgrades the given heap tables, by default the ones
+// registered with WithHeapTables, and returns the migrations it applied or
+// skipped for want of a database extension.
+func (c *Crypto) MigrateHeap(ctx context.Context, tables ...string) ([]heapstore.AppliedMigration, error) {
+	store, err := c.heapStore()
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, ok := store.(heapstore.Migrator)
+	if !ok {
+		return nil, errors.New("heap store does not support migrations")
+	}
+
+	if len(tables) == 0 {
+		tables = c.heapTables.Names()
+	}
+
+	for _, table := range tables {
+		if _, err := c.heapTable(table); err != nil {
+			return nil, err
+		}
+	}
+
+	return migrator.Migrate(ctx, tables...)
+}
+
+// heapTable checks name against the heap table allow-list and quotes it.
+func (c *Crypto) heapTable(name string) (string, error) {
+	return c.heapTables.Quote(name)
+}
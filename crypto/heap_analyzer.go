@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/dyaksa/encryption-pii/crypto/analyzer"
+)
+
+// heapAnalyzers remembers the analyzer building the tokens of every heap
+// table, or prefix column, so SearchContents and SearchPrefix tokenize
+// queries the way the tokens were written. The empty name stands for the
+// legacy split.
+type heapAnalyzers struct {
+	kind  string
+	mu    sync.RWMutex
+	names map[string]string
+}
+
+// WithHeapAnalyzer declares that the tokens of heap table are built by the
+// named analyzer. Tables are also registered from the analyzer tag of the
+// fields writing to them, and with WithEntities; a table written or declared
+// with two analyzers is an error.
+func WithHeapAnalyzer(table, name string) Opts {
+	return func(c *Crypto) error {
+		if _, err := analyzer.Get(name); name != "" && err != nil {
+			return err
+		}
+		return c.heapAnalyzers.set(table, name)
+	}
+}
+
+// WithEntities registers the analyzers of the txt_heap_table and prefix
+// fields of entities, for services searching tables they never write to.
+func WithEntities(entities ...interface{}) Opts {
+	return func(c *Crypto) error {
+		for _, entity := range entities {
+			t := reflect.TypeOf(entity)
+			if t.Kind() == reflect.Pointer {
+				t = t.Elem()
+			}
+			if err := c.registerHeapAnalyzers(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// registerHeapAnalyzers registers the analyzers of the txt_heap_table and
+// prefix fields of the struct type t.
+func (c *Crypto) registerHeapAnalyzers(t reflect.Type) error {
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("entity must be a struct, got %s", t)
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if table := field.Tag.Get("txt_heap_table"); table != "" {
+			if err := c.heapAnalyzers.set(table, field.Tag.Get("analyzer")); err != nil {
+				return fmt.Errorf("field %s: %w", field.Name, err)
+			}
+		}
+		if field.Tag.Get("prefix") == "true" {
+			if err := c.prefixAnalyzers.set(columnName(field), field.Tag.Get("analyzer")); err != nil {
+				return fmt.Errorf("field %s: %w", field.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *heapAnalyzers) set(table, name string) error {
+	if h == nil || table == "" {
+		return nil
+	}
+	table = strings.ToLower(table)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if registered, ok := h.names[table]; ok {
+		if registered != name {
+			return fmt.Errorf("%s %s is analyzed with %q, not %q", h.kind, table, registered, name)
+		}
+		return nil
+	}
+
+	if h.names == nil {
+		h.names = make(map[string]string)
+	}
+	h.names[table] = name
+	return nil
+}
+
+// resolve returns the analyzer to search table with: requested, which must
+// agree with the registered one, or else the registered one.
+func (h *heapAnalyzers) resolve(table, requested string) (string, error) {
+	if h == nil {
+		return requested, nil
+	}
+
+	h.mu.RLock()
+	registered, ok := h.names[strings.ToLower(table)]
+	h.mu.RUnlock()
+
+	switch {
+	case !ok:
+		return requested, nil
+	case requested != "" && requested != registered:
+		return "", fmt.Errorf("%s %s is analyzed with %q, not %q", h.kind, table, registered, requested)
+	default:
+		return registered, nil
+	}
+}
@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/config"
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+)
+
+const (
+	testAESKey  = "0123456789abcdef0123456789abcdef"
+	testHMACKey = "fedcba9876543210fedcba9876543210"
+)
+
+func newTestCrypto(t *testing.T, opts ...Opts) *Crypto {
+	t.Helper()
+
+	c, err := New(&config.Config{AesKey: testAESKey, HmacKey: testHMACKey}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+type analyzedPerson struct {
+	Name     types.AESCipher
+	NameBidx string `db:"name_bidx" txt_heap_table:"name_heap" analyzer:"standard"`
+	City     types.AESCipher
+	CityBidx string `db:"city_bidx" txt_heap_table:"city_heap"`
+}
+
+func TestSearchContentsTokenizesLikeWrites(t *testing.T) {
+	c := newTestCrypto(t, WithHeapStore(heapstore.NewMemory()))
+	ctx := context.Background()
+
+	p := analyzedPerson{
+		Name: c.Encrypt("Zoë Ñúñez-Ortega", aesx.AesGCM),
+		City: c.Encrypt("Jakarta, Selatan", aesx.AesGCM),
+	}
+	if err := c.BindHeap(&p); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		table, content string
+	}{
+		{"name_heap", "ZOË"},
+		{"name_heap", "ortega!"},
+		{"name_heap", "ñúñez-ortega"},
+		{"city_heap", "jakarta,"},
+	}
+	for _, tt := range tests {
+		hashes, err := c.SearchContents(ctx, tt.table, func(p *FindTextHeapByContentParams) {
+			p.Content = tt.content
+		})
+		if err != nil {
+			t.Fatalf("SearchContents(%s, %q): %v", tt.table, tt.content, err)
+		}
+		if len(hashes) == 0 {
+			t.Errorf("SearchContents(%s, %q) found nothing", tt.table, tt.content)
+		}
+	}
+}
+
+func TestHeapAnalyzerConflicts(t *testing.T) {
+	c := newTestCrypto(t, WithHeapStore(heapstore.NewMemory()), WithHeapAnalyzer("name_heap", "email"))
+
+	p := analyzedPerson{Name: c.Encrypt("Budi", aesx.AesGCM)}
+	if err := c.BindHeap(&p); err == nil {
+		t.Error("BindHeap wrote name_heap with a second analyzer")
+	}
+
+	_, err := c.SearchContents(context.Background(), "name_heap", func(p *FindTextHeapByContentParams) {
+		p.Content = "budi"
+		p.Analyzer = "standard"
+	})
+	if err == nil {
+		t.Error("SearchContents searched name_heap with a second analyzer")
+	}
+
+	if _, err := New(&config.Config{AesKey: testAESKey, HmacKey: testHMACKey}, WithEntities(analyzedPerson{}), WithHeapAnalyzer("name_heap", "digits")); err == nil {
+		t.Error("New accepted two analyzers for name_heap")
+	}
+}
@@ -0,0 +1,215 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
+	"github.com/dyaksa/encryption-pii/crypto/ngram"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+)
+
+// HeapMode decides what the content column of heap tables holds. The hash
+// column always holds the HMAC token also written to the bidx columns.
+type HeapMode int
+
+const (
+	// HeapPlaintext stores every token lower-cased in clear. Anyone reading
+	// the heap database learns every name part, email local part and NIK
+	// segment, just not which row they belong to. Search runs in the database
+	// with indexed ILIKE and supports arbitrary LIKE patterns.
+	HeapPlaintext HeapMode = iota
+
+	// HeapEncrypted stores tokens encrypted with AES-GCM under the AES key.
+	// The heap database only learns the number of distinct tokens and their
+	// lengths. Exact words are looked up by hash; patterns decrypt the whole
+	// heap table on every search, so it only suits small tables.
+	HeapEncrypted
+
+	// HeapHashedNgrams stores the keyed hashes of the overlapping trigrams of
+	// every token, in order, instead of the token. Search matches runs of
+	// gram hashes in the database. The heap database learns token lengths
+	// and which tokens share trigrams, and gram frequencies are open to
+	// frequency analysis against a known name list, which recovers common
+	// tokens; it no longer holds any plaintext. Only patterns of the form
+	// term, term%, %term and %term% are supported, with terms of at least
+	// three characters.
+	HeapHashedNgrams
+)
+
+const heapNgramSize = 3
+
+var (
+	ErrSearchTermTooShort = ngram.ErrTermTooShort
+	ErrUnsupportedPattern = errors.New("pattern is not supported by the heap mode")
+)
+
+// WithHeapMode selects what heap tables store, HeapPlaintext by default. The
+// modes differ in leakage and cost, see HeapMode; a heap table must be
+// rebuilt when switching modes.
+func WithHeapMode(mode HeapMode) Opts {
+	return func(c *Crypto) error {
+		switch mode {
+		case HeapPlaintext, HeapEncrypted, HeapHashedNgrams:
+		default:
+			return fmt.Errorf("invalid heap mode %d", mode)
+		}
+
+		c.heapMode = mode
+		return nil
+	}
+}
+
+// HeapContent returns what the content column holds for the lower-cased
+// token under the configured heap mode.
+func (c *Crypto) HeapContent(token string) (string, error) {
+	switch c.heapMode {
+	case HeapEncrypted:
+		v, err := aesx.AESChiper(c.AESFunc(), token, aesx.AesGCM).Value()
+		if err != nil {
+			return "", err
+		}
+		return string(v.([]byte)), nil
+	case HeapHashedNgrams:
+		grams, err := c.gramHashes(token)
+		if err != nil {
+			return "", err
+		}
+		return " " + strings.Join(grams, " ") + " ", nil
+	default:
+		return token, nil
+	}
+}
+
+// gramHashes returns the hashes of the overlapping trigrams of s, or of s
+// itself when it is shorter.
+func (c *Crypto) gramHashes(s string) ([]string, error) {
+	grams := ngram.New(heapNgramSize).Sequence(s)
+
+	hashes := make([]string, len(grams))
+	for i, gram := range grams {
+		// domain separated from the token hashes
+		h, err := c.heapHash("\x00gram:" + gram)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = h
+	}
+
+	return hashes, nil
+}
+
+// searchHeap returns the tokens of table equal to one of words or matching one
+// of the LIKE patterns.
+func (c *Crypto) searchHeap(ctx context.Context, store heapstore.Store, table string, words, patterns []string) ([]heapstore.Token, error) {
+	switch c.heapMode {
+	case HeapEncrypted:
+		return c.searchEncryptedHeap(ctx, store, table, words, patterns)
+	case HeapHashedNgrams:
+		var gramPatterns []string
+		for _, word := range words {
+			if word == "" {
+				continue
+			}
+
+			content, err := c.HeapContent(word)
+			if err != nil {
+				return nil, err
+			}
+			gramPatterns = append(gramPatterns, content)
+		}
+
+		for _, pattern := range patterns {
+			p, err := c.gramPattern(pattern)
+			if err != nil {
+				return nil, err
+			}
+			gramPatterns = append(gramPatterns, p)
+		}
+
+		return store.Search(ctx, table, gramPatterns)
+	default:
+		contents := make([]string, 0, len(words)+len(patterns))
+		for _, word := range words {
+			contents = append(contents, sqlsafe.EscapeLike(word))
+		}
+		return store.Search(ctx, table, append(contents, patterns...))
+	}
+}
+
+// gramPattern turns a term, term%, %term or %term% pattern into a LIKE
+// pattern over gram hash content.
+func (c *Crypto) gramPattern(pattern string) (string, error) {
+	term := strings.TrimSuffix(strings.TrimPrefix(pattern, "%"), "%")
+	if strings.ContainsAny(term, `%_\`) {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedPattern, pattern)
+	}
+
+	term = strings.ToLower(term)
+	if len([]rune(term)) < heapNgramSize {
+		return "", fmt.Errorf("%w: %q", ErrSearchTermTooShort, pattern)
+	}
+
+	grams, err := c.gramHashes(term)
+	if err != nil {
+		return "", err
+	}
+
+	p := " " + strings.Join(grams, " ") + " "
+	if strings.HasPrefix(pattern, "%") {
+		p = "%" + p
+	}
+	if strings.HasSuffix(pattern, "%") {
+		p += "%"
+	}
+	return p, nil
+}
+
+func (c *Crypto) searchEncryptedHeap(ctx context.Context, store heapstore.Store, table string, words, patterns []string) (tokens []heapstore.Token, err error) {
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+
+		hash, err := c.heapHash(word)
+		if err != nil {
+			return nil, err
+		}
+
+		t, ok, err := store.Lookup(ctx, table, hash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			tokens = append(tokens, t)
+		}
+	}
+
+	if len(patterns) == 0 {
+		return tokens, nil
+	}
+
+	all, err := store.Search(ctx, table, []string{"%"})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range all {
+		v := c.Decrypt(aesx.AesGCM)
+		if err := v.Scan([]byte(t.Content)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt heap content of %s: %w", table, err)
+		}
+
+		for _, pattern := range patterns {
+			if heapstore.MatchLike(strings.ToLower(pattern), v.To()) {
+				tokens = append(tokens, t)
+				break
+			}
+		}
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,112 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
+)
+
+// searchRecorder records the patterns of every Search.
+type searchRecorder struct {
+	heapstore.Store
+	searches [][]string
+}
+
+func (s *searchRecorder) Search(ctx context.Context, table string, patterns []string) ([]heapstore.Token, error) {
+	s.searches = append(s.searches, patterns)
+	return s.Store.Search(ctx, table, patterns)
+}
+
+func TestHeapModesRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	for _, mode := range []HeapMode{HeapPlaintext, HeapEncrypted, HeapHashedNgrams} {
+		store := &searchRecorder{Store: heapstore.NewMemory()}
+		c := newTestCrypto(t, WithHeapStore(store), WithHeapMode(mode))
+
+		for _, name := range []string{"Budi Santoso", "Siti Rahayu"} {
+			p := analyzedPerson{Name: c.Encrypt(name, aesx.AesGCM)}
+			if err := c.BindHeap(&p); err != nil {
+				t.Fatalf("mode %d: %v", mode, err)
+			}
+		}
+
+		all, err := store.Store.Search(ctx, "name_heap", []string{"%"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 4 {
+			t.Fatalf("mode %d: %d tokens stored, want 4", mode, len(all))
+		}
+		words := []string{"budi", "santoso", "siti", "rahayu"}
+		for _, token := range all {
+			plain := slices.ContainsFunc(words, func(w string) bool { return strings.Contains(token.Content, w) })
+			if plain != (mode == HeapPlaintext) {
+				t.Errorf("mode %d: content %q", mode, token.Content)
+			}
+		}
+
+		search := func(content string, patterns ...string) []string {
+			t.Helper()
+			hashes, err := c.SearchContents(ctx, "name_heap", func(p *FindTextHeapByContentParams) {
+				p.Content = content
+				p.Patterns = patterns
+			})
+			if err != nil {
+				t.Fatalf("mode %d: search %q %q: %v", mode, content, patterns, err)
+			}
+			return hashes
+		}
+
+		santoso := search("Santoso")
+		if len(santoso) != 1 {
+			t.Fatalf("mode %d: %d hashes for santoso, want 1", mode, len(santoso))
+		}
+		if got := search("santos"); len(got) != 0 {
+			t.Errorf("mode %d: a partial word matched exactly", mode)
+		}
+
+		for _, pattern := range []string{"santoso", "sant%", "%toso", "%nto%", "%ANTOS%"} {
+			if got := search("", pattern); !slices.Equal(got, santoso) {
+				t.Errorf("mode %d: pattern %q found %q, want %q", mode, pattern, got, santoso)
+			}
+		}
+		if got := search("", "%xyz%"); len(got) != 0 {
+			t.Errorf("mode %d: pattern %%xyz%% found %q", mode, got)
+		}
+
+		store.searches = nil
+		search("", "%ahay%")
+		scanned := slices.ContainsFunc(store.searches, func(patterns []string) bool {
+			return slices.Equal(patterns, []string{"%"})
+		})
+		if scanned != (mode == HeapEncrypted) {
+			t.Errorf("mode %d: full table scan %v for a pattern", mode, scanned)
+		}
+	}
+}
+
+func TestHashedNgramsRejectPatterns(t *testing.T) {
+	c := newTestCrypto(t, WithHeapStore(heapstore.NewMemory()), WithHeapMode(HeapHashedNgrams))
+
+	for _, tt := range []struct {
+		pattern string
+		want    error
+	}{
+		{"%to%", ErrSearchTermTooShort},
+		{"s%o", ErrUnsupportedPattern},
+		{"san_oso", ErrUnsupportedPattern},
+	} {
+		_, err := c.SearchContents(context.Background(), "name_heap", func(p *FindTextHeapByContentParams) {
+			p.Patterns = []string{tt.pattern}
+		})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("pattern %q: got %v, want %v", tt.pattern, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
+	"github.com/dyaksa/encryption-pii/crypto/metadata"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+)
+
+// TableNamer is implemented by entities to name the table they are stored
+// in, which heap reference tracking needs.
+type TableNamer interface {
+	TableName() string
+}
+
+// WithHeapRefs records for every bound entity which of its columns reference
+// which heap tokens, so GCHeap and PurgeSubject can delete tokens no row
+// uses any more. Entities must implement TableNamer and have a field tagged
+// db:"id"; the subject in the context metadata is stored for PurgeSubject.
+// The heap store must implement heapstore.RefStore.
+func WithHeapRefs() Opts {
+	return func(c *Crypto) error {
+		c.heapRefs = true
+		return nil
+	}
+}
+
+// heapColumn is a bidx column of an entity and the tokens of its value.
+type heapColumn struct {
+	name  string
+	heaps []TextHeap
+}
+
+// columnName returns the column of field, its db tag or else its lower-cased name.
+func columnName(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("db"), ","); name != "" {
+		return name
+	}
+	return strings.ToLower(field.Name)
+}
+
+// entityRowID returns the value of the field tagged db:"id".
+func entityRowID(v reflect.Value) (string, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("db"), ","); name != "id" {
+			continue
+		}
+
+		var id string
+		switch value := v.Field(i).Interface().(type) {
+		case types.NullUuid:
+			if value.Valid {
+				id = value.UUID.String()
+			}
+		case types.NullString:
+			if value.Valid {
+				id = value.String
+			}
+		case types.NullInt64:
+			if value.Valid {
+				id = fmt.Sprint(value.Int64)
+			}
+		default:
+			if !v.Field(i).IsZero() {
+				id = fmt.Sprint(value)
+			}
+		}
+		return id, id != ""
+	}
+
+	return "", false
+}
+
+func (c *Crypto) bindHeapRefs(ctx context.Context, tx *sql.Tx, entity reflect.Value, columns []heapColumn) error {
+	namer, ok := entity.Addr().Interface().(TableNamer)
+	if !ok {
+		return fmt.Errorf("heap references: %s does not implement TableNamer", entity.Type())
+	}
+
+	rowID, ok := entityRowID(entity)
+	if !ok {
+		return fmt.Errorf("heap references: %s has no id in a field tagged db:\"id\"", entity.Type())
+	}
+
+	return c.saveHeapRefs(ctx, tx, namer.TableName(), rowID, columns)
+}
+
+func (c *Crypto) saveHeapRefs(ctx context.Context, tx *sql.Tx, table, rowID string, columns []heapColumn) error {
+	if len(columns) == 0 {
+		return nil
+	}
+
+	store, err := c.refStore(tx)
+	if err != nil {
+		return err
+	}
+
+	subject := metadata.FromContext(ctx).Subject
+
+	var (
+		owners []heapstore.Owner
+		refs   []heapstore.Ref
+	)
+	for _, column := range columns {
+		owner := heapstore.Owner{Table: table, Column: column.name, RowID: rowID}
+		owners = append(owners, owner)
+		for _, th := range column.heaps {
+			refs = append(refs, heapstore.Ref{Owner: owner, Table: th.Type, Hash: th.Hash, Subject: subject})
+		}
+	}
+
+	// references go first, so a concurrent GCHeap never sees the new tokens unreferenced
+	if err := store.ReplaceRefs(ctx, owners, refs); err != nil {
+		return fmt.Errorf("failed to save heap references: %w", err)
+	}
+	return nil
+}
+
+// refStore returns the store keeping heap references, bound to tx when heap
+// tokens are written in the caller's transaction.
+func (c *Crypto) refStore(tx *sql.Tx) (heapstore.RefStore, error) {
+	var store heapstore.Store
+	if tx != nil && c.heapWriteMode == HeapWriteCallerTx {
+		store = c.txHeapStore(tx)
+	} else {
+		var err error
+		if store, err = c.heapStore(); err != nil {
+			return nil, err
+		}
+	}
+
+	refs, ok := store.(heapstore.RefStore)
+	if !ok {
+		return nil, errors.New("heap store does not track references")
+	}
+	return refs, nil
+}
+
+// DeleteHeapRefs drops the heap references of a deleted row, leaving its
+// tokens to the next GCHeap.
+func (c *Crypto) DeleteHeapRefs(ctx context.Context, table, rowID string) error {
+	store, err := c.refStore(nil)
+	if err != nil {
+		return err
+	}
+
+	return store.DeleteRowRefs(ctx, table, rowID)
+}
+
+// GCHeap deletes the tokens no row references from the given heap tables, by
+// default the ones registered with WithHeapTables, and returns how many it
+// deleted. Only run it on tables whose references were tracked with
+// WithHeapRefs from the start or backfilled, untracked tokens count as garbage.
+func (c *Crypto) GCHeap(ctx context.Context, tables ...string) (deleted int64, err error) {
+	defer func() { c.audit(ctx, AuditGCHeap, strings.Join(tables, ","), err) }()
+
+	store, err := c.refStore(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(tables) == 0 {
+		tables = c.heapTables.Names()
+	}
+	if len(tables) == 0 {
+		return 0, errors.New("no heap tables to collect")
+	}
+
+	for _, table := range tables {
+		if _, err := c.heapTable(table); err != nil {
+			return deleted, err
+		}
+
+		n, err := store.CollectGarbage(ctx, table)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// PurgeSubject serves erasure requests: it drops the heap references recorded
+// for subject and deletes the tokens no other row references, returning how
+// many it deleted. The entity rows themselves are left to the caller.
+func (c *Crypto) PurgeSubject(ctx context.Context, subject string) (deleted int64, err error) {
+	defer func() { c.audit(ctx, AuditPurgeSubject, "", err) }()
+
+	if subject == "" {
+		return 0, errors.New("subject is required")
+	}
+
+	store, err := c.refStore(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return store.PurgeSubject(ctx, subject)
+}
@@ -0,0 +1,98 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
+	"github.com/dyaksa/encryption-pii/crypto/metadata"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+)
+
+type referencingPerson struct {
+	ID       types.NullInt64 `db:"id"`
+	Name     types.AESCipher
+	NameBidx string `db:"name_bidx" txt_heap_table:"name_heap"`
+}
+
+func (referencingPerson) TableName() string { return "people" }
+
+func TestHeapGarbageCollection(t *testing.T) {
+	store := heapstore.NewMemory()
+	c := newTestCrypto(t, WithHeapStore(store), WithHeapRefs(), WithHeapTables("name_heap"))
+
+	bind := func(id int64, subject, name string) {
+		t.Helper()
+
+		p := referencingPerson{
+			ID:   types.NullInt64{NullInt64: sql.NullInt64{Int64: id, Valid: true}},
+			Name: c.Encrypt(name, aesx.AesGCM),
+		}
+		if err := c.BindHeapContext(metadata.WithSubject(context.Background(), subject), &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tokens := func() int {
+		t.Helper()
+
+		found, err := store.Search(context.Background(), "name_heap", []string{"%"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(found)
+	}
+
+	collect := func(want int64) {
+		t.Helper()
+
+		deleted, err := c.GCHeap(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != want {
+			t.Fatalf("GCHeap deleted %d tokens, want %d", deleted, want)
+		}
+	}
+
+	bind(1, "s1", "Budi Santoso")
+	bind(2, "s2", "Budi Hartono")
+	if n := tokens(); n != 3 {
+		t.Fatalf("%d tokens, want 3", n)
+	}
+	collect(0)
+
+	// budi is still referenced by row 2
+	bind(1, "s1", "Ani Santoso")
+	collect(0)
+
+	bind(2, "s2", "Citra Hartono")
+	collect(1)
+	if n := tokens(); n != 4 {
+		t.Fatalf("%d tokens, want ani, santoso, citra and hartono", n)
+	}
+
+	deleted, err := c.PurgeSubject(context.Background(), "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("PurgeSubject deleted %d tokens, want ani and santoso", deleted)
+	}
+
+	// the refs of a deleted row are found by the key they were written under
+	id, err := cursorOf(reflect.ValueOf(types.NullInt64{NullInt64: sql.NullInt64{Int64: 2, Valid: true}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteHeapRefs(context.Background(), "people", id); err != nil {
+		t.Fatal(err)
+	}
+	collect(2)
+	if n := tokens(); n != 0 {
+		t.Fatalf("%d tokens left", n)
+	}
+}
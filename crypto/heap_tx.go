@@ -0,0 +1,227 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+	"github.com/lib/pq"
+)
+
+// HeapWriteMode decides where BindHeapTx writes heap tokens.
+type HeapWriteMode int
+
+const (
+	// HeapWriteDirect writes tokens straight to the heap database, outside the
+	// caller's transaction. A rollback of the entity leaves orphan tokens.
+	HeapWriteDirect HeapWriteMode = iota
+
+	// HeapWriteCallerTx writes tokens in the caller's transaction. Use it when
+	// the heap tables live in the same database as the entities, so tokens
+	// and entity rows commit or roll back together.
+	HeapWriteCallerTx
+
+	// HeapWriteOutbox queues tokens in an outbox table in the caller's
+	// transaction. ReconcileHeapOutbox later moves them to a heap database
+	// living elsewhere; heap writes are idempotent, so a retried batch is safe.
+	HeapWriteOutbox
+)
+
+const DefaultHeapOutboxTable = "heap_outbox"
+
+// WithHeapWriteMode selects how BindHeapTx writes heap tokens.
+//
+// The outbox table for HeapWriteOutbox lives in the entity database:
+//
+//	CREATE TABLE heap_outbox (
+//		id         bigserial PRIMARY KEY,
+//		heap_table text NOT NULL,
+//		content    text NOT NULL,
+//		hash       text NOT NULL,
+//		created_at timestamptz NOT NULL DEFAULT now()
+//	);
+func WithHeapWriteMode(mode HeapWriteMode) Opts {
+	return func(c *Crypto) error {
+		switch mode {
+		case HeapWriteDirect, HeapWriteCallerTx, HeapWriteOutbox:
+		default:
+			return fmt.Errorf("invalid heap write mode %d", mode)
+		}
+
+		c.heapWriteMode = mode
+		return nil
+	}
+}
+
+// WithHeapOutboxTable overrides the name of the outbox table, heap_outbox by default.
+func WithHeapOutboxTable(name string) Opts {
+	return func(c *Crypto) error {
+		if _, err := sqlsafe.QuoteIdent(name); err != nil {
+			return err
+		}
+
+		c.heapOutboxTable = name
+		return nil
+	}
+}
+
+// BindHeapTx is BindHeapContext for entities written in tx. Where the tokens
+// go depends on the HeapWriteMode.
+func (c *Crypto) BindHeapTx(ctx context.Context, tx *sql.Tx, entity any) (err error) {
+	defer func() { c.audit(ctx, AuditBindHeap, "", err) }()
+
+	return c.bindHeap(ctx, tx, entity, func(ctx context.Context, heaps []TextHeap) error {
+		return c.writeHeapTx(ctx, tx, heaps)
+	})
+}
+
+func (c *Crypto) writeHeapTx(ctx context.Context, tx *sql.Tx, heaps []TextHeap) error {
+	if len(heaps) == 0 {
+		return nil
+	}
+
+	switch c.heapWriteMode {
+	case HeapWriteCallerTx:
+		return c.saveToHeap(ctx, c.txHeapStore(tx), heaps)
+	case HeapWriteOutbox:
+		return c.enqueueHeapOutbox(ctx, tx, heaps)
+	default:
+		store, err := c.heapStore()
+		if err != nil {
+			return err
+		}
+		return c.saveToHeap(ctx, store, heaps)
+	}
+}
+
+// txHeapStore binds the heap store to tx. Without a store able to join
+// transactions the heap tables are expected in the Postgres database of tx.
+func (c *Crypto) txHeapStore(tx *sql.Tx) heapstore.Store {
+	if binder, ok := c.heap.(heapstore.TxBinder); ok {
+		return binder.WithTx(tx)
+	}
+	return heapstore.NewPostgres(tx)
+}
+
+func (c *Crypto) outboxTable() (string, error) {
+	if c.heapOutboxTable == "" {
+		return sqlsafe.QuoteIdent(DefaultHeapOutboxTable)
+	}
+	return sqlsafe.QuoteIdent(c.heapOutboxTable)
+}
+
+func (c *Crypto) enqueueHeapOutbox(ctx context.Context, tx *sql.Tx, heaps []TextHeap) error {
+	outbox, err := c.outboxTable()
+	if err != nil {
+		return err
+	}
+
+	var tables, contents, hashes []string
+	for _, th := range heaps {
+		// fail at enqueue time rather than in the reconciler
+		if _, err := c.heapTable(th.Type); err != nil {
+			return err
+		}
+
+		tables = append(tables, th.Type)
+		contents = append(contents, th.Content)
+		hashes = append(hashes, th.Hash)
+	}
+
+	query := "INSERT INTO " + outbox + " (heap_table, content, hash) SELECT * FROM unnest($1::text[], $2::text[], $3::text[])"
+	if _, err := tx.ExecContext(ctx, query, pq.Array(tables), pq.Array(contents), pq.Array(hashes)); err != nil {
+		return fmt.Errorf("failed to enqueue heap tokens: %w", err)
+	}
+
+	return nil
+}
+
+// ReconcileHeapOutbox moves queued tokens from the outbox table in db to the
+// heap database, batchSize rows per transaction, until the outbox is empty or
+// ctx is done. Rows are locked with SKIP LOCKED, so several reconcilers can
+// run side by side. It returns the number of rows moved.
+func (c *Crypto) ReconcileHeapOutbox(ctx context.Context, db *sql.DB, batchSize int) (total int, err error) {
+	store, err := c.heapStore()
+	if err != nil {
+		return 0, err
+	}
+
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		n, err := c.reconcileHeapOutboxBatch(ctx, db, store, batchSize)
+		total += n
+		if err != nil || n == 0 {
+			return total, err
+		}
+	}
+}
+
+func (c *Crypto) reconcileHeapOutboxBatch(ctx context.Context, db *sql.DB, store heapstore.Store, batchSize int) (n int, err error) {
+	outbox, err := c.outboxTable()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, heap_table, content, hash FROM "+outbox+" ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED", batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		ids   []int64
+		heaps []TextHeap
+	)
+	for rows.Next() {
+		var (
+			id int64
+			th TextHeap
+		)
+		if err = rows.Scan(&id, &th.Type, &th.Content, &th.Hash); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		heaps = append(heaps, th)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, tx.Commit()
+	}
+
+	if err = c.saveToHeap(ctx, store, heaps); err != nil {
+		return 0, fmt.Errorf("failed to save to heap: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM "+outbox+" WHERE id = ANY($1)", pq.Array(ids)); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
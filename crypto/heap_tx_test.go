@@ -0,0 +1,287 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
+	"github.com/lib/pq"
+)
+
+// outboxDriver keeps a heap_outbox table in memory. Changes made in a
+// transaction become visible to other connections on commit.
+type outboxDriver struct{}
+
+type outboxRow struct {
+	id                   int64
+	table, content, hash string
+}
+
+var outbox struct {
+	sync.Mutex
+	rows   []outboxRow
+	nextID int64
+}
+
+type outboxConn struct {
+	staged []outboxRow // nil outside transactions
+	inTx   bool
+}
+
+type outboxStmt struct {
+	conn  *outboxConn
+	query string
+}
+
+type outboxRows struct {
+	rows []outboxRow
+}
+
+func (outboxDriver) Open(string) (driver.Conn, error) { return &outboxConn{}, nil }
+
+func (c *outboxConn) Prepare(query string) (driver.Stmt, error) {
+	return &outboxStmt{conn: c, query: query}, nil
+}
+func (c *outboxConn) Close() error { return nil }
+func (c *outboxConn) Begin() (driver.Tx, error) {
+	outbox.Lock()
+	defer outbox.Unlock()
+	c.staged, c.inTx = append([]outboxRow(nil), outbox.rows...), true
+	return c, nil
+}
+func (c *outboxConn) Commit() error {
+	outbox.Lock()
+	defer outbox.Unlock()
+	outbox.rows, c.staged, c.inTx = c.staged, nil, false
+	return nil
+}
+func (c *outboxConn) Rollback() error {
+	c.staged, c.inTx = nil, false
+	return nil
+}
+
+func (s *outboxStmt) Close() error  { return nil }
+func (s *outboxStmt) NumInput() int { return -1 }
+
+func (s *outboxStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if !s.conn.inTx {
+		return nil, errors.New("outbox test driver: exec outside a transaction")
+	}
+
+	switch {
+	case strings.HasPrefix(s.query, `INSERT INTO "heap_outbox"`):
+		var tables, contents, hashes pq.StringArray
+		for i, dest := range []*pq.StringArray{&tables, &contents, &hashes} {
+			if err := dest.Scan(args[i]); err != nil {
+				return nil, err
+			}
+		}
+
+		outbox.Lock()
+		for i := range tables {
+			outbox.nextID++
+			s.conn.staged = append(s.conn.staged, outboxRow{outbox.nextID, tables[i], contents[i], hashes[i]})
+		}
+		outbox.Unlock()
+		return driver.RowsAffected(len(tables)), nil
+	case strings.HasPrefix(s.query, `DELETE FROM "heap_outbox"`):
+		var ids pq.Int64Array
+		if err := ids.Scan(args[0]); err != nil {
+			return nil, err
+		}
+
+		kept := s.conn.staged[:0]
+		for _, r := range s.conn.staged {
+			if !slices.Contains(ids, r.id) {
+				kept = append(kept, r)
+			}
+		}
+		s.conn.staged = kept
+		return driver.RowsAffected(len(ids)), nil
+	}
+	return nil, errors.New("outbox test driver: unexpected exec " + s.query)
+}
+
+func (s *outboxStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if !strings.Contains(s.query, "FOR UPDATE SKIP LOCKED") || !s.conn.inTx {
+		return nil, errors.New("outbox test driver: unexpected query " + s.query)
+	}
+
+	limit := int(args[0].(int64))
+	rows := s.conn.staged
+	return &outboxRows{rows: append([]outboxRow(nil), rows[:min(limit, len(rows))]...)}, nil
+}
+
+func (*outboxRows) Columns() []string { return []string{"id", "heap_table", "content", "hash"} }
+func (*outboxRows) Close() error      { return nil }
+func (r *outboxRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	dest[0], dest[1], dest[2], dest[3] = row.id, row.table, row.content, row.hash
+	return nil
+}
+
+func init() { sql.Register("crypto_outbox_test", outboxDriver{}) }
+
+func openOutbox(t *testing.T) *sql.DB {
+	t.Helper()
+
+	outbox.Lock()
+	outbox.rows, outbox.nextID = nil, 0
+	outbox.Unlock()
+
+	db, err := sql.Open("crypto_outbox_test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func outboxLen() int {
+	outbox.Lock()
+	defer outbox.Unlock()
+	return len(outbox.rows)
+}
+
+func heapTokens(t *testing.T, store heapstore.Store, table string) []heapstore.Token {
+	t.Helper()
+
+	tokens, err := store.Search(context.Background(), table, []string{"%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestHeapOutbox(t *testing.T) {
+	ctx := context.Background()
+	db := openOutbox(t)
+	store := heapstore.NewMemory()
+	c := newTestCrypto(t, WithHeapStore(store), WithHeapWriteMode(HeapWriteOutbox))
+
+	bind := func(name string, commit bool) {
+		t.Helper()
+
+		before := outboxLen()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := analyzedPerson{Name: c.Encrypt(name, aesx.AesGCM)}
+		if err := c.BindHeapTx(ctx, tx, &p); err != nil {
+			t.Fatal(err)
+		}
+
+		// queued in the caller's transaction only
+		if n := outboxLen(); n != before {
+			t.Fatalf("%d outbox rows visible before commit, want %d", n, before)
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bind("Budi Santoso", true)
+	bind("Siti Rahayu", false)
+	bind("Agus Salim", true)
+
+	if n := outboxLen(); n != 4 {
+		t.Fatalf("%d outbox rows, want 4", n)
+	}
+	if tokens := heapTokens(t, store, "name_heap"); len(tokens) != 0 {
+		t.Fatalf("%d tokens in the heap before reconciling", len(tokens))
+	}
+
+	moved, err := c.ReconcileHeapOutbox(ctx, db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 4 {
+		t.Fatalf("moved %d rows, want 4", moved)
+	}
+	if n := outboxLen(); n != 0 {
+		t.Fatalf("%d outbox rows left", n)
+	}
+
+	var contents []string
+	for _, token := range heapTokens(t, store, "name_heap") {
+		contents = append(contents, token.Content)
+	}
+	for _, want := range []string{"budi", "santoso", "agus", "salim"} {
+		if !strings.Contains(strings.Join(contents, " "), want) {
+			t.Errorf("heap holds %q, missing %q", contents, want)
+		}
+	}
+	if strings.Contains(strings.Join(contents, " "), "siti") {
+		t.Errorf("heap holds tokens of a rolled back entity: %q", contents)
+	}
+
+	if moved, err := c.ReconcileHeapOutbox(ctx, db, 3); err != nil || moved != 0 {
+		t.Fatalf("second run moved %d rows: %v", moved, err)
+	}
+}
+
+// txMemory is a Memory store recording the transaction it was bound to.
+type txMemory struct {
+	*heapstore.Memory
+	tx *sql.Tx
+}
+
+func (m *txMemory) WithTx(tx *sql.Tx) heapstore.Store {
+	m.tx = tx
+	return m.Memory
+}
+
+func TestHeapWriteModes(t *testing.T) {
+	ctx := context.Background()
+	db := openOutbox(t)
+
+	for _, mode := range []HeapWriteMode{HeapWriteDirect, HeapWriteCallerTx} {
+		store := &txMemory{Memory: heapstore.NewMemory()}
+		c := newTestCrypto(t, WithHeapStore(store), WithHeapWriteMode(mode))
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := analyzedPerson{Name: c.Encrypt("Budi", aesx.AesGCM)}
+		if err := c.BindHeapTx(ctx, tx, &p); err != nil {
+			t.Fatal(err)
+		}
+
+		if bound := store.tx == tx; bound != (mode == HeapWriteCallerTx) {
+			t.Errorf("mode %d: store bound to the caller's transaction: %v", mode, bound)
+		}
+		if tokens := heapTokens(t, store, "name_heap"); len(tokens) != 1 {
+			t.Errorf("mode %d: %d tokens, want 1", mode, len(tokens))
+		}
+		if n := outboxLen(); n != 0 {
+			t.Errorf("mode %d: %d outbox rows", mode, n)
+		}
+		tx.Rollback()
+	}
+
+	if err := WithHeapWriteMode(HeapWriteMode(9))(new(Crypto)); err == nil {
+		t.Error("New accepted an unknown heap write mode")
+	}
+	if err := WithHeapOutboxTable("outbox; DROP TABLE x")(new(Crypto)); err == nil {
+		t.Error("WithHeapOutboxTable accepted an invalid identifier")
+	}
+}
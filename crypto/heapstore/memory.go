@@ -0,0 +1,116 @@
+package heapstore
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory keeps heap tokens in memory. It is meant for tests and local
+// development.
+type Memory struct {
+	mu     sync.RWMutex
+	tables map[string]map[string]Token
+	refs   map[Owner][]Ref
+}
+
+var (
+	_ Store    = (*Memory)(nil)
+	_ Migrator = (*Memory)(nil)
+)
+
+func NewMemory() *Memory {
+	return &Memory{tables: make(map[string]map[string]Token), refs: make(map[Owner][]Ref)}
+}
+
+func (m *Memory) Save(_ context.Context, tokens []Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range tokens {
+		table := m.tables[strings.ToLower(t.Table)]
+		if table == nil {
+			table = make(map[string]Token)
+			m.tables[strings.ToLower(t.Table)] = table
+		}
+
+		if _, ok := table[t.Hash]; !ok {
+			table[t.Hash] = t
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) Search(_ context.Context, table string, patterns []string) (tokens []Token, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, t := range m.tables[strings.ToLower(table)] {
+		for _, pattern := range patterns {
+			if MatchLike(strings.ToLower(pattern), strings.ToLower(t.Content)) {
+				tokens = append(tokens, t)
+				break
+			}
+		}
+	}
+
+	// map iteration order is random, keep results stable
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Hash < tokens[j].Hash })
+	return tokens, nil
+}
+
+func (m *Memory) Lookup(_ context.Context, table, hash string) (Token, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.tables[strings.ToLower(table)][hash]
+	return t, ok, nil
+}
+
+func (m *Memory) Delete(_ context.Context, table string, hashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, hash := range hashes {
+		delete(m.tables[strings.ToLower(table)], hash)
+	}
+
+	return nil
+}
+
+// MatchLike reports whether s matches the LIKE pattern, where % matches any
+// sequence, _ any single character and backslash escapes the next character.
+// Matching is case-sensitive.
+func MatchLike(pattern, s string) bool {
+	p, str := []rune(pattern), []rune(s)
+
+	// greedy matching with backtracking to the last %
+	var pi, si, starP, starS = 0, 0, -1, 0
+	for si < len(str) {
+		switch {
+		case pi < len(p) && p[pi] == '%':
+			starP, starS = pi, si
+			pi++
+		case pi < len(p) && p[pi] == '\\' && pi+1 < len(p) && p[pi+1] == str[si]:
+			pi += 2
+			si++
+		case pi < len(p) && p[pi] != '\\' && (p[pi] == '_' || p[pi] == str[si]):
+			pi++
+			si++
+		case starP >= 0:
+			pi = starP + 1
+			starS++
+			si = starS
+		default:
+			return false
+		}
+	}
+
+	for pi < len(p) && p[pi] == '%' {
+		pi++
+	}
+
+	return pi == len(p)
+}
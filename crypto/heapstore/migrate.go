@@ -0,0 +1,286 @@
+package heapstore
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+)
+
+// Migrator is implemented by stores able to create and upgrade heap tables.
+type Migrator interface {
+	// Migrate applies the pending migrations of every table and returns the
+	// ones it applied. Applied versions are kept per table in
+	// heap_schema_migrations.
+	Migrate(ctx context.Context, tables ...string) ([]AppliedMigration, error)
+}
+
+type AppliedMigration struct {
+	Table   string
+	Version int
+	Name    string
+
+	// Skipped, when set, tells why the migration was left for a later run.
+	Skipped string
+}
+
+// migrationFiles holds per dialect the DDL of the version table and the heap
+// table migrations, named <version>_<name>.sql and templated by table name.
+// A migration with a "-- extension: <name>" line needs that extension; when
+// it cannot be created the migration is skipped and tried again by the next
+// Migrate.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+type migration struct {
+	version   int
+	name      string
+	extension string
+	tmpl      *template.Template
+}
+
+func loadMigrations(dialect string) (versions string, migrations []migration, err error) {
+	dir := path.Join("migrations", dialect)
+
+	b, err := migrationFiles.ReadFile(path.Join(dir, "schema_migrations.sql"))
+	if err != nil {
+		return "", nil, err
+	}
+	versions = string(b)
+
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// ReadDir sorts by file name, which orders the zero padded versions
+	for _, entry := range entries {
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			continue
+		}
+
+		b, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return "", nil, err
+		}
+
+		tmpl, err := template.New(entry.Name()).Funcs(template.FuncMap{"index": func(string) string { return "" }}).Parse(string(b))
+		if err != nil {
+			return "", nil, err
+		}
+
+		migrations = append(migrations, migration{version: version, name: name, extension: extensionOf(string(b)), tmpl: tmpl})
+	}
+
+	return versions, migrations, nil
+}
+
+// extensionOf returns the extension named by the "-- extension:" line of sql.
+func extensionOf(sql string) string {
+	for _, line := range strings.Split(sql, "\n") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), "-- extension:"); ok {
+			return strings.TrimSpace(name)
+		}
+	}
+	return ""
+}
+
+// render returns the statements of m for table.
+func (m migration) render(table string) ([]string, error) {
+	quoted, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return nil, err
+	}
+
+	// index names cannot be schema qualified, they live in the schema of the table
+	base := table[strings.LastIndex(table, ".")+1:]
+
+	var indexErr error
+	index := func(suffix string) string {
+		name, err := sqlsafe.QuoteIdent(base + "_" + suffix)
+		if err != nil {
+			indexErr = err
+		}
+		return name
+	}
+
+	b := new(strings.Builder)
+	if err := template.Must(m.tmpl.Clone()).Funcs(template.FuncMap{"index": index}).Execute(b, struct{ Table string }{quoted}); err != nil {
+		return nil, err
+	}
+	if indexErr != nil {
+		return nil, indexErr
+	}
+
+	return splitStatements(b.String()), nil
+}
+
+// splitStatements splits a migration at the semicolons ending a line, as not
+// every driver runs several statements in one call. Comment lines are dropped.
+func splitStatements(sql string) (stmts []string) {
+	var lines []string
+	for _, line := range strings.Split(sql, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";\n") {
+		if stmt = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(stmt), ";")); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	return stmts
+}
+
+func (s *sqlStore) Migrate(ctx context.Context, tables ...string) (applied []AppliedMigration, err error) {
+	if len(tables) == 0 {
+		return nil, errors.New("heapstore: no heap tables to migrate")
+	}
+
+	versions, migrations, err := loadMigrations(s.dialect.name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, table := range tables {
+		err := inTx(ctx, s.db, func(tx DB) error {
+			done, err := s.migrateTable(ctx, tx, versions, migrations, table)
+			applied = append(applied, done...)
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to migrate heap table %s: %w", table, err)
+		}
+	}
+
+	return applied, nil
+}
+
+func (s *sqlStore) migrateTable(ctx context.Context, tx DB, versions string, migrations []migration, table string) ([]AppliedMigration, error) {
+	if s.dialect.lock != "" {
+		if _, err := tx.ExecContext(ctx, s.dialect.lock); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, stmt := range splitStatements(versions) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	table = strings.ToLower(table)
+	current, err := s.appliedVersions(ctx, tx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []AppliedMigration
+	for _, m := range migrations {
+		if _, ok := current[m.version]; ok {
+			continue
+		}
+
+		if m.extension != "" {
+			skipped, err := s.createExtension(ctx, tx, m.extension)
+			if err != nil {
+				return nil, fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+			}
+			if skipped != "" {
+				applied = append(applied, AppliedMigration{Table: table, Version: m.version, Name: m.name, Skipped: skipped})
+				continue
+			}
+		}
+
+		stmts, err := m.render(table)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stmt := range stmts {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return nil, fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+			}
+		}
+
+		query := "INSERT INTO heap_schema_migrations (heap_table, version, name) VALUES (" +
+			s.dialect.placeholder(1) + ", " + s.dialect.placeholder(2) + ", " + s.dialect.placeholder(3) + ")"
+		if _, err := tx.ExecContext(ctx, query, table, m.version, m.name); err != nil {
+			return nil, err
+		}
+
+		applied = append(applied, AppliedMigration{Table: table, Version: m.version, Name: m.name})
+	}
+
+	return applied, nil
+}
+
+// createExtension creates extension unless it exists, and returns why it
+// cannot when the role lacks the privilege or the server the extension.
+func (s *sqlStore) createExtension(ctx context.Context, tx DB, extension string) (skipped string, err error) {
+	if !s.dialect.extensions {
+		return "", fmt.Errorf("%s has no extensions", s.dialect.name)
+	}
+
+	quoted, err := sqlsafe.QuoteIdent(extension)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT heap_extension"); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS "+quoted); err != nil {
+		var state interface{ SQLState() string }
+		// insufficient_privilege, or no such extension on the server
+		if !errors.As(err, &state) || (state.SQLState() != "42501" && state.SQLState() != "58P01") {
+			return "", err
+		}
+
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT heap_extension"); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("cannot create extension %s: %v", extension, err), nil
+	}
+
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT heap_extension")
+	return "", err
+}
+
+func (s *sqlStore) appliedVersions(ctx context.Context, tx DB, table string) (map[int]struct{}, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT version FROM heap_schema_migrations WHERE heap_table = "+s.dialect.placeholder(1), table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int]struct{})
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions[v] = struct{}{}
+	}
+
+	return versions, rows.Err()
+}
+
+// Migrate is a no-op, memory tables need no schema.
+func (m *Memory) Migrate(context.Context, ...string) ([]AppliedMigration, error) {
+	return nil, nil
+}
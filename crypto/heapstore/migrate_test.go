@@ -0,0 +1,119 @@
+package heapstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// recordDriver records the statements it runs, cut short when they have
+// many parameters, and fails CREATE EXTENSION with denyExtension.
+type recordDriver struct{}
+
+var (
+	executed      []string
+	maxArgs       int
+	denyExtension error
+)
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "pq: permission denied to create extension" }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+type recordConn struct{}
+type recordStmt struct{ query string }
+type noRows struct{}
+
+func (recordDriver) Open(string) (driver.Conn, error)        { return recordConn{}, nil }
+func (recordConn) Prepare(query string) (driver.Stmt, error) { return recordStmt{query}, nil }
+func (recordConn) Close() error                              { return nil }
+func (recordConn) Begin() (driver.Tx, error)                 { return recordConn{}, nil }
+func (recordConn) Commit() error                             { return nil }
+func (recordConn) Rollback() error                           { return nil }
+func (recordStmt) Close() error                              { return nil }
+func (recordStmt) NumInput() int                             { return -1 }
+func (s recordStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.HasPrefix(s.query, "CREATE EXTENSION") && denyExtension != nil {
+		return nil, denyExtension
+	}
+
+	maxArgs = max(maxArgs, len(args))
+	if len(args) > 100 {
+		executed = append(executed, s.query[:min(len(s.query), 40)])
+		return driver.RowsAffected(0), nil
+	}
+
+	stmt := s.query
+	for _, arg := range args {
+		stmt += " " + fmt.Sprint(arg)
+	}
+	executed = append(executed, stmt)
+	return driver.RowsAffected(0), nil
+}
+func (recordStmt) Query([]driver.Value) (driver.Rows, error) { return noRows{}, nil }
+func (noRows) Columns() []string                             { return []string{"version"} }
+func (noRows) Close() error                                  { return nil }
+func (noRows) Next([]driver.Value) error                     { return io.EOF }
+
+func init() { sql.Register("heapstore_record_test", recordDriver{}) }
+
+func TestMigrateSkipsMissingExtension(t *testing.T) {
+	db, err := sql.Open("heapstore_record_test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		deny    error
+		skipped bool
+		err     bool
+	}{
+		{"created", nil, false, false},
+		{"insufficient privilege", sqlStateError("42501"), true, false},
+		{"not installed", sqlStateError("58P01"), true, false},
+		{"other error", sqlStateError("08006"), false, true},
+		{"no sql state", errors.New("connection reset"), false, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			executed, denyExtension = nil, tt.deny
+
+			applied, err := NewPostgres(db).Migrate(context.Background(), "email_heap")
+			if tt.err {
+				if err == nil {
+					t.Fatal("migration succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(applied) != 3 {
+				t.Fatalf("got migrations %+v, want 3", applied)
+			}
+			for _, m := range applied {
+				if (m.Skipped != "") != (tt.skipped && m.Version == 2) {
+					t.Fatalf("migration %+v", m)
+				}
+			}
+
+			all := strings.Join(executed, "\n")
+			if strings.Contains(all, "gin_trgm_ops") == tt.skipped {
+				t.Fatalf("trigram index created %v, want %v:\n%s", !tt.skipped, !tt.skipped, all)
+			}
+			if strings.Contains(all, "content_trgm_index") == tt.skipped {
+				t.Fatalf("trigram migration recorded %v, want %v:\n%s", !tt.skipped, !tt.skipped, all)
+			}
+			if tt.skipped && !strings.Contains(all, "ROLLBACK TO SAVEPOINT heap_extension") {
+				t.Fatalf("failed CREATE EXTENSION not rolled back:\n%s", all)
+			}
+		})
+	}
+}
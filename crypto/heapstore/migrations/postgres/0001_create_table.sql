@@ -0,0 +1,7 @@
+-- gen_random_uuid is built in since Postgres 13, older versions need pgcrypto.
+CREATE TABLE IF NOT EXISTS {{.Table}} (
+	id      uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+	content text NOT NULL,
+	hash    text NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS {{index "hash_key"}} ON {{.Table}} (hash);
@@ -0,0 +1,5 @@
+-- extension: pg_trgm
+-- SearchContents matches content with ILIKE ANY and leading wildcards, which
+-- only a trigram index can serve. Roles that may not create pg_trgm skip this
+-- migration until it is installed.
+CREATE INDEX IF NOT EXISTS {{index "content_trgm_idx"}} ON {{.Table}} USING gin (content gin_trgm_ops);
@@ -0,0 +1,13 @@
+-- heap_refs is shared by all heap tables and records which row references
+-- which token, so unreferenced tokens can be garbage collected.
+CREATE TABLE IF NOT EXISTS heap_refs (
+	heap_table   text NOT NULL,
+	hash         text NOT NULL,
+	owner_table  text NOT NULL,
+	owner_column text NOT NULL,
+	row_id       text NOT NULL,
+	subject      text NOT NULL DEFAULT '',
+	PRIMARY KEY (owner_table, row_id, owner_column, heap_table, hash)
+);
+CREATE INDEX IF NOT EXISTS heap_refs_hash_idx ON heap_refs (heap_table, hash);
+CREATE INDEX IF NOT EXISTS heap_refs_subject_idx ON heap_refs (subject) WHERE subject <> '';
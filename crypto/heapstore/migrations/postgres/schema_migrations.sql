@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS heap_schema_migrations (
+	heap_table text NOT NULL,
+	version    integer NOT NULL,
+	name       text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (heap_table, version)
+);
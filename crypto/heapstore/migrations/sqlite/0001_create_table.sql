@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS {{.Table}} (
+	id      TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+	content TEXT NOT NULL,
+	hash    TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS {{index "hash_key"}} ON {{.Table}} (hash);
@@ -0,0 +1,13 @@
+-- heap_refs is shared by all heap tables and records which row references
+-- which token, so unreferenced tokens can be garbage collected.
+CREATE TABLE IF NOT EXISTS heap_refs (
+	heap_table   TEXT NOT NULL,
+	hash         TEXT NOT NULL,
+	owner_table  TEXT NOT NULL,
+	owner_column TEXT NOT NULL,
+	row_id       TEXT NOT NULL,
+	subject      TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (owner_table, row_id, owner_column, heap_table, hash)
+);
+CREATE INDEX IF NOT EXISTS heap_refs_hash_idx ON heap_refs (heap_table, hash);
+CREATE INDEX IF NOT EXISTS heap_refs_subject_idx ON heap_refs (subject) WHERE subject <> '';
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS heap_schema_migrations (
+	heap_table TEXT NOT NULL,
+	version    INTEGER NOT NULL,
+	name       TEXT NOT NULL,
+	applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (heap_table, version)
+);
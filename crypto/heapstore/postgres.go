@@ -0,0 +1,127 @@
+package heapstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+	"github.com/lib/pq"
+)
+
+var postgresDialect = dialect{
+	name:        "postgres",
+	placeholder: sqlsafe.Placeholder,
+	like:        "ILIKE",
+	// the wire protocol counts bind parameters in 16 bits
+	maxParams:  65535,
+	lock:       `SELECT pg_advisory_xact_lock(hashtext('heap_schema_migrations'))`,
+	extensions: true,
+}
+
+// Postgres stores heap tokens in Postgres tables.
+type Postgres struct {
+	sqlStore
+}
+
+var (
+	_ Store     = (*Postgres)(nil)
+	_ TxBinder  = (*Postgres)(nil)
+	_ BulkSaver = (*Postgres)(nil)
+	_ Migrator  = (*Postgres)(nil)
+)
+
+func NewPostgres(db DB) *Postgres {
+	return &Postgres{sqlStore{db: db, dialect: postgresDialect}}
+}
+
+func (p *Postgres) WithTx(tx *sql.Tx) Store {
+	return &Postgres{*p.withTx(tx)}
+}
+
+// Search matches all patterns with a single ILIKE ANY over a text array.
+func (p *Postgres) Search(ctx context.Context, table string, patterns []string) ([]Token, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	quoted, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.query(ctx, table, "SELECT content, hash FROM "+quoted+" WHERE content ILIKE ANY ($1::text[])", pq.Array(patterns))
+}
+
+func (p *Postgres) Delete(ctx context.Context, table string, hashes []string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	quoted, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.db.ExecContext(ctx, "DELETE FROM "+quoted+" WHERE hash = ANY($1)", pq.Array(hashes)); err != nil {
+		return fmt.Errorf("failed to delete from heap table %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// SaveBulk loads tokens with COPY into a temporary staging table per heap
+// table and moves them over with INSERT ... SELECT ... ON CONFLICT (hash) DO
+// NOTHING, as COPY itself cannot skip existing tokens. It needs a *sql.DB or
+// a store bound to a transaction with WithTx.
+func (p *Postgres) SaveBulk(ctx context.Context, tokens []Token) error {
+	return inTx(ctx, p.db, func(db DB) error {
+		return copyTokens(ctx, db.(*sql.Tx), tokens)
+	})
+}
+
+func copyTokens(ctx context.Context, tx *sql.Tx, tokens []Token) error {
+	tables, rows := group(tokens)
+	for _, name := range tables {
+		table, err := sqlsafe.QuoteIdent(name)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `CREATE TEMP TABLE IF NOT EXISTS heap_copy_staging (content text, hash text) ON COMMIT DROP`); err != nil {
+			return err
+		}
+
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn("heap_copy_staging", "content", "hash"))
+		if err != nil {
+			return err
+		}
+
+		for _, t := range rows[name] {
+			if _, err := stmt.ExecContext(ctx, t.Content, t.Hash); err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			stmt.Close()
+			return err
+		}
+
+		if err := stmt.Close(); err != nil {
+			return err
+		}
+
+		query := "INSERT INTO " + table + " (content, hash) SELECT content, hash FROM heap_copy_staging ON CONFLICT (hash) DO NOTHING"
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to copy into heap table %s: %w", name, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `TRUNCATE heap_copy_staging`); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,285 @@
+package heapstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+)
+
+// Owner is a column of a row holding heap tokens in its bidx value.
+type Owner struct {
+	Table  string
+	Column string
+	RowID  string
+}
+
+// Ref records that a row references a token of a heap table.
+type Ref struct {
+	Owner Owner
+	Table string
+	Hash  string
+
+	// Subject is the data subject the row belongs to, used by PurgeSubject.
+	Subject string
+}
+
+// RefStore is implemented by stores tracking which rows reference which
+// tokens, in the heap_refs table created by the migrations.
+//
+// Collecting garbage races with writers reusing a token that is momentarily
+// unreferenced; the next write of such a row restores the token. Run it
+// outside peak hours, and only on heap tables whose references were tracked
+// from the start or backfilled, as untracked tokens count as garbage.
+type RefStore interface {
+	// ReplaceRefs drops the references of owners and stores refs instead.
+	ReplaceRefs(ctx context.Context, owners []Owner, refs []Ref) error
+
+	// DeleteRowRefs drops every reference of a row, e.g. after deleting it.
+	DeleteRowRefs(ctx context.Context, table, rowID string) error
+
+	// CollectGarbage deletes the tokens of table no row references and
+	// returns how many it deleted.
+	CollectGarbage(ctx context.Context, table string) (int64, error)
+
+	// PurgeSubject drops the references of subject and deletes the tokens no
+	// other row references, returning how many tokens it deleted.
+	PurgeSubject(ctx context.Context, subject string) (int64, error)
+}
+
+var (
+	_ RefStore = (*Postgres)(nil)
+	_ RefStore = (*SQLite)(nil)
+	_ RefStore = (*Memory)(nil)
+)
+
+func (s *sqlStore) ReplaceRefs(ctx context.Context, owners []Owner, refs []Ref) error {
+	return inTx(ctx, s.db, func(tx DB) error {
+		for _, o := range owners {
+			query := "DELETE FROM heap_refs WHERE owner_table = " + s.dialect.placeholder(1) +
+				" AND row_id = " + s.dialect.placeholder(2) + " AND owner_column = " + s.dialect.placeholder(3)
+			if _, err := tx.ExecContext(ctx, query, strings.ToLower(o.Table), o.RowID, o.Column); err != nil {
+				return fmt.Errorf("failed to delete heap references: %w", err)
+			}
+		}
+
+		// six parameters per row
+		batch := s.dialect.rowsPerStatement(6)
+		for start := 0; start < len(refs); start += batch {
+			end := min(start+batch, len(refs))
+
+			query := new(strings.Builder)
+			query.WriteString("INSERT INTO heap_refs (heap_table, hash, owner_table, owner_column, row_id, subject) VALUES ")
+
+			args := make([]any, 0, 6*(end-start))
+			for i, r := range refs[start:end] {
+				if i > 0 {
+					query.WriteString(", ")
+				}
+				query.WriteString("(")
+				for j := 1; j <= 6; j++ {
+					if j > 1 {
+						query.WriteString(", ")
+					}
+					query.WriteString(s.dialect.placeholder(len(args) + j))
+				}
+				query.WriteString(")")
+				args = append(args, strings.ToLower(r.Table), r.Hash, strings.ToLower(r.Owner.Table), r.Owner.Column, r.Owner.RowID, r.Subject)
+			}
+			query.WriteString(" ON CONFLICT DO NOTHING")
+
+			if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+				return fmt.Errorf("failed to insert heap references: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *sqlStore) DeleteRowRefs(ctx context.Context, table, rowID string) error {
+	query := "DELETE FROM heap_refs WHERE owner_table = " + s.dialect.placeholder(1) + " AND row_id = " + s.dialect.placeholder(2)
+	if _, err := s.db.ExecContext(ctx, query, strings.ToLower(table), rowID); err != nil {
+		return fmt.Errorf("failed to delete heap references: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) CollectGarbage(ctx context.Context, table string) (int64, error) {
+	return s.deleteUnreferenced(ctx, s.db, table, nil)
+}
+
+// deleteUnreferenced deletes the unreferenced tokens of table, only among
+// hashes when given.
+func (s *sqlStore) deleteUnreferenced(ctx context.Context, db DB, table string, hashes []string) (int64, error) {
+	quoted, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return 0, err
+	}
+
+	query := "DELETE FROM " + quoted + " WHERE NOT EXISTS (SELECT 1 FROM heap_refs r WHERE r.heap_table = " +
+		s.dialect.placeholder(1) + " AND r.hash = " + quoted + ".hash)"
+	args := []any{strings.ToLower(table)}
+
+	if hashes != nil {
+		placeholders := make([]string, len(hashes))
+		for i, hash := range hashes {
+			placeholders[i] = s.dialect.placeholder(i + 2)
+			args = append(args, hash)
+		}
+		query += " AND hash IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to collect garbage of heap table %s: %w", table, err)
+	}
+
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) PurgeSubject(ctx context.Context, subject string) (deleted int64, err error) {
+	err = inTx(ctx, s.db, func(tx DB) error {
+		rows, err := tx.QueryContext(ctx, "SELECT DISTINCT heap_table, hash FROM heap_refs WHERE subject = "+s.dialect.placeholder(1), subject)
+		if err != nil {
+			return err
+		}
+
+		var tables []string
+		hashes := make(map[string][]string)
+		for rows.Next() {
+			var table, hash string
+			if err := rows.Scan(&table, &hash); err != nil {
+				rows.Close()
+				return err
+			}
+			if _, ok := hashes[table]; !ok {
+				tables = append(tables, table)
+			}
+			hashes[table] = append(hashes[table], hash)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM heap_refs WHERE subject = "+s.dialect.placeholder(1), subject); err != nil {
+			return err
+		}
+
+		for _, table := range tables {
+			// the heap table name and one parameter per hash
+			batch := s.dialect.maxParams - 1
+			for start := 0; start < len(hashes[table]); start += batch {
+				end := min(start+batch, len(hashes[table]))
+
+				n, err := s.deleteUnreferenced(ctx, tx, table, hashes[table][start:end])
+				if err != nil {
+					return err
+				}
+				deleted += n
+			}
+		}
+
+		return nil
+	})
+
+	return deleted, err
+}
+
+func (m *Memory) ReplaceRefs(_ context.Context, owners []Owner, refs []Ref) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, o := range owners {
+		delete(m.refs, memoryOwner(o))
+	}
+
+	for _, r := range refs {
+		m.refs[memoryOwner(r.Owner)] = append(m.refs[memoryOwner(r.Owner)], r)
+	}
+
+	return nil
+}
+
+func (m *Memory) DeleteRowRefs(_ context.Context, table, rowID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for o := range m.refs {
+		if o.Table == strings.ToLower(table) && o.RowID == rowID {
+			delete(m.refs, o)
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) CollectGarbage(_ context.Context, table string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.deleteUnreferenced(strings.ToLower(table), nil), nil
+}
+
+func (m *Memory) PurgeSubject(_ context.Context, subject string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := make(map[string][]string)
+	for o, refs := range m.refs {
+		kept := refs[:0]
+		for _, r := range refs {
+			if r.Subject == subject {
+				purged[strings.ToLower(r.Table)] = append(purged[strings.ToLower(r.Table)], r.Hash)
+				continue
+			}
+			kept = append(kept, r)
+		}
+		m.refs[o] = kept
+	}
+
+	var deleted int64
+	for table, hashes := range purged {
+		deleted += m.deleteUnreferenced(table, hashes)
+	}
+
+	return deleted, nil
+}
+
+// deleteUnreferenced expects m.mu to be held.
+func (m *Memory) deleteUnreferenced(table string, hashes []string) (deleted int64) {
+	referenced := make(map[string]struct{})
+	for _, refs := range m.refs {
+		for _, r := range refs {
+			if strings.ToLower(r.Table) == table {
+				referenced[r.Hash] = struct{}{}
+			}
+		}
+	}
+
+	candidates := hashes
+	if candidates == nil {
+		for hash := range m.tables[table] {
+			candidates = append(candidates, hash)
+		}
+	}
+
+	for _, hash := range candidates {
+		if _, ok := referenced[hash]; ok {
+			continue
+		}
+		if _, ok := m.tables[table][hash]; ok {
+			delete(m.tables[table], hash)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+func memoryOwner(o Owner) Owner {
+	o.Table = strings.ToLower(o.Table)
+	return o
+}
@@ -0,0 +1,55 @@
+package heapstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReplaceRefsBatches(t *testing.T) {
+	db, err := sql.Open("heapstore_record_test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		store   RefStore
+		limit   int
+		refs    int
+		inserts int
+	}{
+		{"postgres", NewPostgres(db), 65535, 25000, 3},
+		{"sqlite", NewSQLite(db), 999, 500, 4},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			executed, maxArgs = nil, 0
+
+			owner := Owner{Table: "people", Column: "name_bidx", RowID: "1"}
+			refs := make([]Ref, tt.refs)
+			for i := range refs {
+				refs[i] = Ref{Owner: owner, Table: "name_heap", Hash: fmt.Sprintf("%08x", i)}
+			}
+
+			if err := tt.store.ReplaceRefs(context.Background(), []Owner{owner}, refs); err != nil {
+				t.Fatal(err)
+			}
+
+			if maxArgs > tt.limit {
+				t.Fatalf("a statement has %d parameters, the limit is %d", maxArgs, tt.limit)
+			}
+
+			var inserts int
+			for _, stmt := range executed {
+				if strings.HasPrefix(stmt, "INSERT INTO heap_refs") {
+					inserts++
+				}
+			}
+			if inserts != tt.inserts {
+				t.Fatalf("%d inserts, want %d", inserts, tt.inserts)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package heapstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+)
+
+// dialect holds what differs between the SQL databases.
+type dialect struct {
+	name string
+
+	// placeholder returns the bind parameter for the n-th argument, from 1.
+	placeholder func(n int) string
+
+	// like is the case-insensitive LIKE operator and escape the clause making
+	// backslash its escape character, written after the pattern.
+	like, escape string
+
+	// maxParams is the bind parameter limit of a statement.
+	maxParams int
+
+	// lock, when set, serializes migrations for the rest of the transaction.
+	lock string
+
+	// extensions tells whether CREATE EXTENSION is supported.
+	extensions bool
+}
+
+// rowsPerStatement returns how many rows of params bind parameters each fit
+// in one statement.
+func (d dialect) rowsPerStatement(params int) int {
+	return d.maxParams / params
+}
+
+type sqlStore struct {
+	db      DB
+	dialect dialect
+}
+
+func (s *sqlStore) Save(ctx context.Context, tokens []Token) error {
+	tables, rows := group(tokens)
+	for _, name := range tables {
+		table, err := sqlsafe.QuoteIdent(name)
+		if err != nil {
+			return err
+		}
+
+		heaps := rows[name]
+		batch := s.dialect.rowsPerStatement(2)
+		for start := 0; start < len(heaps); start += batch {
+			end := min(start+batch, len(heaps))
+
+			query := new(strings.Builder)
+			query.WriteString("INSERT INTO ")
+			query.WriteString(table)
+			query.WriteString(" (content, hash) VALUES ")
+
+			args := make([]any, 0, 2*(end-start))
+			for i, t := range heaps[start:end] {
+				if i > 0 {
+					query.WriteString(", ")
+				}
+				query.WriteString("(" + s.dialect.placeholder(len(args)+1) + ", " + s.dialect.placeholder(len(args)+2) + ")")
+				args = append(args, t.Content, t.Hash)
+			}
+			query.WriteString(" ON CONFLICT (hash) DO NOTHING")
+
+			if _, err := s.db.ExecContext(ctx, query.String(), args...); err != nil {
+				return fmt.Errorf("failed to insert into heap table %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *sqlStore) Search(ctx context.Context, table string, patterns []string) ([]Token, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	quoted, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return nil, err
+	}
+
+	query := new(strings.Builder)
+	query.WriteString("SELECT content, hash FROM ")
+	query.WriteString(quoted)
+	query.WriteString(" WHERE ")
+
+	args := make([]any, len(patterns))
+	for i, pattern := range patterns {
+		if i > 0 {
+			query.WriteString(" OR ")
+		}
+		query.WriteString("content " + s.dialect.like + " " + s.dialect.placeholder(i+1) + s.dialect.escape)
+		args[i] = pattern
+	}
+
+	return s.query(ctx, table, query.String(), args...)
+}
+
+func (s *sqlStore) Lookup(ctx context.Context, table, hash string) (Token, bool, error) {
+	quoted, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return Token{}, false, err
+	}
+
+	tokens, err := s.query(ctx, table, "SELECT content, hash FROM "+quoted+" WHERE hash = "+s.dialect.placeholder(1), hash)
+	if err != nil || len(tokens) == 0 {
+		return Token{}, false, err
+	}
+
+	return tokens[0], true, nil
+}
+
+func (s *sqlStore) Delete(ctx context.Context, table string, hashes []string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	quoted, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return err
+	}
+
+	batch := s.dialect.rowsPerStatement(1)
+	for start := 0; start < len(hashes); start += batch {
+		end := min(start+batch, len(hashes))
+
+		placeholders := make([]string, end-start)
+		args := make([]any, end-start)
+		for i, hash := range hashes[start:end] {
+			placeholders[i] = s.dialect.placeholder(i + 1)
+			args[i] = hash
+		}
+
+		query := "DELETE FROM " + quoted + " WHERE hash IN (" + strings.Join(placeholders, ", ") + ")"
+		if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to delete from heap table %s: %w", table, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *sqlStore) query(ctx context.Context, table, query string, args ...any) (tokens []Token, err error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := Token{Table: table}
+		if err = rows.Scan(&t.Content, &t.Hash); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// inTx runs fn in a transaction: db itself when it already is one, a new one
+// when it is a *sql.DB.
+func inTx(ctx context.Context, db DB, fn func(tx DB) error) (err error) {
+	switch db := db.(type) {
+	case *sql.Tx:
+		return fn(db)
+	case *sql.DB:
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	default:
+		return errors.New("heapstore: a *sql.DB or *sql.Tx is required")
+	}
+}
+
+func (s *sqlStore) withTx(tx *sql.Tx) *sqlStore {
+	return &sqlStore{db: tx, dialect: s.dialect}
+}
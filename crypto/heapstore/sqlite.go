@@ -0,0 +1,34 @@
+package heapstore
+
+import "database/sql"
+
+var sqliteDialect = dialect{
+	name:        "sqlite",
+	placeholder: func(int) string { return "?" },
+	// LIKE is case-insensitive for ASCII in SQLite and has no default escape character
+	like:   "LIKE",
+	escape: ` ESCAPE '\'`,
+	// SQLITE_MAX_VARIABLE_NUMBER is 999 before 3.32
+	maxParams: 999,
+}
+
+// SQLite stores heap tokens in SQLite tables. It needs SQLite 3.24 or later
+// for ON CONFLICT; register a driver such as modernc.org/sqlite or
+// github.com/mattn/go-sqlite3 in the application.
+type SQLite struct {
+	sqlStore
+}
+
+var (
+	_ Store    = (*SQLite)(nil)
+	_ TxBinder = (*SQLite)(nil)
+	_ Migrator = (*SQLite)(nil)
+)
+
+func NewSQLite(db DB) *SQLite {
+	return &SQLite{sqlStore{db: db, dialect: sqliteDialect}}
+}
+
+func (s *SQLite) WithTx(tx *sql.Tx) Store {
+	return &SQLite{*s.withTx(tx)}
+}
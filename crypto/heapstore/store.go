@@ -0,0 +1,90 @@
+// Package heapstore persists the text heap: the per-table sets of
+// (content, hash) tokens behind the bidx columns and substring search.
+//
+// Store is implemented for Postgres, SQLite and in memory. The SQL stores work
+// on anything implementing DB, so they can run inside a caller's *sql.Tx.
+//
+// Every heap table holds one row per distinct token and relies on a unique
+// index on hash, which makes writes idempotent and safe for concurrent
+// writers:
+//
+//	CREATE TABLE email_text_heap (
+//		id      uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+//		content text NOT NULL,
+//		hash    text NOT NULL
+//	);
+//	CREATE UNIQUE INDEX email_text_heap_hash_key ON email_text_heap (hash);
+//
+// Tokens are written with one multi-row INSERT ... ON CONFLICT (hash) DO
+// NOTHING per table and call, so existing tokens are skipped by the database
+// instead of a racy SELECT before every INSERT. Without the unique index the
+// statement fails, which surfaces a missing migration instead of silently
+// storing duplicates.
+//
+// Stores implementing Migrator create these tables, the unique index and, on
+// Postgres, a pg_trgm index serving the ILIKE searches.
+package heapstore
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Token is one row of a heap table.
+type Token struct {
+	Table   string
+	Content string
+	Hash    string
+}
+
+type Store interface {
+	// Save stores tokens, skipping hashes already present in their table.
+	Save(ctx context.Context, tokens []Token) error
+
+	// Search returns the tokens of table whose content matches any of the
+	// case-insensitive LIKE patterns, with backslash as escape character.
+	Search(ctx context.Context, table string, patterns []string) ([]Token, error)
+
+	// Lookup returns the token of table with the given hash.
+	Lookup(ctx context.Context, table, hash string) (Token, bool, error)
+
+	// Delete removes the tokens of table with the given hashes.
+	Delete(ctx context.Context, table string, hashes []string) error
+}
+
+// TxBinder is implemented by stores able to run inside a caller's transaction.
+type TxBinder interface {
+	WithTx(tx *sql.Tx) Store
+}
+
+// BulkSaver is implemented by stores with a faster path for large loads.
+type BulkSaver interface {
+	SaveBulk(ctx context.Context, tokens []Token) error
+}
+
+// DB is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type DB interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// group groups tokens by table, keeping the first occurrence of every hash
+// and the order tables were first seen in.
+func group(tokens []Token) (tables []string, rows map[string][]Token) {
+	rows = make(map[string][]Token)
+	seen := make(map[string]map[string]struct{})
+	for _, t := range tokens {
+		if _, ok := seen[t.Table]; !ok {
+			seen[t.Table] = make(map[string]struct{})
+			tables = append(tables, t.Table)
+		}
+
+		if _, ok := seen[t.Table][t.Hash]; ok {
+			continue
+		}
+		seen[t.Table][t.Hash] = struct{}{}
+		rows[t.Table] = append(rows[t.Table], t)
+	}
+
+	return tables, rows
+}
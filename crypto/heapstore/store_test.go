@@ -0,0 +1,362 @@
+package heapstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sqliteDriver runs the statements of the SQLite store against tables in
+// memory. Like SQLite, it fails an upsert whose ON CONFLICT target has no
+// unique index and accepts only ? placeholders.
+type sqliteDriver struct{}
+
+type sqliteTable struct {
+	unique bool
+	rows   []Token
+}
+
+var (
+	sqliteTables     map[string]*sqliteTable
+	sqliteMigrations map[string][]int64
+	sqliteInserts    int
+)
+
+var (
+	createTable    = regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS (\S+) \(`)
+	createUnique   = regexp.MustCompile(`^CREATE UNIQUE INDEX IF NOT EXISTS \S+ ON (\S+) \(hash\)$`)
+	insertTokens   = regexp.MustCompile(`^INSERT INTO (\S+) \(content, hash\) VALUES (\(\?, \?\)(?:, \(\?, \?\))*) ON CONFLICT \(hash\) DO NOTHING$`)
+	selectTokens   = regexp.MustCompile(`^SELECT content, hash FROM (\S+) WHERE (.+)$`)
+	likeCondition  = regexp.MustCompile(`^content LIKE \? ESCAPE '\\'$`)
+	deleteTokens   = regexp.MustCompile(`^DELETE FROM (\S+) WHERE hash IN \(\?(?:, \?)*\)$`)
+	placeholderNum = regexp.MustCompile(`\$\d`)
+)
+
+type sqliteConn struct{}
+type sqliteStmt struct{ query string }
+
+type sqliteRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (sqliteDriver) Open(string) (driver.Conn, error)        { return sqliteConn{}, nil }
+func (sqliteConn) Prepare(query string) (driver.Stmt, error) { return sqliteStmt{query}, nil }
+func (sqliteConn) Close() error                              { return nil }
+func (sqliteConn) Begin() (driver.Tx, error)                 { return sqliteConn{}, nil }
+func (sqliteConn) Commit() error                             { return nil }
+func (sqliteConn) Rollback() error                           { return nil }
+func (sqliteStmt) Close() error                              { return nil }
+func (sqliteStmt) NumInput() int                             { return -1 }
+
+func sqliteTableOf(quoted string) (*sqliteTable, error) {
+	t, ok := sqliteTables[strings.Trim(quoted, `"`)]
+	if !ok {
+		return nil, fmt.Errorf("no such table: %s", quoted)
+	}
+	return t, nil
+}
+
+func (s sqliteStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if placeholderNum.MatchString(s.query) {
+		return nil, errors.New(`near "$": syntax error`)
+	}
+
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS heap_schema_migrations"),
+		strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS heap_refs"),
+		strings.HasPrefix(s.query, "CREATE INDEX IF NOT EXISTS heap_refs_"):
+		return driver.RowsAffected(0), nil
+	case createTable.MatchString(s.query):
+		name := strings.Trim(createTable.FindStringSubmatch(s.query)[1], `"`)
+		if sqliteTables[name] == nil {
+			sqliteTables[name] = &sqliteTable{}
+		}
+		return driver.RowsAffected(0), nil
+	case createUnique.MatchString(s.query):
+		t, err := sqliteTableOf(createUnique.FindStringSubmatch(s.query)[1])
+		if err != nil {
+			return nil, err
+		}
+		t.unique = true
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "INSERT INTO heap_schema_migrations (heap_table, version, name) VALUES (?, ?, ?)"):
+		table := args[0].(string)
+		sqliteMigrations[table] = append(sqliteMigrations[table], args[1].(int64))
+		return driver.RowsAffected(1), nil
+	case insertTokens.MatchString(s.query):
+		t, err := sqliteTableOf(insertTokens.FindStringSubmatch(s.query)[1])
+		if err != nil {
+			return nil, err
+		}
+		if !t.unique {
+			return nil, errors.New("ON CONFLICT clause does not match any PRIMARY KEY or UNIQUE constraint")
+		}
+
+		sqliteInserts++
+		var n int64
+		for i := 0; i < len(args); i += 2 {
+			hash := args[i+1].(string)
+			if !slices.ContainsFunc(t.rows, func(r Token) bool { return r.Hash == hash }) {
+				t.rows = append(t.rows, Token{Content: args[i].(string), Hash: hash})
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	case deleteTokens.MatchString(s.query):
+		t, err := sqliteTableOf(deleteTokens.FindStringSubmatch(s.query)[1])
+		if err != nil {
+			return nil, err
+		}
+		before := len(t.rows)
+		t.rows = slices.DeleteFunc(t.rows, func(r Token) bool { return slices.Contains(args, driver.Value(r.Hash)) })
+		return driver.RowsAffected(before - len(t.rows)), nil
+	}
+
+	return nil, fmt.Errorf("sqlite test driver: unexpected exec %s", s.query)
+}
+
+func (s sqliteStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if placeholderNum.MatchString(s.query) {
+		return nil, errors.New(`near "$": syntax error`)
+	}
+
+	if s.query == "SELECT version FROM heap_schema_migrations WHERE heap_table = ?" {
+		rows := &sqliteRows{columns: []string{"version"}}
+		for _, v := range sqliteMigrations[args[0].(string)] {
+			rows.values = append(rows.values, []driver.Value{v})
+		}
+		return rows, nil
+	}
+
+	m := selectTokens.FindStringSubmatch(s.query)
+	if m == nil {
+		return nil, fmt.Errorf("sqlite test driver: unexpected query %s", s.query)
+	}
+	t, err := sqliteTableOf(m[1])
+	if err != nil {
+		return nil, err
+	}
+
+	match := func(r Token) bool { return r.Hash == args[0] }
+	if m[2] != "hash = ?" {
+		for _, cond := range strings.Split(m[2], " OR ") {
+			if !likeCondition.MatchString(cond) {
+				return nil, fmt.Errorf("sqlite test driver: unexpected condition %s", cond)
+			}
+		}
+		// LIKE folds ASCII case in SQLite
+		match = func(r Token) bool {
+			return slices.ContainsFunc(args, func(pattern driver.Value) bool {
+				return MatchLike(strings.ToLower(pattern.(string)), strings.ToLower(r.Content))
+			})
+		}
+	}
+
+	rows := &sqliteRows{columns: []string{"content", "hash"}}
+	for _, r := range t.rows {
+		if match(r) {
+			rows.values = append(rows.values, []driver.Value{r.Content, r.Hash})
+		}
+	}
+	return rows, nil
+}
+
+func (r *sqliteRows) Columns() []string { return r.columns }
+func (r *sqliteRows) Close() error      { return nil }
+func (r *sqliteRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func init() { sql.Register("heapstore_sqlite_test", sqliteDriver{}) }
+
+// newStores returns an empty Memory store and an SQLite store with tables
+// migrated.
+func newStores(t *testing.T, tables ...string) map[string]Store {
+	t.Helper()
+
+	sqliteTables, sqliteMigrations, sqliteInserts = map[string]*sqliteTable{}, map[string][]int64{}, 0
+
+	db, err := sql.Open("heapstore_sqlite_test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	stores := map[string]Store{"memory": NewMemory(), "sqlite": NewSQLite(db)}
+	for name, store := range stores {
+		if _, err := store.(Migrator).Migrate(context.Background(), tables...); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	return stores
+}
+
+// saveBulk saves tokens like crypto.SaveHeapBulk: with the bulk path of
+// stores implementing BulkSaver, with Save otherwise.
+func saveBulk(ctx context.Context, store Store, tokens []Token) error {
+	if bulk, ok := store.(BulkSaver); ok {
+		return bulk.SaveBulk(ctx, tokens)
+	}
+	return store.Save(ctx, tokens)
+}
+
+func sortedTokens(tokens []Token) []Token {
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Hash < tokens[j].Hash })
+	return tokens
+}
+
+func TestSQLiteMigrationsSkipTrigramIndex(t *testing.T) {
+	newStores(t, "email_heap", "name_heap")
+
+	for _, table := range []string{"email_heap", "name_heap"} {
+		if got := sqliteMigrations[table]; !slices.Equal(got, []int64{1, 3}) {
+			t.Errorf("%s: applied versions %v, want [1 3]", table, got)
+		}
+		if !sqliteTables[table].unique {
+			t.Errorf("%s: no unique index on hash", table)
+		}
+	}
+
+	db, err := sql.Open("heapstore_sqlite_test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	applied, err := NewSQLite(db).Migrate(context.Background(), "email_heap")
+	if err != nil || len(applied) != 0 {
+		t.Errorf("second migration applied %+v, %v", applied, err)
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	stores := newStores(t, "email_heap", "name_heap")
+
+	tokens := []Token{
+		{Table: "email_heap", Content: "budi@mail.com", Hash: "e1"},
+		{Table: "email_heap", Content: "Budiman@Mail.com", Hash: "e2"},
+		{Table: "email_heap", Content: "siti@mail.com", Hash: "e3"},
+		{Table: "email_heap", Content: "budi@mail.com", Hash: "e1"},
+		{Table: "name_heap", Content: "100%", Hash: "n1"},
+		{Table: "name_heap", Content: "1000", Hash: "n2"},
+		{Table: "name_heap", Content: "a_b", Hash: "n3"},
+		{Table: "name_heap", Content: "axb", Hash: "n4"},
+	}
+
+	searches := []struct {
+		table    string
+		patterns []string
+		want     []string
+	}{
+		{"email_heap", []string{"%budi%"}, []string{"e1", "e2"}},
+		{"email_heap", []string{"BUDI@MAIL.COM"}, []string{"e1"}},
+		{"email_heap", []string{"siti%", "%man@%"}, []string{"e2", "e3"}},
+		{"email_heap", []string{"%agus%"}, nil},
+		{"name_heap", []string{`100\%`}, []string{"n1"}},
+		{"name_heap", []string{"100%"}, []string{"n1", "n2"}},
+		{"name_heap", []string{`a\_b`}, []string{"n3"}},
+		{"name_heap", []string{"a_b"}, []string{"n3", "n4"}},
+	}
+
+	results := map[string][]Token{}
+	for name, store := range stores {
+		// saving twice keeps one token per hash
+		for range 2 {
+			if err := store.Save(ctx, tokens); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		for _, s := range searches {
+			found, err := store.Search(ctx, s.table, s.patterns)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			var hashes []string
+			for _, tok := range sortedTokens(found) {
+				hashes = append(hashes, tok.Hash)
+			}
+			if !slices.Equal(hashes, s.want) {
+				t.Errorf("%s: search %s %q = %q, want %q", name, s.table, s.patterns, hashes, s.want)
+			}
+			results[name] = append(results[name], found...)
+		}
+
+		tok, ok, err := store.Lookup(ctx, "email_heap", "e2")
+		if err != nil || !ok || tok != (Token{Table: "email_heap", Content: "Budiman@Mail.com", Hash: "e2"}) {
+			t.Errorf("%s: lookup = %+v, %v, %v", name, tok, ok, err)
+		}
+		if _, ok, err := store.Lookup(ctx, "name_heap", "e2"); ok || err != nil {
+			t.Errorf("%s: lookup found e2 in name_heap, %v", name, err)
+		}
+
+		if err := store.Delete(ctx, "email_heap", []string{"e1", "e3", "missing"}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		found, err := store.Search(ctx, "email_heap", []string{"%"})
+		if err != nil || len(found) != 1 || found[0].Hash != "e2" {
+			t.Errorf("%s: after delete %+v, %v", name, found, err)
+		}
+	}
+
+	if !slices.Equal(results["memory"], results["sqlite"]) {
+		t.Errorf("stores answer differently:\nmemory %+v\nsqlite %+v", results["memory"], results["sqlite"])
+	}
+}
+
+func TestStoreBulkRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	stores := newStores(t, "email_heap")
+
+	// more rows than one SQLite statement binds, with every token twice
+	var tokens []Token
+	for i := range 1200 {
+		tokens = append(tokens, Token{Table: "email_heap", Content: fmt.Sprintf("user%04d@mail.com", i), Hash: fmt.Sprintf("%04d", i)})
+	}
+	tokens = append(tokens, tokens...)
+
+	results := map[string][]Token{}
+	for name, store := range stores {
+		if err := saveBulk(ctx, store, tokens); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		found, err := store.Search(ctx, "email_heap", []string{"user%"})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !slices.Equal(sortedTokens(found), tokens[:1200]) {
+			t.Errorf("%s: %d tokens found, want the 1200 saved", name, len(found))
+		}
+		results[name] = found
+
+		found, err = store.Search(ctx, "email_heap", []string{"user11%"})
+		if err != nil || len(found) != 100 {
+			t.Errorf("%s: %d tokens found for user11%%, want 100, %v", name, len(found), err)
+		}
+	}
+
+	// 1200 unique tokens at 499 rows per statement
+	if sqliteInserts != 3 {
+		t.Errorf("sqlite ran %d inserts, want 3", sqliteInserts)
+	}
+	if !slices.Equal(results["memory"], results["sqlite"]) {
+		t.Error("stores answer differently")
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/dyaksa/encryption-pii/crypto/core"
 )
 
+var ErrHMACKeyTooShort = core.ErrHMACKeyTooShort
+
 var _ interface {
 	sql.Scanner
 	driver.Value
@@ -26,31 +28,26 @@ type HMAC[T any, H hash.Hash] struct {
 	v T
 }
 
+// HashString returns the last 8 hex digits of the HMAC, or "" on failure.
+//
+// Deprecated: a failure yields an empty value that matches every other
+// failed hash; use HashStringErr.
 func (h HMAC[T, H]) HashString() (str string) {
-	m, err := h.hmacFunc()
-	if err != nil {
-		return ""
-	}
-
-	b, err := h.btov(h.v)
-	if err != nil {
-		return ""
-	}
+	str, _ = h.HashStringErr()
+	return str
+}
 
-	_, err = m.Write(b)
+// HashStringErr returns the last 8 hex digits of the HMAC.
+func (h HMAC[T, H]) HashStringErr() (string, error) {
+	b, err := h.sum()
 	if err != nil {
-		return ""
-	}
-
-	str = fmt.Sprintf("%x", m.Sum(nil))
-	if len(str) > 8 {
-		return str[len(str)-8:]
+		return "", err
 	}
 
-	return str
+	return To[T, H]{b: b}.ToLast8DigitValue(), nil
 }
 
-func (h HMAC[T, H]) Value() (driver.Value, error) {
+func (h HMAC[T, H]) sum() ([]byte, error) {
 	m, err := h.hmacFunc()
 	if err != nil {
 		return nil, err
@@ -69,6 +66,15 @@ func (h HMAC[T, H]) Value() (driver.Value, error) {
 	return m.Sum(nil), nil
 }
 
+func (h HMAC[T, H]) Value() (driver.Value, error) {
+	b, err := h.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
 func (h *HMAC[T, H]) Scan(value interface{}) error {
 	if value == nil {
 		return nil
@@ -101,25 +107,23 @@ type To[T any, H hash.Hash] struct {
 	b []byte
 }
 
+// Hash returns the HMAC, or an empty value on failure.
+//
+// Deprecated: a failure yields an empty value that matches every other
+// failed hash; use HashErr.
 func (h HMAC[T, H]) Hash() To[T, H] {
-	t := To[T, H]{}
-	m, err := h.hmacFunc()
-	if err != nil {
-		return t
-	}
-
-	b, err := h.btov(h.v)
-	if err != nil {
-		return t
-	}
+	t, _ := h.HashErr()
+	return t
+}
 
-	_, err = m.Write(b)
+// HashErr returns the HMAC.
+func (h HMAC[T, H]) HashErr() (To[T, H], error) {
+	b, err := h.sum()
 	if err != nil {
-		return t
+		return To[T, H]{}, err
 	}
 
-	t.b = m.Sum(nil)
-	return t
+	return To[T, H]{b: b}, nil
 }
 
 func (t To[T, H]) ToString() string {
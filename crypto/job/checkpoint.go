@@ -0,0 +1,104 @@
+package job
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+)
+
+// DefaultCheckpointTable is the table used by NewPostgres without name.
+const DefaultCheckpointTable = "job_checkpoints"
+
+// Checkpoints stores the cursor of jobs by name. db is the transaction of
+// the batch whose cursor is saved, or the database the job runs on.
+type Checkpoints interface {
+	// Load returns the saved cursor of job, "" when there is none.
+	Load(ctx context.Context, db DB, job string) (string, error)
+	Save(ctx context.Context, db DB, job, cursor string) error
+}
+
+// TxCheckpoints is implemented by Checkpoints saved in the transaction of
+// their batch, so a committed batch is never run again.
+type TxCheckpoints interface {
+	Checkpoints
+
+	// InBatchTx marks Save as writing through the transaction it is given.
+	InBatchTx()
+}
+
+// Memory keeps checkpoints in memory, for tests and one-off runs.
+type Memory struct {
+	mu      sync.Mutex
+	cursors map[string]string
+}
+
+func NewMemory() *Memory {
+	return &Memory{cursors: make(map[string]string)}
+}
+
+func (m *Memory) Load(_ context.Context, _ DB, job string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.cursors[job], nil
+}
+
+func (m *Memory) Save(_ context.Context, _ DB, job, cursor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cursors[job] = cursor
+	return nil
+}
+
+// Postgres keeps checkpoints in a Postgres table of the database the job runs on,
+// created on first use:
+//
+//	CREATE TABLE job_checkpoints (
+//		name text PRIMARY KEY,
+//		last_key text NOT NULL,
+//		updated_at timestamptz NOT NULL DEFAULT now()
+//	);
+type Postgres struct {
+	table string
+}
+
+// NewPostgres keeps checkpoints in table, DefaultCheckpointTable when empty.
+func NewPostgres(table string) (*Postgres, error) {
+	if table == "" {
+		table = DefaultCheckpointTable
+	}
+
+	quoted, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Postgres{table: quoted}, nil
+}
+
+func (*Postgres) InBatchTx() {}
+
+func (s *Postgres) Load(ctx context.Context, db DB, job string) (string, error) {
+	create := "CREATE TABLE IF NOT EXISTS " + s.table + " (name text PRIMARY KEY, last_key text NOT NULL, updated_at timestamptz NOT NULL DEFAULT now())"
+	if _, err := db.ExecContext(ctx, create); err != nil {
+		return "", err
+	}
+
+	var cursor string
+	err := db.QueryRowContext(ctx, "SELECT last_key FROM "+s.table+" WHERE name = $1", job).Scan(&cursor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return cursor, err
+}
+
+func (s *Postgres) Save(ctx context.Context, db DB, job, cursor string) error {
+	query := "INSERT INTO " + s.table + " (name, last_key) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET last_key = EXCLUDED.last_key, updated_at = now()"
+	_, err := db.ExecContext(ctx, query, job, cursor)
+	return err
+}
@@ -0,0 +1,199 @@
+// Package job runs resumable batch jobs over database tables such as blind
+// index rebuilds and re-encryption.
+//
+// A job walks a table in key order with keyset pagination, each batch in its
+// own transaction. After a batch the key of its last row is saved as the
+// checkpoint, in the same transaction for checkpoints kept in the database,
+// so an interrupted job resumes after the last committed batch. Batches must
+// be safe to repeat, as a batch may run again when saving its checkpoint
+// fails.
+package job
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+)
+
+const (
+	DefaultKey       = "id"
+	DefaultBatchSize = 500
+)
+
+// DB is the subset of *sql.DB and *sql.Tx used by checkpoints.
+type DB interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Config describes the table a job walks.
+type Config struct {
+	// Name identifies the checkpoint. A finished job keeps its checkpoint,
+	// so running it again only visits rows added since; use a new name to
+	// start over.
+	Name  string
+	Table string
+
+	// Key is the unique column rows are ordered by, id by default.
+	Key       string
+	BatchSize int
+
+	// Checkpoints records progress; without it the job starts from the
+	// first row every time.
+	Checkpoints Checkpoints
+
+	// DryRun rolls every batch back and saves no checkpoint.
+	DryRun bool
+
+	// Progress is called after every batch.
+	Progress func(Stats)
+}
+
+func (cfg Config) key() string {
+	if cfg.Key == "" {
+		return DefaultKey
+	}
+	return cfg.Key
+}
+
+func (cfg Config) batchSize() int {
+	if cfg.BatchSize <= 0 {
+		return DefaultBatchSize
+	}
+	return cfg.BatchSize
+}
+
+// Stats reports the progress of a job.
+type Stats struct {
+	Batches int64
+	Rows    int64
+
+	// Cursor is the key of the last row processed.
+	Cursor  string
+	Elapsed time.Duration
+}
+
+// Batch processes at most limit rows with a key after cursor, or from the
+// first row when cursor is empty, in tx and returns the key of the last one
+// and how many it processed.
+type Batch func(ctx context.Context, tx *sql.Tx, cursor string, limit int) (last string, n int, err error)
+
+// Run calls batch until the table is exhausted or ctx is done.
+func Run(ctx context.Context, db *sql.DB, cfg Config, batch Batch) (stats Stats, err error) {
+	if cfg.Name == "" {
+		return stats, errors.New("job name is required")
+	}
+
+	start := time.Now()
+	if cfg.Checkpoints != nil {
+		if stats.Cursor, err = cfg.Checkpoints.Load(ctx, db, cfg.Name); err != nil {
+			return stats, fmt.Errorf("failed to load checkpoint of %s: %w", cfg.Name, err)
+		}
+	}
+
+	limit := cfg.batchSize()
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		n, last, err := runBatch(ctx, db, cfg, batch, stats.Cursor, limit)
+		if err != nil {
+			return stats, fmt.Errorf("job %s: batch after %q: %w", cfg.Name, stats.Cursor, err)
+		}
+		if n == 0 {
+			return stats, nil
+		}
+
+		stats.Batches++
+		stats.Rows += int64(n)
+		stats.Cursor = last
+		stats.Elapsed = time.Since(start)
+		if cfg.Progress != nil {
+			cfg.Progress(stats)
+		}
+
+		if n < limit {
+			return stats, nil
+		}
+	}
+}
+
+func runBatch(ctx context.Context, db *sql.DB, cfg Config, batch Batch, cursor string, limit int) (n int, last string, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() {
+		if err != nil || cfg.DryRun || n == 0 {
+			tx.Rollback()
+		}
+	}()
+
+	last, n, err = batch(ctx, tx, cursor, limit)
+	if err != nil || n == 0 || cfg.DryRun {
+		return n, last, err
+	}
+
+	if cfg.Checkpoints != nil {
+		if err = cfg.Checkpoints.Save(ctx, tx, cfg.Name, last); err != nil {
+			return n, last, fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+	}
+
+	return n, last, tx.Commit()
+}
+
+// Page returns the query selecting at most limit rows of table with a key
+// after cursor, or from the first row when cursor is empty, ordered by key.
+// The cursor is bound as text and cast by the database to the key's type.
+func Page(table, key string, columns []string, cursor string, limit int) (string, []interface{}, error) {
+	quotedTable, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return "", nil, err
+	}
+
+	quotedKey, err := sqlsafe.QuoteIdent(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	quoted, err := sqlsafe.QuoteIdents(columns...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := "SELECT " + strings.Join(quoted, ", ") + " FROM " + quotedTable
+	if cursor == "" {
+		return query + " ORDER BY " + quotedKey + " LIMIT $1", []interface{}{limit}, nil
+	}
+
+	query += " WHERE " + quotedKey + " > $1 ORDER BY " + quotedKey + " LIMIT $2"
+	return query, []interface{}{cursor, limit}, nil
+}
+
+// Update returns the statement setting columns, numbered from $1, of the row
+// of table whose key is the last argument.
+func Update(table, key string, columns []string) (string, error) {
+	quotedTable, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return "", err
+	}
+
+	quoted, err := sqlsafe.QuoteIdents(append(columns[:len(columns):len(columns)], key)...)
+	if err != nil {
+		return "", err
+	}
+
+	sets := make([]string, len(columns))
+	for i := range columns {
+		sets[i] = quoted[i] + " = " + sqlsafe.Placeholder(i+1)
+	}
+
+	return "UPDATE " + quotedTable + " SET " + strings.Join(sets, ", ") + " WHERE " + quoted[len(columns)] + " = " + sqlsafe.Placeholder(len(columns)+1), nil
+}
@@ -0,0 +1,78 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallel(t *testing.T) {
+	for _, tt := range []struct {
+		workers, n int
+	}{
+		{0, 100},
+		{1, 10},
+		{4, 100},
+		{8, 3},
+		{4, 0},
+	} {
+		calls := make([]atomic.Int32, tt.n)
+		err := Parallel(context.Background(), tt.workers, tt.n, func(i int) error {
+			calls[i].Add(1)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("workers %d, n %d: %v", tt.workers, tt.n, err)
+		}
+
+		for i := range calls {
+			if got := calls[i].Load(); got != 1 {
+				t.Errorf("workers %d, n %d: index %d called %d times", tt.workers, tt.n, i, got)
+			}
+		}
+	}
+}
+
+func TestParallelStopsOnError(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	var calls atomic.Int32
+	err := Parallel(context.Background(), 2, 1000, func(i int) error {
+		calls.Add(1)
+		if i == 10 {
+			return errFailed
+		}
+		return nil
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("got %v, want %v", err, errFailed)
+	}
+
+	// the feed may win a few selects against the cancellation, not hundreds
+	if got := calls.Load(); got > 100 {
+		t.Errorf("%d calls after failing at index 10", got)
+	}
+}
+
+func TestParallelStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var calls atomic.Int32
+	err := Parallel(ctx, 2, 1000, func(i int) error {
+		if calls.Add(1) == 5 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if got := calls.Load(); got > 100 {
+		t.Errorf("%d calls after cancelling at the fifth", got)
+	}
+
+	if err := Parallel(ctx, 2, 10, func(int) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled context: got %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,110 @@
+package crypto
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/config"
+	"github.com/dyaksa/encryption-pii/crypto/keyfile"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+)
+
+var testKDF = keyfile.KDFParams{Name: keyfile.KDFScrypt, N: 1 << 10, R: 8, P: 1}
+
+func writeTestKeyFile(t *testing.T, aesPrimary uint32, aesKeys ...keyfile.Key) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	ks := keyfile.Keyset{
+		AES:  keyfile.Ring{Primary: aesPrimary, Keys: aesKeys},
+		HMAC: keyfile.Ring{Primary: 1, Keys: []keyfile.Key{{ID: 1, Material: []byte(testHMACKey)}}},
+	}
+	if err := keyfile.WriteFile(path, ks, []byte("passphrase"), testKDF); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func openTestKeyFile(path string) (*Crypto, error) {
+	return New(&config.Config{}, WithKeyFile(path, func() ([]byte, error) { return []byte("passphrase"), nil }))
+}
+
+func TestKeyFileDecryptsWithOldAESKeys(t *testing.T) {
+	oldKey := keyfile.Key{ID: 1, Material: []byte("0123456789abcdef0123456789abcdef")}
+	newKey := keyfile.Key{ID: 2, Material: []byte("abcdef0123456789abcdef0123456789")}
+
+	before, err := openTestKeyFile(writeTestKeyFile(t, 1, oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := openTestKeyFile(writeTestKeyFile(t, 2, oldKey, newKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newOnly, err := openTestKeyFile(writeTestKeyFile(t, 2, newKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alg := range []aesx.AesAlg{aesx.AesGCM, aesx.AesCBC} {
+		written, err := before.Encrypt("Budi Santoso", alg).Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		read := after.Decrypt(alg)
+		err = read.Scan(written)
+		switch {
+		case alg == aesx.AesGCM && (err != nil || read.To() != "Budi Santoso"):
+			t.Errorf("%s: old ciphertext read %q, %v", alg, read.To(), err)
+		case alg == aesx.AesCBC && err == nil && read.To() == "Budi Santoso":
+			t.Errorf("%s: old ciphertext decrypted without key IDs", alg)
+		}
+
+		if alg != aesx.AesGCM {
+			continue
+		}
+
+		probe := newOnly.Decrypt(alg)
+		if err := probe.Scan(written); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("%s: keyset without the old key read old ciphertext: %v", alg, err)
+		}
+
+		rewritten, err := after.Encrypt("Budi Santoso", alg).Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := probe.Scan(rewritten); err != nil || probe.To() != "Budi Santoso" {
+			t.Errorf("%s: new ciphertext is not under the primary key: %q, %v", alg, probe.To(), err)
+		}
+
+		var i types.AESInt64 = after.DecryptInt64(alg)
+		n, _ := before.EncryptInt64(42, alg).Value()
+		if err := i.Scan(n); err != nil || i.To() != 42 {
+			t.Errorf("%s: old int64 ciphertext read %d, %v", alg, i.To(), err)
+		}
+	}
+}
+
+func TestKeyFileValidatesEveryKey(t *testing.T) {
+	primary := keyfile.Key{ID: 1, Material: []byte("0123456789abcdef0123456789abcdef")}
+
+	// keys of invalid size cannot be written
+	short := keyfile.Key{ID: 2, Material: []byte("0123456")}
+	ks := keyfile.Keyset{
+		AES:  keyfile.Ring{Primary: 1, Keys: []keyfile.Key{primary, short}},
+		HMAC: keyfile.Ring{Primary: 1, Keys: []keyfile.Key{{ID: 1, Material: []byte(testHMACKey)}}},
+	}
+	if err := keyfile.WriteFile(filepath.Join(t.TempDir(), "keys.json"), ks, []byte("passphrase"), testKDF); !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("aes key of 7 bytes: %v, want ErrInvalidKeySize", err)
+	}
+
+	// keys of another size than configured are found when loading
+	path := writeTestKeyFile(t, 1, primary, keyfile.Key{ID: 2, Material: []byte("0123456789abcdef")})
+	_, err := New(&config.Config{AesKeySize: 32}, WithKeyFile(path, func() ([]byte, error) { return []byte("passphrase"), nil }))
+	if !errors.Is(err, ErrInvalidKeySize) {
+		t.Errorf("aes key of 16 bytes with 32 configured: %v, want ErrInvalidKeySize", err)
+	}
+}
@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"strings"
+)
+
+// WithDualKeyLookup makes the search APIs match blind indexes computed under
+// any HMAC key of the keyset, not only the primary one, for the cut-over
+// while Reindex moves rows to a new key. Load the keyset with WithKeyFile,
+// the new key as primary. Conditions then OR one match per key, so turn the
+// option off again once the reindex is done.
+func WithDualKeyLookup() Opts {
+	return func(c *Crypto) error {
+		c.dualKeyLookup = true
+		return nil
+	}
+}
+
+// lookupKeys returns c or, with dual key lookups, a copy of c for every HMAC
+// key of the keyset, the primary first.
+func (c *Crypto) lookupKeys() ([]*Crypto, error) {
+	if !c.dualKeyLookup {
+		return []*Crypto{c}, nil
+	}
+
+	primary := *c
+	primary.dualKeyLookup = false
+
+	ids := c.hmac.KeyIDs()
+	keys := []*Crypto{&primary}
+	for _, id := range ids[1:] {
+		hmac, err := c.hmac.WithPrimary(id)
+		if err != nil {
+			return nil, err
+		}
+
+		k := *c
+		k.hmac, k.dualKeyLookup = &hmac, false
+		keys = append(keys, &k)
+	}
+
+	return keys, nil
+}
+
+// anyKey ORs the conditions built by build for every lookup key, numbering
+// their arguments on from startAt.
+func anyKey(keys []*Crypto, startAt int, build func(k *Crypto, startAt int) (string, []interface{}, error)) (string, []interface{}, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, k := range keys {
+		cond, a, err := build(k, startAt+len(args))
+		if err != nil {
+			return "", nil, err
+		}
+		conds = append(conds, cond)
+		args = append(args, a...)
+	}
+
+	return "(" + strings.Join(conds, " OR ") + ")", args, nil
+}
+
+// LookupHashes returns the 8 digit hash of data under every lookup key, the
+// primary first, for matching with = ANY.
+func (c *Crypto) LookupHashes(data string) ([]string, error) {
+	return c.lookupHashes(data, (*Crypto).HashErr)
+}
+
+// LookupHashStrings returns the full HMAC of data under every lookup key, the
+// primary first, for matching with = ANY.
+func (c *Crypto) LookupHashStrings(data string) ([]string, error) {
+	return c.lookupHashes(data, (*Crypto).HashStringErr)
+}
+
+func (c *Crypto) lookupHashes(data string, hash func(*Crypto, string) (string, error)) ([]string, error) {
+	keys, err := c.lookupKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(keys))
+	for i, k := range keys {
+		if hashes[i], err = hash(k, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,101 @@
+package keyfile
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	KDFArgon2id = "argon2id"
+	KDFScrypt   = "scrypt"
+)
+
+const (
+	saltSize   = 16
+	wrapKeyLen = 32
+)
+
+// KDFParams describes how the wrapping key is derived from the passphrase.
+// They are stored in the keyfile header so a file can always be opened with
+// the parameters it was written with.
+type KDFParams struct {
+	Name string `json:"name"`
+	Salt []byte `json:"salt"`
+
+	// argon2id
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+
+	// scrypt
+	N int `json:"n,omitempty"`
+	R int `json:"r,omitempty"`
+	P int `json:"p,omitempty"`
+}
+
+// DefaultArgon2id follows the second recommended option of RFC 9106 (64 MiB, t=3).
+func DefaultArgon2id() KDFParams {
+	return KDFParams{
+		Name:    KDFArgon2id,
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+	}
+}
+
+func DefaultScrypt() KDFParams {
+	return KDFParams{
+		Name: KDFScrypt,
+		N:    1 << 15,
+		R:    8,
+		P:    1,
+	}
+}
+
+func (p KDFParams) validate() error {
+	switch p.Name {
+	case KDFArgon2id:
+		if p.Time == 0 || p.Memory == 0 || p.Threads == 0 {
+			return errors.New("keyfile: argon2id requires time, memory and threads")
+		}
+	case KDFScrypt:
+		if p.N <= 1 || p.N&(p.N-1) != 0 || p.R <= 0 || p.P <= 0 {
+			return errors.New("keyfile: scrypt requires n as a power of two, r and p")
+		}
+	default:
+		return fmt.Errorf("keyfile: unsupported kdf %q", p.Name)
+	}
+
+	return nil
+}
+
+// withSalt returns a copy of p with a fresh random salt.
+func (p KDFParams) withSalt() (KDFParams, error) {
+	p.Salt = make([]byte, saltSize)
+	if _, err := rand.Read(p.Salt); err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+func (p KDFParams) derive(passphrase []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	if len(p.Salt) < saltSize {
+		return nil, errors.New("keyfile: kdf salt too short")
+	}
+
+	switch p.Name {
+	case KDFArgon2id:
+		return argon2.IDKey(passphrase, p.Salt, p.Time, p.Memory, p.Threads, wrapKeyLen), nil
+	default:
+		return scrypt.Key(passphrase, p.Salt, p.N, p.R, p.P, wrapKeyLen)
+	}
+}
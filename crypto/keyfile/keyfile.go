@@ -0,0 +1,286 @@
+// Package keyfile reads and writes keysets encrypted under a passphrase.
+//
+// A keyfile is a JSON document whose header records the format version, the
+// key derivation function with its parameters, and the cipher. The keyset
+// itself is JSON sealed with AES-256-GCM under a key derived from the
+// passphrase, with the encoded header as additional data so it cannot be
+// altered without detection.
+package keyfile
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dyaksa/encryption-pii/crypto/core"
+)
+
+const (
+	Version      = 1
+	CipherAESGCM = "aes-256-gcm"
+)
+
+var (
+	ErrWrongPassphrase = errors.New("keyfile: wrong passphrase or corrupted keyfile")
+	ErrEmptyPassphrase = errors.New("keyfile: passphrase is empty")
+)
+
+// Key is a single key of a ring, its material is base64 encoded in JSON.
+type Key struct {
+	ID       uint32 `json:"id"`
+	Material []byte `json:"key"`
+}
+
+// Ring is a list of keys with the one used for new data marked as primary.
+type Ring struct {
+	Primary uint32 `json:"primary"`
+	Keys    []Key  `json:"keys"`
+}
+
+// Keyset holds the AES and HMAC rings stored in a keyfile.
+type Keyset struct {
+	AES  Ring `json:"aes"`
+	HMAC Ring `json:"hmac"`
+}
+
+// Header is the unencrypted part of a keyfile.
+type Header struct {
+	Version int       `json:"version"`
+	KDF     KDFParams `json:"kdf"`
+	Cipher  string    `json:"cipher"`
+	Nonce   []byte    `json:"nonce"`
+}
+
+type file struct {
+	Header
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// CoreKeys converts the ring into the form accepted by core.NewMultiKeySet.
+func (r Ring) CoreKeys() []core.Key {
+	keys := make([]core.Key, len(r.Keys))
+	for i, k := range r.Keys {
+		keys[i] = core.Key{ID: k.ID, Material: k.Material}
+	}
+
+	return keys
+}
+
+// PrimaryKey returns the material of the primary key.
+func (r Ring) PrimaryKey() ([]byte, error) {
+	for _, k := range r.Keys {
+		if k.ID == r.Primary {
+			return k.Material, nil
+		}
+	}
+
+	return nil, fmt.Errorf("keyfile: primary key id %d not found", r.Primary)
+}
+
+func (r Ring) validate(name string, check func([]byte) error) error {
+	if len(r.Keys) == 0 {
+		return fmt.Errorf("keyfile: %s ring has no keys", name)
+	}
+
+	if _, err := r.PrimaryKey(); err != nil {
+		return fmt.Errorf("keyfile: %s ring: %w", name, err)
+	}
+
+	for _, k := range r.Keys {
+		if err := check(k.Material); err != nil {
+			return fmt.Errorf("keyfile: %s key %d: %w", name, k.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks both rings hold a primary key and every key has a valid
+// size.
+func (ks Keyset) Validate() error {
+	if err := ks.AES.validate("aes", core.CheckAESKey); err != nil {
+		return err
+	}
+
+	return ks.HMAC.validate("hmac", core.CheckHMACKey)
+}
+
+// Generate creates a keyset with one random AES key of aesKeySize bytes, 16,
+// 24 or 32, and one random 32 byte HMAC key, both with ID 1.
+func Generate(aesKeySize int) (Keyset, error) {
+	if err := core.CheckAESKey(make([]byte, max(aesKeySize, 0))); err != nil {
+		return Keyset{}, fmt.Errorf("keyfile: %w", err)
+	}
+
+	aesKey := make([]byte, aesKeySize)
+	if _, err := rand.Read(aesKey); err != nil {
+		return Keyset{}, err
+	}
+
+	hmacKey := make([]byte, 32)
+	if _, err := rand.Read(hmacKey); err != nil {
+		return Keyset{}, err
+	}
+
+	return Keyset{
+		AES:  Ring{Primary: 1, Keys: []Key{{ID: 1, Material: aesKey}}},
+		HMAC: Ring{Primary: 1, Keys: []Key{{ID: 1, Material: hmacKey}}},
+	}, nil
+}
+
+// Seal encrypts ks under passphrase using a fresh salt for kdf.
+func Seal(ks Keyset, passphrase []byte, kdf KDFParams) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrEmptyPassphrase
+	}
+
+	if err := ks.Validate(); err != nil {
+		return nil, err
+	}
+
+	kdf, err := kdf.withSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapKey, err := kdf.derive(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+
+	f := file{Header: Header{Version: Version, KDF: kdf, Cipher: CipherAESGCM}}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return nil, err
+	}
+
+	ad, err := json.Marshal(f.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := json.Marshal(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Ciphertext = aead.Seal(nil, f.Nonce, plain, ad)
+	return json.MarshalIndent(f, "", "  ")
+}
+
+// Open decrypts a keyfile with passphrase.
+func Open(data, passphrase []byte) (Keyset, Header, error) {
+	f, err := parse(data)
+	if err != nil {
+		return Keyset{}, Header{}, err
+	}
+
+	wrapKey, err := f.KDF.derive(passphrase)
+	if err != nil {
+		return Keyset{}, f.Header, err
+	}
+
+	aead, err := newAEAD(wrapKey)
+	if err != nil {
+		return Keyset{}, f.Header, err
+	}
+
+	ad, err := json.Marshal(f.Header)
+	if err != nil {
+		return Keyset{}, f.Header, err
+	}
+
+	plain, err := aead.Open(nil, f.Nonce, f.Ciphertext, ad)
+	if err != nil {
+		return Keyset{}, f.Header, ErrWrongPassphrase
+	}
+
+	var ks Keyset
+	if err := json.Unmarshal(plain, &ks); err != nil {
+		return Keyset{}, f.Header, fmt.Errorf("keyfile: decode keyset: %w", err)
+	}
+
+	return ks, f.Header, ks.Validate()
+}
+
+// Inspect returns the header of a keyfile without decrypting it.
+func Inspect(data []byte) (Header, error) {
+	f, err := parse(data)
+	if err != nil {
+		return Header{}, err
+	}
+
+	return f.Header, nil
+}
+
+// Rewrap re-encrypts a keyfile under a new passphrase and KDF parameters. The
+// keys themselves are left unchanged.
+func Rewrap(data, oldPassphrase, newPassphrase []byte, kdf KDFParams) ([]byte, error) {
+	ks, _, err := Open(data, oldPassphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return Seal(ks, newPassphrase, kdf)
+}
+
+// ReadFile opens the keyfile at path with the passphrase from source.
+func ReadFile(path string, source PassphraseSource) (Keyset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Keyset{}, err
+	}
+
+	passphrase, err := source()
+	if err != nil {
+		return Keyset{}, fmt.Errorf("keyfile: read passphrase: %w", err)
+	}
+
+	ks, _, err := Open(data, passphrase)
+	return ks, err
+}
+
+// WriteFile seals ks and writes it to path readable by the owner only.
+func WriteFile(path string, ks Keyset, passphrase []byte, kdf KDFParams) error {
+	data, err := Seal(ks, passphrase, kdf)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
+
+func parse(data []byte) (file, error) {
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("keyfile: invalid format: %w", err)
+	}
+
+	if f.Version != Version {
+		return f, fmt.Errorf("keyfile: unsupported version %d", f.Version)
+	}
+
+	if f.Cipher != CipherAESGCM {
+		return f, fmt.Errorf("keyfile: unsupported cipher %q", f.Cipher)
+	}
+
+	return f, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
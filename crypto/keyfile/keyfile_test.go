@@ -0,0 +1,178 @@
+package keyfile
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/core"
+)
+
+var testKDF = KDFParams{Name: KDFScrypt, N: 1 << 10, R: 8, P: 1}
+
+func TestSealOpen(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		aesSize int
+		kdf     KDFParams
+	}{
+		{"scrypt aes-128", 16, testKDF},
+		{"scrypt aes-256", 32, testKDF},
+		{"argon2id", 32, KDFParams{Name: KDFArgon2id, Time: 1, Memory: 1024, Threads: 1}},
+	} {
+		ks, err := Generate(tt.aesSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := Seal(ks, []byte("passphrase"), tt.kdf)
+		if err != nil {
+			t.Fatalf("%s: Seal: %v", tt.name, err)
+		}
+
+		got, header, err := Open(data, []byte("passphrase"))
+		if err != nil {
+			t.Fatalf("%s: Open: %v", tt.name, err)
+		}
+		if header.KDF.Name != tt.kdf.Name || len(header.KDF.Salt) != saltSize {
+			t.Errorf("%s: header kdf %+v", tt.name, header.KDF)
+		}
+		if !equalKeysets(got, ks) {
+			t.Errorf("%s: opened %+v, want %+v", tt.name, got, ks)
+		}
+
+		if _, _, err := Open(data, []byte("wrong")); !errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("%s: wrong passphrase: got %v", tt.name, err)
+		}
+	}
+}
+
+func TestSealRejects(t *testing.T) {
+	ks, err := Generate(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Seal(ks, nil, testKDF); !errors.Is(err, ErrEmptyPassphrase) {
+		t.Errorf("empty passphrase: got %v", err)
+	}
+
+	ks.AES.Primary = 2
+	if _, err := Seal(ks, []byte("passphrase"), testKDF); err == nil {
+		t.Error("sealed a keyset without its primary key")
+	}
+	ks.AES.Primary = 1
+
+	for _, tt := range []struct {
+		name string
+		ks   func(Keyset) Keyset
+		want error
+	}{
+		{"aes key of 20 bytes", func(ks Keyset) Keyset {
+			ks.AES.Keys = append(ks.AES.Keys, Key{ID: 2, Material: make([]byte, 20)})
+			return ks
+		}, core.ErrInvalidKeySize},
+		{"hmac key of 8 bytes", func(ks Keyset) Keyset {
+			ks.HMAC.Keys = []Key{{ID: 1, Material: make([]byte, 8)}}
+			return ks
+		}, core.ErrHMACKeyTooShort},
+	} {
+		if _, err := Seal(tt.ks(ks), []byte("passphrase"), testKDF); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestGenerateSizes(t *testing.T) {
+	for _, size := range []int{16, 24, 32} {
+		ks, err := Generate(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(ks.AES.Keys[0].Material); got != size {
+			t.Errorf("Generate(%d) made a key of %d bytes", size, got)
+		}
+	}
+
+	for _, size := range []int{-1, 0, 20, 64} {
+		if _, err := Generate(size); !errors.Is(err, core.ErrInvalidKeySize) {
+			t.Errorf("Generate(%d): got %v, want %v", size, err, core.ErrInvalidKeySize)
+		}
+	}
+}
+
+func TestOpenDetectsHeaderTampering(t *testing.T) {
+	ks, err := Generate(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Seal(ks, []byte("passphrase"), testKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Replace(data, []byte(`"p": 1`), []byte(`"p": 2`), 1)
+	if bytes.Equal(tampered, data) {
+		t.Fatal("kdf parameter not found in keyfile")
+	}
+	if _, _, err := Open(tampered, []byte("passphrase")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("tampered header: got %v", err)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	ks, err := Generate(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Seal(ks, []byte("old"), testKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewrapped, err := Rewrap(data, []byte("old"), []byte("new"), testKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := Inspect(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := Inspect(rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(before.KDF.Salt, after.KDF.Salt) {
+		t.Error("rewrap kept the salt")
+	}
+
+	if _, _, err := Open(rewrapped, []byte("old")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("old passphrase: got %v", err)
+	}
+	got, _, err := Open(rewrapped, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalKeysets(got, ks) {
+		t.Errorf("rewrap changed the keys")
+	}
+}
+
+func equalKeysets(a, b Keyset) bool {
+	return equalRings(a.AES, b.AES) && equalRings(a.HMAC, b.HMAC)
+}
+
+func equalRings(a, b Ring) bool {
+	if a.Primary != b.Primary || len(a.Keys) != len(b.Keys) {
+		return false
+	}
+	for i := range a.Keys {
+		if a.Keys[i].ID != b.Keys[i].ID || !bytes.Equal(a.Keys[i].Material, b.Keys[i].Material) {
+			return false
+		}
+	}
+	return true
+}
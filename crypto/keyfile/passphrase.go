@@ -0,0 +1,72 @@
+package keyfile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// PassphraseSource supplies the passphrase protecting a keyfile.
+type PassphraseSource func() ([]byte, error)
+
+// Passphrase returns a fixed passphrase, mostly useful in code and tests.
+func Passphrase(p []byte) PassphraseSource {
+	return func() ([]byte, error) {
+		if len(p) == 0 {
+			return nil, ErrEmptyPassphrase
+		}
+		return p, nil
+	}
+}
+
+// PassphraseFromEnv reads the passphrase from the environment variable name.
+func PassphraseFromEnv(name string) PassphraseSource {
+	return func() ([]byte, error) {
+		p, ok := os.LookupEnv(name)
+		if !ok || p == "" {
+			return nil, fmt.Errorf("%w: %s is not set", ErrEmptyPassphrase, name)
+		}
+		return []byte(p), nil
+	}
+}
+
+// PassphraseFromFile reads the passphrase from a file, ignoring a trailing newline.
+func PassphraseFromFile(path string) PassphraseSource {
+	return func() ([]byte, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		p := bytes.TrimRight(b, "\r\n")
+		if len(p) == 0 {
+			return nil, ErrEmptyPassphrase
+		}
+		return p, nil
+	}
+}
+
+// PassphraseFromReader reads the next line of r as the passphrase, e.g. from
+// os.Stdin. Sources reading successive lines of one stream must share a
+// *bufio.Reader, a fresh buffer would swallow the lines after the first.
+func PassphraseFromReader(r io.Reader) PassphraseSource {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	return func() ([]byte, error) {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		p := bytes.TrimRight(line, "\r\n")
+		if len(p) == 0 {
+			return nil, ErrEmptyPassphrase
+		}
+		return p, nil
+	}
+}
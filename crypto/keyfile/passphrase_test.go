@@ -0,0 +1,33 @@
+package keyfile
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPassphraseFromReaderSharesLines(t *testing.T) {
+	stdin := bufio.NewReader(strings.NewReader("old secret\r\nnew secret\n"))
+	oldPass, newPass := PassphraseFromReader(stdin), PassphraseFromReader(stdin)
+
+	for _, tt := range []struct {
+		source PassphraseSource
+		want   string
+	}{
+		{oldPass, "old secret"},
+		{newPass, "new secret"},
+	} {
+		got, err := tt.source()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("passphrase %q, want %q", got, tt.want)
+		}
+	}
+
+	if _, err := newPass(); !errors.Is(err, ErrEmptyPassphrase) {
+		t.Errorf("passphrase after the last line: %v, want ErrEmptyPassphrase", err)
+	}
+}
@@ -0,0 +1,48 @@
+// Package metadata carries request scoped information about who is touching
+// PII and why, so the encryption and audit layers can read it from a context.
+package metadata
+
+import "context"
+
+type Metadata struct {
+	// Tenant owning the data.
+	Tenant string
+	// Purpose of the access, e.g. "kyc" or "support".
+	Purpose string
+	// Subject is the data subject the PII belongs to, e.g. a customer ID.
+	Subject string
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying md, replacing any metadata already present.
+func NewContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, contextKey{}, md)
+}
+
+// FromContext returns the metadata carried by ctx, or the zero value.
+func FromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(contextKey{}).(Metadata)
+	return md
+}
+
+// WithTenant returns a copy of ctx with the tenant set, keeping the other fields.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	md := FromContext(ctx)
+	md.Tenant = tenant
+	return NewContext(ctx, md)
+}
+
+// WithPurpose returns a copy of ctx with the purpose set, keeping the other fields.
+func WithPurpose(ctx context.Context, purpose string) context.Context {
+	md := FromContext(ctx)
+	md.Purpose = purpose
+	return NewContext(ctx, md)
+}
+
+// WithSubject returns a copy of ctx with the subject set, keeping the other fields.
+func WithSubject(ctx context.Context, subject string) context.Context {
+	md := FromContext(ctx)
+	md.Subject = subject
+	return NewContext(ctx, md)
+}
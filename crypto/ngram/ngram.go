@@ -0,0 +1,111 @@
+// Package ngram splits values into character n-grams for blind substring
+// indexes. Callers hash the grams with a keyed HMAC before storing them, so
+// the index holds no plaintext; a term matches a value when every gram of the
+// term is among the grams of the value.
+package ngram
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrTermTooShort = errors.New("search term is shorter than the n-gram size")
+	ErrInvalidSize  = errors.New("invalid n-gram size")
+)
+
+// Tokenizer emits the grams of Min to Max runes of a value.
+type Tokenizer struct {
+	Min int
+	Max int
+}
+
+// New returns a Tokenizer emitting grams of exactly n runes.
+func New(n int) Tokenizer {
+	return Tokenizer{Min: n, Max: n}
+}
+
+// Parse reads a tokenizer from a struct tag value, "n" or "min-max".
+func Parse(s string) (Tokenizer, error) {
+	lo, hi, found := strings.Cut(s, "-")
+	if !found {
+		hi = lo
+	}
+
+	minSize, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	maxSize, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil {
+		return Tokenizer{}, fmt.Errorf("%w: %q", ErrInvalidSize, s)
+	}
+
+	t := Tokenizer{Min: minSize, Max: maxSize}
+	return t, t.Validate()
+}
+
+func (t Tokenizer) Validate() error {
+	if t.Min < 1 || t.Max < t.Min {
+		return fmt.Errorf("%w: %d-%d", ErrInvalidSize, t.Min, t.Max)
+	}
+	return nil
+}
+
+// Grams returns the distinct grams of s of every size from Min to Max, in
+// order of first occurrence. Values shorter than Min have no grams.
+func (t Tokenizer) Grams(s string) []string {
+	runes := []rune(s)
+
+	var grams []string
+	seen := make(map[string]struct{})
+	for n := t.Min; n <= t.Max && n <= len(runes); n++ {
+		for i := 0; i+n <= len(runes); i++ {
+			gram := string(runes[i : i+n])
+			if _, ok := seen[gram]; ok {
+				continue
+			}
+			seen[gram] = struct{}{}
+			grams = append(grams, gram)
+		}
+	}
+
+	return grams
+}
+
+// QueryGrams returns the grams a value must contain to contain term: the
+// distinct grams of the largest size up to Max fitting in term.
+func (t Tokenizer) QueryGrams(term string) ([]string, error) {
+	n := len([]rune(term))
+	if n < t.Min {
+		return nil, fmt.Errorf("%w: %q needs at least %d characters", ErrTermTooShort, term, t.Min)
+	}
+
+	return Tokenizer{Min: min(n, t.Max), Max: min(n, t.Max)}.Grams(term), nil
+}
+
+// Sequence returns the overlapping grams of Max runes of s in order,
+// duplicates included, or s itself when it is shorter.
+func (t Tokenizer) Sequence(s string) []string {
+	runes := []rune(s)
+	if len(runes) <= t.Max {
+		return []string{s}
+	}
+
+	grams := make([]string, 0, len(runes)-t.Max+1)
+	for i := 0; i+t.Max <= len(runes); i++ {
+		grams = append(grams, string(runes[i:i+t.Max]))
+	}
+	return grams
+}
+
+// Prefixes returns the distinct leading grams of s from Min to Max runes, the
+// edge n-grams used for prefix search. Values shorter than Min have none.
+func (t Tokenizer) Prefixes(s string) []string {
+	runes := []rune(s)
+
+	var prefixes []string
+	for n := t.Min; n <= t.Max && n <= len(runes); n++ {
+		prefixes = append(prefixes, string(runes[:n]))
+	}
+	return prefixes
+}
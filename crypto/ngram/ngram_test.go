@@ -0,0 +1,98 @@
+package ngram
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		tag  string
+		want Tokenizer
+		err  error
+	}{
+		{"3", Tokenizer{Min: 3, Max: 3}, nil},
+		{"2-4", Tokenizer{Min: 2, Max: 4}, nil},
+		{" 2 - 4 ", Tokenizer{Min: 2, Max: 4}, nil},
+		{"0", Tokenizer{}, ErrInvalidSize},
+		{"4-2", Tokenizer{}, ErrInvalidSize},
+		{"x", Tokenizer{}, ErrInvalidSize},
+	} {
+		got, err := Parse(tt.tag)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q) error %v, want %v", tt.tag, err, tt.err)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestGrams(t *testing.T) {
+	for _, tt := range []struct {
+		t     Tokenizer
+		value string
+		want  []string
+	}{
+		{New(3), "budi", []string{"bud", "udi"}},
+		{New(2), "aaaa", []string{"aa"}},
+		{New(3), "ab", nil},
+		{New(2), "zoë", []string{"zo", "oë"}},
+		{Tokenizer{Min: 2, Max: 3}, "abc", []string{"ab", "bc", "abc"}},
+	} {
+		if got := tt.t.Grams(tt.value); !slices.Equal(got, tt.want) {
+			t.Errorf("%+v.Grams(%q) = %q, want %q", tt.t, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestQueryGrams(t *testing.T) {
+	tok := Tokenizer{Min: 2, Max: 3}
+
+	for _, tt := range []struct {
+		term string
+		want []string
+	}{
+		{"ab", []string{"ab"}},
+		{"abcd", []string{"abc", "bcd"}},
+	} {
+		got, err := tok.QueryGrams(tt.term)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("QueryGrams(%q) = %q, want %q", tt.term, got, tt.want)
+		}
+
+		// every query gram of a term is a gram of a value containing it
+		grams := tok.Grams("xx" + tt.term + "yy")
+		for _, g := range got {
+			if !slices.Contains(grams, g) {
+				t.Errorf("query gram %q of %q not among the value grams", g, tt.term)
+			}
+		}
+	}
+
+	if _, err := tok.QueryGrams("a"); !errors.Is(err, ErrTermTooShort) {
+		t.Errorf("short term: got %v, want %v", err, ErrTermTooShort)
+	}
+}
+
+func TestSequenceAndPrefixes(t *testing.T) {
+	tok := Tokenizer{Min: 2, Max: 3}
+
+	if got, want := tok.Sequence("abab"), []string{"aba", "bab"}; !slices.Equal(got, want) {
+		t.Errorf("Sequence = %q, want %q", got, want)
+	}
+	if got, want := tok.Sequence("ab"), []string{"ab"}; !slices.Equal(got, want) {
+		t.Errorf("Sequence of a short value = %q, want %q", got, want)
+	}
+	if got, want := tok.Prefixes("abcd"), []string{"ab", "abc"}; !slices.Equal(got, want) {
+		t.Errorf("Prefixes = %q, want %q", got, want)
+	}
+	if got := tok.Prefixes("a"); got != nil {
+		t.Errorf("Prefixes of a short value = %q, want none", got)
+	}
+}
@@ -0,0 +1,192 @@
+package crypto
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
+	"github.com/dyaksa/encryption-pii/crypto/ngram"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+	"github.com/lib/pq"
+)
+
+// N-gram blind indexes
+//
+// A field tagged ngram receives from BindHeap the keyed hashes of the n-grams
+// of the field named like it without the last four characters. The tag holds
+// the gram size, "3" or a range such as "2-4":
+//
+//	Name     types.AESCipher `db:"name"`
+//	NameGram pq.StringArray  `db:"name_gram" ngram:"3"`
+//
+// stored in a text[] column with a GIN index:
+//
+//	ALTER TABLE profiles ADD COLUMN name_gram text[];
+//	CREATE INDEX profiles_name_gram_idx ON profiles USING gin (name_gram);
+//
+// NgramCondition matches rows whose column contains a term. Alternatively the
+// hashes live in a dedicated index table, written with ReplaceNgramIndex and
+// queried with NgramLookup:
+//
+//	CREATE TABLE profiles_name_gram (
+//		row_id text NOT NULL,
+//		hash   text NOT NULL,
+//		PRIMARY KEY (hash, row_id)
+//	);
+//
+// Hashes are keyed by the column name, so equal grams of different columns do
+// not correlate. Matches are candidates: a value containing all grams of a
+// term in another order matches too, decrypt and compare to confirm.
+
+// NgramHashes returns the hashes of the grams of the lower-cased value for
+// column.
+func (c *Crypto) NgramHashes(column, value string, t ngram.Tokenizer) ([]string, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.ngramHashes(column, t.Grams(strings.ToLower(value)))
+}
+
+// NgramQueryHashes returns the hashes a value of column must contain to
+// contain term.
+func (c *Crypto) NgramQueryHashes(column, term string, t ngram.Tokenizer) ([]string, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	grams, err := t.QueryGrams(strings.ToLower(term))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ngramHashes(column, grams)
+}
+
+func (c *Crypto) ngramHashes(column string, grams []string) ([]string, error) {
+	hashes := make([]string, len(grams))
+	for i, gram := range grams {
+		h, err := c.heapHash("\x00ngram:" + strings.ToLower(column) + "\x00" + gram)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = h
+	}
+
+	return hashes, nil
+}
+
+// NgramCondition returns a condition matching rows whose text[] column holds
+// every gram hash of term, with its argument numbered from startAt.
+func (c *Crypto) NgramCondition(column, term string, t ngram.Tokenizer, startAt int) (string, []interface{}, error) {
+	keys, err := c.lookupKeys()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(keys) > 1 {
+		return anyKey(keys, startAt, func(k *Crypto, startAt int) (string, []interface{}, error) {
+			return k.NgramCondition(column, term, t, startAt)
+		})
+	}
+
+	quoted, err := sqlsafe.QuoteIdent(column)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hashes, err := c.NgramQueryHashes(column, term, t)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return quoted + " @> " + sqlsafe.Placeholder(startAt) + "::text[]", []interface{}{pq.Array(hashes)}, nil
+}
+
+// NgramLookup returns a query selecting the row_id of the rows of the
+// dedicated index table holding every gram hash of term for column.
+func (c *Crypto) NgramLookup(indexTable, column, term string, t ngram.Tokenizer) (string, []interface{}, error) {
+	quoted, err := sqlsafe.QuoteIdent(indexTable)
+	if err != nil {
+		return "", nil, err
+	}
+
+	keys, err := c.lookupKeys()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var (
+		queries []string
+		args    []interface{}
+	)
+	for _, k := range keys {
+		hashes, err := k.NgramQueryHashes(column, term, t)
+		if err != nil {
+			return "", nil, err
+		}
+
+		queries = append(queries, "SELECT row_id FROM "+quoted+" WHERE hash = ANY("+sqlsafe.Placeholder(len(args)+1)+") GROUP BY row_id HAVING count(DISTINCT hash) = "+sqlsafe.Placeholder(len(args)+2))
+		args = append(args, pq.Array(hashes), len(hashes))
+	}
+
+	return strings.Join(queries, " UNION "), args, nil
+}
+
+// ReplaceNgramIndex replaces the gram hashes of rowID in the dedicated index
+// table with the ones of value.
+func (c *Crypto) ReplaceNgramIndex(ctx context.Context, db heapstore.DB, indexTable, column, rowID, value string, t ngram.Tokenizer) error {
+	quoted, err := sqlsafe.QuoteIdent(indexTable)
+	if err != nil {
+		return err
+	}
+
+	hashes, err := c.NgramHashes(column, value, t)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM "+quoted+" WHERE row_id = $1", rowID); err != nil {
+		return fmt.Errorf("failed to delete from ngram index %s: %w", indexTable, err)
+	}
+
+	query := "INSERT INTO " + quoted + " (row_id, hash) SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING"
+	if _, err := db.ExecContext(ctx, query, rowID, pq.Array(hashes)); err != nil {
+		return fmt.Errorf("failed to insert into ngram index %s: %w", indexTable, err)
+	}
+
+	return nil
+}
+
+// bindNgram fills the ngram tagged field of entity.
+func (c *Crypto) bindNgram(entity reflect.Value, field reflect.StructField) error {
+	t, err := ngram.Parse(field.Tag.Get("ngram"))
+	if err != nil {
+		return fmt.Errorf("field %s: %w", field.Name, err)
+	}
+
+	gramField := entity.FieldByName(field.Name)
+	if gramField.Kind() != reflect.Slice || gramField.Type().Elem().Kind() != reflect.String {
+		return fmt.Errorf("field %s: ngram fields must be string slices", field.Name)
+	}
+
+	source := entity.FieldByName(field.Name[:len(field.Name)-4])
+	if !source.IsValid() {
+		return fmt.Errorf("field %s: no field %s to index", field.Name, field.Name[:len(field.Name)-4])
+	}
+
+	plain, ok := source.Interface().(types.AESCipher)
+	if !ok {
+		return nil
+	}
+
+	hashes, err := c.NgramHashes(columnName(field), plain.To(), t)
+	if err != nil {
+		return fmt.Errorf("failed to hash ngrams: %w", err)
+	}
+
+	gramField.Set(reflect.ValueOf(hashes).Convert(gramField.Type()))
+	return nil
+}
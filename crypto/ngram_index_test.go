@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/ngram"
+)
+
+func TestNgramHashes(t *testing.T) {
+	c := newTestCrypto(t)
+	tok := ngram.New(3)
+
+	stored, err := c.NgramHashes("name_gram", "Budi Santoso", tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != len(tok.Grams("budi santoso")) {
+		t.Fatalf("got %d hashes for %d grams", len(stored), len(tok.Grams("budi santoso")))
+	}
+
+	for _, tt := range []struct {
+		term  string
+		match bool
+	}{
+		{"SANTO", true},
+		{"udi s", true},
+		{"sinto", false},
+	} {
+		query, err := c.NgramQueryHashes("name_gram", tt.term, tok)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		match := true
+		for _, h := range query {
+			match = match && slices.Contains(stored, h)
+		}
+		if match != tt.match {
+			t.Errorf("term %q: match %v, want %v", tt.term, match, tt.match)
+		}
+	}
+
+	other, err := c.NgramHashes("address_gram", "Budi Santoso", tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range other {
+		if slices.Contains(stored, h) {
+			t.Fatalf("hash %s shared between columns", h)
+		}
+	}
+
+	if _, err := c.NgramQueryHashes("name_gram", "bu", tok); !errors.Is(err, ngram.ErrTermTooShort) {
+		t.Errorf("short term: got %v, want %v", err, ngram.ErrTermTooShort)
+	}
+	if _, err := c.NgramHashes("name_gram", "budi", ngram.Tokenizer{}); !errors.Is(err, ngram.ErrInvalidSize) {
+		t.Errorf("zero tokenizer: got %v, want %v", err, ngram.ErrInvalidSize)
+	}
+}
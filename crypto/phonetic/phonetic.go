@@ -0,0 +1,119 @@
+// Package phonetic encodes names into codes that are equal for common
+// spelling variants, tuned for Indonesian names in old (Van Ophuijsen and
+// Soewandi) and current spelling and for Latin names. Muhammad, Muhamad,
+// Mohammad and Mochammad all encode to MHMT, Soekarno and Sukarno to SKRN.
+//
+// Codes are coarse by design: unrelated names collide more often than with
+// exact tokens, so fuzzy matches are candidates to confirm.
+package phonetic
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// spelling rewrites old and foreign spellings to current Indonesian
+// spelling, in order.
+var spelling = strings.NewReplacer(
+	"oe", "u", // Soekarno
+	"dj", "j", // Djoko
+	"tj", "c", // Tjahjo
+	"sj", "sy", // Sjahrir
+	"nj", "ny", // Njoman
+	"ch", "kh", // Mochammad, Achmad
+	"ph", "f",
+	"ck", "k",
+	"qu", "k",
+	"q", "k",
+	"x", "ks",
+	"v", "f",
+)
+
+// Encode returns the code of every word of name, in order.
+func Encode(name string) []string {
+	var codes []string
+	for _, word := range strings.FieldsFunc(fold(name), func(r rune) bool { return r < 'a' || r > 'z' }) {
+		if code := encodeWord(word); code != "" {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// fold lower-cases name and strips diacritics, leaving latin letters.
+func fold(name string) string {
+	b := new(strings.Builder)
+	for _, r := range norm.NFD.String(strings.ToLower(name)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func encodeWord(word string) string {
+	w := []rune(spelling.Replace(word))
+
+	var out []rune
+	for i, r := range w {
+		var next rune
+		if i+1 < len(w) {
+			next = w[i+1]
+		}
+
+		switch {
+		case isVowel(r):
+			// only a leading vowel counts, and all of them alike
+			if i == 0 {
+				out = append(out, 'A')
+			}
+			continue
+		case r == 'h':
+			// silent after consonants (Dhani, Ghani, Sjahrir) and at the end
+			// (Fatimah); kh and sy reduce to h and s
+			if i == 0 || next == 0 || !isVowel(w[i-1]) && w[i-1] != 'k' {
+				continue
+			}
+		case r == 'k' && next == 'h':
+			continue
+		case r == 'y' && i > 0 && w[i-1] == 's':
+			continue
+		case r == 'j':
+			// old j is current y, and dj became j: treat them alike
+			r = 'y'
+		case r == 'z':
+			r = 's'
+		}
+
+		if next == 0 {
+			// final voiced stops devoice: Ahmad/Ahmat, Yakub/Yakup
+			switch r {
+			case 'd':
+				r = 't'
+			case 'b':
+				r = 'p'
+			case 'g':
+				r = 'k'
+			}
+		}
+
+		r = unicode.ToUpper(r)
+		if len(out) > 0 && out[len(out)-1] == r {
+			continue
+		}
+		out = append(out, r)
+	}
+
+	return string(out)
+}
+
+func isVowel(r rune) bool {
+	switch r {
+	case 'a', 'e', 'i', 'o', 'u':
+		return true
+	}
+	return false
+}
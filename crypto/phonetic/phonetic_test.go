@@ -0,0 +1,41 @@
+package phonetic
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	for _, tt := range []struct {
+		names []string
+		want  []string
+	}{
+		{[]string{"Muhammad", "Muhamad", "Mohammad", "Mochammad", "MUHAMMAD"}, []string{"MHMT"}},
+		{[]string{"Soekarno", "Sukarno"}, []string{"SKRN"}},
+		{[]string{"Ahmad", "Achmad", "Ahmat"}, []string{"AHMT"}},
+		{[]string{"Djoko", "Joko"}, []string{"YK"}},
+		{[]string{"Tjahjo", "Cahyo"}, []string{"CHY"}},
+		{[]string{"Yakub", "Yakup", "Jakub"}, []string{"YKP"}},
+		{[]string{"Muhamad Sukarno", "Mohammad Soekarno"}, []string{"MHMT", "SKRN"}},
+		{[]string{"Zoë", "Zoe"}, []string{"S"}},
+		{[]string{"", " - ", "123"}, nil},
+	} {
+		for _, name := range tt.names {
+			if got := Encode(name); !slices.Equal(got, tt.want) {
+				t.Errorf("Encode(%q) = %q, want %q", name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestEncodeKeepsDifferentNamesApart(t *testing.T) {
+	for _, pair := range [][2]string{
+		{"Budi", "Bagus"},
+		{"Siti", "Sari"},
+		{"Agus", "Anto"},
+	} {
+		if a, b := Encode(pair[0]), Encode(pair[1]); slices.Equal(a, b) {
+			t.Errorf("%s and %s both encode to %q", pair[0], pair[1], a)
+		}
+	}
+}
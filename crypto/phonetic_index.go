@@ -0,0 +1,120 @@
+package crypto
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dyaksa/encryption-pii/crypto/phonetic"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+)
+
+// Phonetic blind indexes
+//
+// A string field tagged phonetic:"true" receives from BindHeap the keyed
+// hashes of the phonetic codes of the words of the field named like it
+// without the last four characters, each closed by a comma so that matches
+// cannot straddle two hashes:
+//
+//	Name     types.AESCipher `db:"name"`
+//	NamePhon string          `db:"name_phon" phonetic:"true"`
+//
+// PhoneticCondition then finds Mohammad Soekarno, stored as
+// ",1a2b3c4d,5e6f7a8b,", when searching for Muhamad Sukarno. Besides
+// what an exact bidx reveals, the column groups rows whose names sound
+// alike; see package phonetic for the rules.
+
+// phoneticSep delimits the hashes of a phonetic column.
+const phoneticSep = ","
+
+// PhoneticHashes returns the hashes of the phonetic codes of the words of
+// name for column.
+func (c *Crypto) PhoneticHashes(column, name string) ([]string, error) {
+	codes := phonetic.Encode(name)
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		h, err := c.heapHash("\x00phonetic:" + strings.ToLower(column) + "\x00" + code)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = h
+	}
+
+	return hashes, nil
+}
+
+// PhoneticCondition returns a condition matching rows whose phonetic column
+// sounds like every word of name, with its arguments numbered from startAt.
+func (c *Crypto) PhoneticCondition(column, name string, startAt int) (string, []interface{}, error) {
+	keys, err := c.lookupKeys()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(keys) > 1 {
+		return anyKey(keys, startAt, func(k *Crypto, startAt int) (string, []interface{}, error) {
+			return k.PhoneticCondition(column, name, startAt)
+		})
+	}
+
+	quoted, err := sqlsafe.QuoteIdent(column)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hashes, err := c.PhoneticHashes(column, name)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(hashes) == 0 {
+		return "", nil, fmt.Errorf("%q has no letters to match", name)
+	}
+
+	conds := make([]string, len(hashes))
+	args := make([]interface{}, len(hashes))
+	for i, hash := range hashes {
+		conds[i] = quoted + " LIKE " + sqlsafe.Placeholder(startAt+i)
+		args[i] = sqlsafe.Contains(phoneticSep + hash + phoneticSep)
+	}
+
+	return "(" + strings.Join(conds, " AND ") + ")", args, nil
+}
+
+// bindPhonetic fills the phonetic tagged field of entity.
+func (c *Crypto) bindPhonetic(entity reflect.Value, field reflect.StructField) error {
+	if field.Tag.Get("phonetic") != "true" {
+		return nil
+	}
+
+	target := entity.FieldByName(field.Name)
+	if target.Kind() != reflect.String {
+		return fmt.Errorf("field %s: phonetic fields must be strings", field.Name)
+	}
+
+	source := entity.FieldByName(field.Name[:len(field.Name)-4])
+	if !source.IsValid() {
+		return fmt.Errorf("field %s: no field %s to index", field.Name, field.Name[:len(field.Name)-4])
+	}
+
+	plain, ok := source.Interface().(types.AESCipher)
+	if !ok {
+		return nil
+	}
+
+	hashes, err := c.PhoneticHashes(columnName(field), plain.To())
+	if err != nil {
+		return fmt.Errorf("failed to hash phonetic codes: %w", err)
+	}
+
+	target.SetString(phoneticValue(hashes))
+	return nil
+}
+
+// phoneticValue returns the column value holding hashes.
+func phoneticValue(hashes []string) string {
+	if len(hashes) == 0 {
+		return ""
+	}
+	return phoneticSep + strings.Join(hashes, phoneticSep) + phoneticSep
+}
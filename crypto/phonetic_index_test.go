@@ -0,0 +1,37 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
+)
+
+func TestPhoneticConditionMatchesWholeHashes(t *testing.T) {
+	c := newTestCrypto(t)
+
+	stored, err := c.PhoneticHashes("name_phon", "Mohammad Soekarno")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("got %d hashes, want 2", len(stored))
+	}
+	value := phoneticValue(stored)
+
+	_, args, err := c.PhoneticCondition("name_phon", "Muhamad Sukarno", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range args {
+		if !heapstore.MatchLike(arg.(string), value) {
+			t.Fatalf("pattern %q does not match %q", arg, value)
+		}
+	}
+
+	// A hash made of the end of one stored hash and the start of the next
+	// must not match.
+	straddling := stored[0][4:] + stored[1][:4]
+	if pattern := "%" + phoneticSep + straddling + phoneticSep + "%"; heapstore.MatchLike(pattern, value) {
+		t.Fatalf("pattern %q matches across hashes of %q", pattern, value)
+	}
+}
@@ -0,0 +1,253 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dyaksa/encryption-pii/crypto/analyzer"
+	"github.com/dyaksa/encryption-pii/crypto/ngram"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+)
+
+// Prefix indexes
+//
+// A field tagged prefix:"true" receives from BindHeap the keyed hashes of the
+// leading characters (edge n-grams) of the whole value and of each of its
+// words, taken from the field named like it without the last four
+// characters. Words are split by the analyzer of the analyzer tag, like heap
+// tokens, and SearchPrefix normalizes prefixes with the same analyzer:
+//
+//	Name     types.AESCipher `db:"name"`
+//	NamePref pq.StringArray  `db:"name_pref" prefix:"true" analyzer:"standard"`
+//
+// stored in a text[] column with a GIN index. SearchPrefix returns the hash a
+// row starting with a prefix holds in that column. Without an analyzer,
+// values of digits and separators are indexed as digits, and numbers written
+// with a leading + or 0 in both their international and national form, so
+// 08123, 0812-3 and +62 812-3 all find 0812-3456-7890; the phone analyzer
+// normalizes values and prefixes to E.164 instead. Only prefixes between the
+// lengths set by WithPrefixLength are indexed: a longer minimum hides short
+// prefixes, which are shared by many rows and easy to guess, a smaller
+// maximum caps how much of a value the index reveals by equality.
+
+const (
+	DefaultPrefixMinLength = 3
+	DefaultPrefixMaxLength = 16
+)
+
+var ErrPrefixTooShort = errors.New("prefix is shorter than the minimum prefix length")
+
+// WithPrefixLength sets the shortest and longest indexed prefix, 3 and 16
+// characters by default. Rebuild prefix columns after changing it.
+func WithPrefixLength(minLen, maxLen int) Opts {
+	return func(c *Crypto) error {
+		t := ngram.Tokenizer{Min: minLen, Max: maxLen}
+		if err := t.Validate(); err != nil {
+			return err
+		}
+
+		c.prefix = t
+		return nil
+	}
+}
+
+func (c *Crypto) prefixTokenizer() ngram.Tokenizer {
+	if c.prefix.Min == 0 {
+		return ngram.Tokenizer{Min: DefaultPrefixMinLength, Max: DefaultPrefixMaxLength}
+	}
+	return c.prefix
+}
+
+// PrefixHashes returns the prefix hashes stored in column for value, analyzed
+// like the column was registered with by a prefix field or WithEntities.
+func (c *Crypto) PrefixHashes(column, value string) ([]string, error) {
+	name, err := c.prefixAnalyzers.resolve(column, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.prefixHashes(column, value, name)
+}
+
+func (c *Crypto) prefixHashes(column, value, analyzerName string) ([]string, error) {
+	parts, err := prefixParts(value, analyzerName)
+	if err != nil {
+		return nil, err
+	}
+
+	t := c.prefixTokenizer()
+	seen := make(map[string]struct{})
+	var hashes []string
+	for _, part := range parts {
+		for _, p := range t.Prefixes(part) {
+			if _, ok := seen[p]; ok {
+				continue
+			}
+			seen[p] = struct{}{}
+
+			h, err := c.prefixHash(column, p)
+			if err != nil {
+				return nil, err
+			}
+			hashes = append(hashes, h)
+		}
+	}
+
+	return hashes, nil
+}
+
+// prefixParts returns the normalized value and its words, whose prefixes
+// are indexed.
+func prefixParts(value, analyzerName string) ([]string, error) {
+	if analyzerName == "" {
+		form := legacyPrefixForm(value)
+		parts := append([]string{form}, phoneForms(form, value)...)
+		return append(parts, split(strings.ToLower(value))...), nil
+	}
+
+	a, err := analyzer.Get(analyzerName)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{a.Normalized(value)}, a.Analyze(value)...), nil
+}
+
+// phoneCountry is the calling code the legacy prefix index assumes for
+// national phone numbers, like the phone analyzer.
+const phoneCountry = "62"
+
+// legacyPrefixForm returns s lower-cased, or only its digits when it holds
+// nothing but digits and separators, as phone numbers and NIKs do, so
+// 0812-3456 and 08123456 share their prefixes.
+func legacyPrefixForm(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	digits := new(strings.Builder)
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case strings.ContainsRune(" -.+()/", r):
+		default:
+			return s
+		}
+	}
+
+	if digits.Len() == 0 {
+		return s
+	}
+	return digits.String()
+}
+
+// phoneForms returns the international and national digits of a number
+// written with a leading + or 0, so a prefix in either form finds it.
+func phoneForms(form, value string) []string {
+	value = strings.TrimSpace(value)
+	if strings.Trim(form, "0123456789") != "" || !strings.HasPrefix(value, "+") && !strings.HasPrefix(value, "0") {
+		return nil
+	}
+
+	international := strings.TrimPrefix(analyzer.E164(phoneCountry)(value), "+")
+	if national, ok := strings.CutPrefix(international, phoneCountry); ok {
+		return []string{international, "0" + national}
+	}
+	return []string{international}
+}
+
+func (c *Crypto) prefixHash(column, prefix string) (string, error) {
+	return c.heapHash("\x00prefix:" + strings.ToLower(column) + "\x00" + prefix)
+}
+
+// SearchPrefix returns the hash held in the prefix column by rows whose value
+// or one of its words starts with prefix. Prefixes longer than the maximum
+// length are cut, so matches are candidates to confirm after decrypting.
+func (c *Crypto) SearchPrefix(ctx context.Context, column, prefix string) (hash string, err error) {
+	defer func() { c.audit(ctx, AuditSearchPrefix, column, err) }()
+
+	name, err := c.prefixAnalyzers.resolve(column, "")
+	if err != nil {
+		return "", err
+	}
+
+	normalized := legacyPrefixForm(prefix)
+	if name != "" {
+		a, err := analyzer.Get(name)
+		if err != nil {
+			return "", err
+		}
+		normalized = a.Normalized(prefix)
+	}
+
+	t := c.prefixTokenizer()
+	runes := []rune(normalized)
+	if len(runes) < t.Min {
+		return "", fmt.Errorf("%w: %q needs at least %d characters", ErrPrefixTooShort, prefix, t.Min)
+	}
+
+	return c.prefixHash(column, string(runes[:min(len(runes), t.Max)]))
+}
+
+// PrefixCondition returns a condition matching rows whose prefix column
+// starts with prefix, with its argument numbered from startAt.
+func (c *Crypto) PrefixCondition(ctx context.Context, column, prefix string, startAt int) (string, []interface{}, error) {
+	keys, err := c.lookupKeys()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(keys) > 1 {
+		return anyKey(keys, startAt, func(k *Crypto, startAt int) (string, []interface{}, error) {
+			return k.PrefixCondition(ctx, column, prefix, startAt)
+		})
+	}
+
+	quoted, err := sqlsafe.QuoteIdent(column)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hash, err := c.SearchPrefix(ctx, column, prefix)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return sqlsafe.Placeholder(startAt) + " = ANY(" + quoted + ")", []interface{}{hash}, nil
+}
+
+// bindPrefix fills the prefix tagged field of entity.
+func (c *Crypto) bindPrefix(entity reflect.Value, field reflect.StructField) error {
+	if field.Tag.Get("prefix") != "true" {
+		return nil
+	}
+
+	prefixField := entity.FieldByName(field.Name)
+	if prefixField.Kind() != reflect.Slice || prefixField.Type().Elem().Kind() != reflect.String {
+		return fmt.Errorf("field %s: prefix fields must be string slices", field.Name)
+	}
+
+	source := entity.FieldByName(field.Name[:len(field.Name)-4])
+	if !source.IsValid() {
+		return fmt.Errorf("field %s: no field %s to index", field.Name, field.Name[:len(field.Name)-4])
+	}
+
+	plain, ok := source.Interface().(types.AESCipher)
+	if !ok {
+		return nil
+	}
+
+	name := field.Tag.Get("analyzer")
+	if err := c.prefixAnalyzers.set(columnName(field), name); err != nil {
+		return fmt.Errorf("field %s: %w", field.Name, err)
+	}
+
+	hashes, err := c.prefixHashes(columnName(field), plain.To(), name)
+	if err != nil {
+		return fmt.Errorf("failed to hash prefixes: %w", err)
+	}
+
+	prefixField.Set(reflect.ValueOf(hashes).Convert(prefixField.Type()))
+	return nil
+}
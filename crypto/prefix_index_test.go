@@ -0,0 +1,118 @@
+package crypto
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+	"github.com/lib/pq"
+)
+
+type prefixedPerson struct {
+	Name       types.AESCipher
+	NamePref   pq.StringArray `db:"name_pref" prefix:"true" analyzer:"standard"`
+	Phone      types.AESCipher
+	PhonePref  pq.StringArray `db:"phone_pref" prefix:"true"`
+	Mobile     types.AESCipher
+	MobilePref pq.StringArray `db:"mobile_pref" prefix:"true" analyzer:"phone"`
+}
+
+func TestPrefixHashesUseAnalyzer(t *testing.T) {
+	c := newTestCrypto(t)
+	ctx := context.Background()
+
+	p := prefixedPerson{
+		Name:   c.Encrypt("Zoë Ñúñez-Ortega", aesx.AesGCM),
+		Phone:  c.Encrypt("0812-3456-7890", aesx.AesGCM),
+		Mobile: c.Encrypt("0812-3456-7890", aesx.AesGCM),
+	}
+	if err := c.BindHeap(&p); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		column, prefix string
+		stored         []string
+	}{
+		{"name_pref", "ZOË", p.NamePref},
+		{"name_pref", "ñúñe", p.NamePref},
+		{"name_pref", "ortega", p.NamePref},
+		{"phone_pref", "0812", p.PhonePref},
+		{"phone_pref", "0812-3", p.PhonePref},
+		{"phone_pref", "08123", p.PhonePref},
+		{"phone_pref", "628123", p.PhonePref},
+		{"phone_pref", "+62 812", p.PhonePref},
+		{"mobile_pref", "0812", p.MobilePref},
+		{"mobile_pref", "0812-3", p.MobilePref},
+		{"mobile_pref", "08123", p.MobilePref},
+		{"mobile_pref", "628123", p.MobilePref},
+		{"mobile_pref", "+62812", p.MobilePref},
+	} {
+		hash, err := c.SearchPrefix(ctx, tt.column, tt.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Contains(tt.stored, hash) {
+			t.Fatalf("SearchPrefix(%s, %q) does not match the stored prefixes", tt.column, tt.prefix)
+		}
+	}
+
+	// A searching service learns the analyzer from the entity.
+	search := newTestCrypto(t, WithEntities(prefixedPerson{}))
+	hash, err := search.SearchPrefix(ctx, "name_pref", "ñúñe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(p.NamePref, hash) {
+		t.Fatal("prefix searched without writing does not match")
+	}
+
+	hashes, err := search.PrefixHashes("name_pref", "Zoë Ñúñez-Ortega")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(hashes, p.NamePref) {
+		t.Fatal("PrefixHashes differs from the bound prefixes")
+	}
+}
+
+func TestPrefixHashesMatchAcrossPhoneFormats(t *testing.T) {
+	c := newTestCrypto(t)
+	ctx := context.Background()
+
+	for _, column := range []string{"phone_pref", "mobile_pref"} {
+		for _, stored := range []string{"0812-3456-7890", "081234567890", "+62 812 3456 7890"} {
+			p := prefixedPerson{
+				Phone:  c.Encrypt(stored, aesx.AesGCM),
+				Mobile: c.Encrypt(stored, aesx.AesGCM),
+			}
+			if err := c.BindHeap(&p); err != nil {
+				t.Fatal(err)
+			}
+			hashes := p.PhonePref
+			if column == "mobile_pref" {
+				hashes = p.MobilePref
+			}
+
+			for _, prefix := range []string{"0812", "08123", "0812-345", "628123", "+628123", "+62 812-3"} {
+				hash, err := c.SearchPrefix(ctx, column, prefix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Contains(hashes, hash) {
+					t.Errorf("%s: prefix %q does not match stored %q", column, prefix, stored)
+				}
+			}
+
+			hash, err := c.SearchPrefix(ctx, column, "0813")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if slices.Contains(hashes, hash) {
+				t.Errorf("%s: prefix 0813 matches stored %q", column, stored)
+			}
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/dyaksa/encryption-pii/crypto"
 	"github.com/dyaksa/encryption-pii/crypto/hmacx"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
 	"github.com/dyaksa/encryption-pii/crypto/types"
 	"github.com/google/uuid"
 )
@@ -38,66 +39,14 @@ type TextHeap struct {
 	Hash    string
 }
 
-// Deprecated: any is deprecated. Use interface{} instead.
+// Deprecated: use crypto.BuildQueryLikeArgs.
 func BuildQueryLike(ctx context.Context, tx *sql.Tx, data any, cond string) (str string, err error) {
-	entityValue := reflect.ValueOf(data)
-	entityType := entityValue.Type()
-
-	for i := 0; i < entityType.NumField(); i++ {
-		field := entityType.Field(i)
-		bidxCol := field.Tag.Get("bidx_col")
-		heapCol := field.Tag.Get("txt_heap_table")
-		value := entityValue.Field(i).Interface()
-
-		var query = new(strings.Builder)
-		query.WriteString("SELECT content, hash FROM ")
-		query.WriteString(heapCol)
-		query.WriteString(" WHERE content ILIKE $1")
-
-		rows, err := tx.QueryContext(ctx, query.String(), "%"+value.(string)+"%")
-		if err != nil {
-			return "", err
-		}
-
-		var heaps []string
-		for rows.Next() {
-			var i FindTextHeapRow
-			err = rows.Scan(&i.Content, &i.Hash)
-			if err != nil {
-				return "", err
-			}
-
-			heaps = append(heaps, i.Hash)
-		}
-
-		var like []string
-		for _, heap := range heaps {
-			like = append(like, bidxCol+" ILIKE "+"'%"+heap+"%'")
-		}
-
-		str = strings.Join(like, " "+cond+" ")
-	}
-	return
+	return crypto.BuildQueryLike(ctx, tx, data, cond)
 }
 
-// Deprecated: any is deprecated. Use interface{} instead.
+// Deprecated: failures yield FALSE; use crypto.GenerateSQLConditionsArgs.
 func GenerateSQLConditions(data any) (strs []string) {
-	entityValue := reflect.ValueOf(data)
-	entityType := entityValue.Type()
-
-	for i := 0; i < entityType.NumField(); i++ {
-		field := entityType.Field(i)
-		bidxCol := field.Tag.Get("bidx_col")
-		value := entityValue.Field(i).Interface()
-
-		if bidxCol == "" {
-			continue
-		}
-
-		strs = append(strs, bidxCol+" ILIKE "+"'%"+value.(string)+"%'")
-	}
-
-	return
+	return crypto.GenerateSQLConditions(data)
 }
 
 // Deprecated: any is deprecated. Use interface{} instead.
@@ -164,7 +113,10 @@ func InsertWithHeap[T Entity](c *crypto.Crypto, ctx context.Context, tx *sql.Tx,
 
 			switch fieldValue := entityValue.Field(i).Interface().(type) {
 			case types.AESCipher:
-				str, heaps := BuildHeap(c, fieldValue.To(), field.Tag.Get("txt_heap_table"))
+				str, heaps, err := BuildHeapErr(c, fieldValue.To(), field.Tag.Get("txt_heap_table"))
+				if err != nil {
+					return a, fmt.Errorf("failed to build heap: %w", err)
+				}
 				th = append(th, heaps...)
 				args = append(args, str)
 			}
@@ -172,7 +124,17 @@ func InsertWithHeap[T Entity](c *crypto.Crypto, ctx context.Context, tx *sql.Tx,
 		placeholders = append(placeholders, "$"+fmt.Sprint(len(placeholders)+1))
 	}
 
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id", tableName, strings.Join(fieldNames, ", "), strings.Join(placeholders, ", "))
+	table, err := sqlsafe.QuoteIdent(tableName)
+	if err != nil {
+		return a, err
+	}
+
+	columns, err := sqlsafe.QuoteIdents(fieldNames...)
+	if err != nil {
+		return a, err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id", table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
 
 	stmt, err := tx.PrepareContext(ctx, query)
 	if err != nil {
@@ -257,7 +219,10 @@ func UpdateWithHeap(c *crypto.Crypto, ctx context.Context, tx *sql.Tx, tableName
 
 			switch fieldValue := entityValue.Field(i).Interface().(type) {
 			case types.AESCipher:
-				str, heaps := BuildHeap(c, fieldValue.To(), field.Tag.Get("txt_heap_table"))
+				str, heaps, err := BuildHeapErr(c, fieldValue.To(), field.Tag.Get("txt_heap_table"))
+				if err != nil {
+					return fmt.Errorf("failed to build heap: %w", err)
+				}
 				th = append(th, heaps...)
 				args = append(args, str)
 			}
@@ -265,12 +230,23 @@ func UpdateWithHeap(c *crypto.Crypto, ctx context.Context, tx *sql.Tx, tableName
 		placeholders = append(placeholders, "$"+fmt.Sprint(len(placeholders)+1))
 	}
 
-	query := "UPDATE " + tableName + " SET "
-	for i, field := range fieldNames {
-		query += field + " = " + placeholders[i] + ", "
+	table, err := sqlsafe.QuoteIdent(tableName)
+	if err != nil {
+		return err
+	}
+
+	columns, err := sqlsafe.QuoteIdents(fieldNames...)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE " + table + " SET "
+	for i, column := range columns {
+		query += column + " = " + placeholders[i] + ", "
 	}
 	query = strings.TrimSuffix(query, ", ")
-	query += " WHERE id = '" + id + "'"
+	query += " WHERE id = " + sqlsafe.Placeholder(len(args)+1)
+	args = append(args, id)
 
 	stmt, err := tx.PrepareContext(ctx, query)
 	if err != nil {
@@ -306,7 +282,10 @@ func QueryLike[T Entity](ctx context.Context, basQuery string, tx *sql.Tx, iOpti
 	if iOptionalFilter != nil {
 		var likeParams ILikeParams
 		iOptionalFilter(&likeParams)
-		basQuery, args = buildLikeQuery(likeParams.ColumnHeap, basQuery, likeParams.Hash)
+		basQuery, args, err = buildLikeQuery(likeParams.ColumnHeap, basQuery, likeParams.Hash)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	rows, err := tx.QueryContext(ctx, basQuery, args...)
@@ -315,22 +294,18 @@ func QueryLike[T Entity](ctx context.Context, basQuery string, tx *sql.Tx, iOpti
 	}
 	defer rows.Close()
 
+	m, err := crypto.MapColumns[T](rows)
+	if err != nil {
+		return
+	}
+
 	for rows.Next() {
 		var i T
 		if iOptInitFunc != nil {
 			iOptInitFunc(&i)
 		}
-		entityValue := reflect.ValueOf(&i).Elem()
-		entityType := entityValue.Type()
 
-		scanArgs := make([]interface{}, entityType.NumField())
-
-		for i := 0; i < entityType.NumField(); i++ {
-			field := entityValue.Field(i).Addr().Interface()
-			scanArgs[i] = field
-		}
-
-		err = rows.Scan(scanArgs...)
+		err = m.Scan(rows, &i)
 		if err != nil {
 			return
 		}
@@ -340,28 +315,52 @@ func QueryLike[T Entity](ctx context.Context, basQuery string, tx *sql.Tx, iOpti
 	return
 }
 
-// Deprecated: any is deprecated. Use interface{} instead.
+// BuildHeap returns the heap tokens of value and their concatenated hashes,
+// or empty values on failure.
+//
+// Deprecated: a failure yields empty values; use BuildHeapErr.
 func BuildHeap(c *crypto.Crypto, value string, typeHeap string) (s string, th []TextHeap) {
+	s, th, _ = BuildHeapErr(c, value, typeHeap)
+	return
+}
+
+// BuildHeapErr returns the heap tokens of value and their concatenated hashes.
+func BuildHeapErr(c *crypto.Crypto, value string, typeHeap string) (s string, th []TextHeap, err error) {
 	var values = split(value)
 	builder := new(strings.Builder)
 	for _, value := range values {
-		builder.WriteString(hmacx.HMACHash(c.HMACFunc(), value).Hash().ToLast8DigitValue())
+		h, err := hmacx.HMACHash(c.HMACFunc(), value).HashErr()
+		if err != nil {
+			return "", nil, err
+		}
+
+		content, err := c.HeapContent(strings.ToLower(value))
+		if err != nil {
+			return "", nil, err
+		}
+
+		builder.WriteString(h.ToLast8DigitValue())
 		th = append(th, TextHeap{
-			Content: strings.ToLower(value),
+			Content: content,
 			Type:    typeHeap,
-			Hash:    hmacx.HMACHash(c.HMACFunc(), value).Hash().ToLast8DigitValue(),
+			Hash:    h.ToLast8DigitValue(),
 		})
 	}
-	return builder.String(), th
+	return builder.String(), th, nil
 }
 
 // Deprecated: any is deprecated. Use interface{} instead.
 func SearchContents(ctx context.Context, tx *sql.Tx, table string, args FindTextHeapByContentParams) (heaps []string, err error) {
+	quoted, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return nil, err
+	}
+
 	var query = new(strings.Builder)
 	query.WriteString("SELECT content, hash FROM ")
-	query.WriteString(table)
+	query.WriteString(quoted)
 	query.WriteString(" WHERE content ILIKE $1")
-	rows, err := tx.QueryContext(ctx, query.String(), "%"+args.Content+"%")
+	rows, err := tx.QueryContext(ctx, query.String(), sqlsafe.Contains(args.Content))
 	if err != nil {
 		return
 	}
@@ -382,36 +381,14 @@ func SearchContents(ctx context.Context, tx *sql.Tx, table string, args FindText
 	return
 }
 
-// Deprecated: any is deprecated. Use interface{} instead.
+// Deprecated: use crypto.InsertHeaps.
 func SaveToHeap(ctx context.Context, tx *sql.Tx, textHeaps []TextHeap) (err error) {
-	for _, th := range textHeaps {
-		query := new(strings.Builder)
-		query.WriteString("INSERT INTO ")
-		query.WriteString(th.Type)
-		query.WriteString(" (content, hash) VALUES ($1, $2)")
-		if ok, _ := isHashExist(ctx, tx, th.Type, FindTextHeapByHashParams{Hash: th.Hash}); !ok {
-			_, err = tx.ExecContext(ctx, query.String(), th.Content, th.Hash)
-		}
+	heaps := make([]crypto.TextHeap, len(textHeaps))
+	for i, th := range textHeaps {
+		heaps[i] = crypto.TextHeap(th)
 	}
-	return
-}
 
-// Deprecated: any is deprecated. Use interface{} instead.
-func isHashExist(ctx context.Context, tx *sql.Tx, typeHeap string, args FindTextHeapByHashParams) (bool, error) {
-	var query = new(strings.Builder)
-	query.WriteString("SELECT hash FROM ")
-	query.WriteString(typeHeap)
-	query.WriteString(" WHERE hash = $1")
-	row := tx.QueryRowContext(ctx, query.String(), args.Hash)
-	var i FindTextHeapRow
-	err := row.Scan(&i.Hash)
-	if err != nil {
-		return false, err
-	}
-	if i.Hash == args.Hash {
-		return true, nil
-	}
-	return false, nil
+	return crypto.InsertHeaps(ctx, tx, heaps)
 }
 
 // Deprecated: any is deprecated. Use interface{} instead.
@@ -444,16 +421,21 @@ func validateEmail(email string) bool {
 }
 
 // Deprecated: any is deprecated. Use interface{} instead.
-func buildLikeQuery(column, baseQuery string, terms []string) (string, []interface{}) {
+func buildLikeQuery(column, baseQuery string, terms []string) (string, []interface{}, error) {
 	var likeClauses []string
 	var args []interface{}
 
+	quoted, err := sqlsafe.QuoteIdent(column)
+	if err != nil {
+		return "", nil, err
+	}
+
 	for _, term := range terms {
-		likeClauses = append(likeClauses, column+" LIKE $"+fmt.Sprint(len(args)+1))
-		args = append(args, "%"+term+"%")
+		likeClauses = append(likeClauses, quoted+" LIKE "+sqlsafe.Placeholder(len(args)+1))
+		args = append(args, sqlsafe.Contains(term))
 	}
 
 	fullQuery := fmt.Sprintf("%s WHERE %s", baseQuery, strings.Join(likeClauses, " OR "))
 
-	return fullQuery, args
+	return fullQuery, args, nil
 }
@@ -0,0 +1,129 @@
+package crypto
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dyaksa/encryption-pii/crypto/rangeidx"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+	"github.com/lib/pq"
+)
+
+// Range indexes
+//
+// A string slice field tagged range receives from BindHeap the keyed hashes
+// of the buckets containing the field named like it without the last four
+// characters, a types.AESInt64, types.AESTime, integer or time.Time. The tag
+// lists the bucket widths, integers or Go durations for times, and may be
+// empty for the defaults of package rangeidx:
+//
+//	BirthDate     types.AESTime  `db:"birth_date"`
+//	BirthDateRidx pq.StringArray `db:"birth_date_ridx" range:"24h,384h,6144h"`
+//
+// stored in a text[] column with a GIN index. RangeCondition and
+// TimeRangeCondition expand a range into bucket hashes matched with &&. See
+// package rangeidx for the leakage.
+
+// RangeHashes returns the bucket hashes of v for column.
+func (c *Crypto) RangeHashes(column string, idx rangeidx.Index, v int64) ([]string, error) {
+	return c.bucketHashes(column, idx.Buckets(v))
+}
+
+func (c *Crypto) bucketHashes(column string, buckets []rangeidx.Bucket) ([]string, error) {
+	hashes := make([]string, len(buckets))
+	for i, b := range buckets {
+		h, err := c.heapHash("\x00range:" + strings.ToLower(column) + "\x00" + b.String())
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = h
+	}
+
+	return hashes, nil
+}
+
+// RangeCondition returns a condition matching rows whose range column lies
+// in [lo, hi], give or take the smallest bucket width at the edges, with its
+// argument numbered from startAt. Ranges wider than idx.MaxSpan() may fail
+// with rangeidx.ErrRangeTooWide, so open ranges need a bound: for salaries
+// above lo pass hi = lo+idx.MaxSpan()-1, or the largest salary there can be.
+func (c *Crypto) RangeCondition(column string, idx rangeidx.Index, lo, hi int64, startAt int) (string, []interface{}, error) {
+	keys, err := c.lookupKeys()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(keys) > 1 {
+		return anyKey(keys, startAt, func(k *Crypto, startAt int) (string, []interface{}, error) {
+			return k.RangeCondition(column, idx, lo, hi, startAt)
+		})
+	}
+
+	quoted, err := sqlsafe.QuoteIdent(column)
+	if err != nil {
+		return "", nil, err
+	}
+
+	buckets, err := idx.Cover(lo, hi)
+	if err != nil {
+		return "", nil, err
+	}
+
+	hashes, err := c.bucketHashes(column, buckets)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return quoted + " && " + sqlsafe.Placeholder(startAt) + "::text[]", []interface{}{pq.Array(hashes)}, nil
+}
+
+// TimeRangeCondition is RangeCondition for times in [from, to].
+func (c *Crypto) TimeRangeCondition(column string, idx rangeidx.Index, from, to time.Time, startAt int) (string, []interface{}, error) {
+	return c.RangeCondition(column, idx, rangeidx.TimeKey(from), rangeidx.TimeKey(to), startAt)
+}
+
+// bindRange fills the range tagged field of entity.
+func (c *Crypto) bindRange(entity reflect.Value, field reflect.StructField) error {
+	target := entity.FieldByName(field.Name)
+	if target.Kind() != reflect.Slice || target.Type().Elem().Kind() != reflect.String {
+		return fmt.Errorf("field %s: range fields must be string slices", field.Name)
+	}
+
+	source := entity.FieldByName(field.Name[:len(field.Name)-4])
+	if !source.IsValid() {
+		return fmt.Errorf("field %s: no field %s to index", field.Name, field.Name[:len(field.Name)-4])
+	}
+
+	var (
+		key   int64
+		times bool
+	)
+	switch v := source.Interface().(type) {
+	case types.AESInt64:
+		key = v.To()
+	case types.AESTime:
+		key, times = rangeidx.TimeKey(v.To()), true
+	case time.Time:
+		key, times = rangeidx.TimeKey(v), true
+	default:
+		if !source.CanInt() {
+			return fmt.Errorf("field %s: cannot range index %s", field.Name, source.Type())
+		}
+		key = source.Int()
+	}
+
+	idx, err := rangeidx.Parse(field.Tag.Get("range"), times)
+	if err != nil {
+		return fmt.Errorf("field %s: %w", field.Name, err)
+	}
+
+	hashes, err := c.RangeHashes(columnName(field), idx, key)
+	if err != nil {
+		return fmt.Errorf("failed to hash range buckets: %w", err)
+	}
+
+	target.Set(reflect.ValueOf(hashes).Convert(target.Type()))
+	return nil
+}
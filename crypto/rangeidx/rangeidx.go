@@ -0,0 +1,232 @@
+// Package rangeidx maps numbers and times to buckets for range queries over
+// encrypted columns.
+//
+// An Index has several bucket widths, each a multiple of the next smaller
+// one. A value is indexed by the bucket containing it at every width; callers
+// store keyed hashes of the buckets. A range is expanded with Cover into a
+// small set of buckets of mixed widths, and rows holding any of them match.
+// Matches are candidates: the buckets at the edges of the range stick out by
+// up to the smallest width, so filter after decrypting.
+//
+// Leakage: the index reveals which rows share a bucket at every width, so the
+// database learns clusters of close values and, with a known distribution
+// such as birth dates, can estimate bucket positions from their sizes. It
+// does not reveal the order of buckets. Order-revealing encryption was
+// deliberately left out: it reveals the order of all values (and for
+// practical schemes parts of their bits), which for dense, well known
+// distributions like dates and salaries allows recovering most plaintexts
+// from the ciphertexts alone.
+package rangeidx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidWidths = errors.New("invalid bucket widths")
+	ErrInvalidRange  = errors.New("invalid range")
+	ErrRangeTooWide  = errors.New("range needs too many buckets")
+)
+
+// MaxCoverBuckets bounds the number of buckets Cover returns, so a range
+// may span at most MaxCoverBuckets of the widest buckets. There is no bucket
+// holding every value: open ranges need an upper or lower bound, e.g. the
+// largest value the column can hold.
+const MaxCoverBuckets = 512
+
+// Bucket is the interval [Start, Start+Width).
+type Bucket struct {
+	Width int64
+	Start int64
+}
+
+// String returns the canonical form callers hash.
+func (b Bucket) String() string {
+	return strconv.FormatInt(b.Width, 10) + ":" + strconv.FormatInt(b.Start, 10)
+}
+
+// Index is a set of bucket widths, ascending.
+type Index struct {
+	widths []int64
+}
+
+// New returns an index with the given widths, each a multiple of the
+// smaller ones.
+func New(widths ...int64) (Index, error) {
+	w := append([]int64(nil), widths...)
+	sort.Slice(w, func(i, j int) bool { return w[i] < w[j] })
+
+	if len(w) == 0 || w[0] <= 0 {
+		return Index{}, fmt.Errorf("%w: %v", ErrInvalidWidths, widths)
+	}
+	for i := 1; i < len(w); i++ {
+		if w[i] == w[i-1] || w[i]%w[i-1] != 0 {
+			return Index{}, fmt.Errorf("%w: %d is not a multiple of %d", ErrInvalidWidths, w[i], w[i-1])
+		}
+	}
+
+	return Index{widths: w}, nil
+}
+
+// Durations returns an index over Unix seconds, see TimeKey.
+func Durations(widths ...time.Duration) (Index, error) {
+	w := make([]int64, len(widths))
+	for i, d := range widths {
+		if d%time.Second != 0 {
+			return Index{}, fmt.Errorf("%w: %s is not whole seconds", ErrInvalidWidths, d)
+		}
+		w[i] = int64(d / time.Second)
+	}
+	return New(w...)
+}
+
+// DefaultInts has widths 1, 16, 256, ... 16^7.
+func DefaultInts() Index {
+	idx, _ := New(1, 1<<4, 1<<8, 1<<12, 1<<16, 1<<20, 1<<24, 1<<28)
+	return idx
+}
+
+const day = 24 * time.Hour
+
+// DefaultTimes has widths of 1, 16, 256 and 4096 days.
+func DefaultTimes() Index {
+	idx, _ := Durations(day, 16*day, 256*day, 4096*day)
+	return idx
+}
+
+// Parse reads widths from a struct tag value: comma separated integers, or
+// Go durations for time indexes. Empty returns the default.
+func Parse(s string, times bool) (Index, error) {
+	if strings.TrimSpace(s) == "" {
+		if times {
+			return DefaultTimes(), nil
+		}
+		return DefaultInts(), nil
+	}
+
+	var (
+		ints      []int64
+		durations []time.Duration
+	)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if times {
+			d, err := time.ParseDuration(part)
+			if err != nil {
+				return Index{}, fmt.Errorf("%w: %q", ErrInvalidWidths, s)
+			}
+			durations = append(durations, d)
+			continue
+		}
+
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return Index{}, fmt.Errorf("%w: %q", ErrInvalidWidths, s)
+		}
+		ints = append(ints, n)
+	}
+
+	if times {
+		return Durations(durations...)
+	}
+	return New(ints...)
+}
+
+// MaxSpan returns the width of the widest range Cover accepts wherever it
+// lies: each edge may take up to ratio-1 buckets of every width below the
+// widest, the rest are widest buckets. An open range such as salaries above
+// lo can be queried as [lo, lo+MaxSpan()-1] when no value lies beyond. It
+// is 0 when the ratios between widths are too large for any guarantee.
+func (x Index) MaxSpan() int64 {
+	if len(x.widths) == 0 {
+		return 0
+	}
+
+	var edge int64
+	for i := 1; i < len(x.widths); i++ {
+		edge += x.widths[i]/x.widths[i-1] - 1
+		if edge > MaxCoverBuckets {
+			return 0
+		}
+	}
+
+	n := MaxCoverBuckets - 2*edge
+	widest := x.widths[len(x.widths)-1]
+	if n <= 0 {
+		return 0
+	}
+	if widest > math.MaxInt64/n {
+		return math.MaxInt64
+	}
+	return n * widest
+}
+
+func (x Index) Widths() []int64 {
+	return append([]int64(nil), x.widths...)
+}
+
+// Buckets returns the bucket containing v at every width.
+func (x Index) Buckets(v int64) []Bucket {
+	buckets := make([]Bucket, len(x.widths))
+	for i, w := range x.widths {
+		buckets[i] = Bucket{Width: w, Start: floor(v, w)}
+	}
+	return buckets
+}
+
+// Cover returns buckets covering [lo, hi], both inclusive, using the widest
+// buckets that fit. It fails with ErrRangeTooWide beyond MaxCoverBuckets;
+// ranges no wider than MaxSpan always fit.
+func (x Index) Cover(lo, hi int64) ([]Bucket, error) {
+	if len(x.widths) == 0 {
+		return nil, fmt.Errorf("%w: empty index", ErrInvalidWidths)
+	}
+	if lo > hi {
+		return nil, fmt.Errorf("%w: %d > %d", ErrInvalidRange, lo, hi)
+	}
+
+	smallest := x.widths[0]
+	pos := floor(lo, smallest)
+	last := floor(hi, smallest)
+
+	var buckets []Bucket
+	for {
+		// widest bucket aligned at pos that ends within the range
+		w := smallest
+		for _, width := range x.widths[1:] {
+			if floor(pos, width) == pos && pos <= math.MaxInt64-width && pos+width-smallest <= last {
+				w = width
+			}
+		}
+
+		buckets = append(buckets, Bucket{Width: w, Start: pos})
+		if len(buckets) > MaxCoverBuckets {
+			return nil, fmt.Errorf("%w: [%d, %d] is wider than %d buckets of %d; bound open ranges or add a wider bucket", ErrRangeTooWide, lo, hi, MaxCoverBuckets, x.widths[len(x.widths)-1])
+		}
+
+		if pos+w-smallest >= last {
+			return buckets, nil
+		}
+		pos += w
+	}
+}
+
+// TimeKey is the value time indexes bucket, t in Unix seconds.
+func TimeKey(t time.Time) int64 {
+	return t.Unix()
+}
+
+// floor rounds v down to a multiple of w, also for negative v.
+func floor(v, w int64) int64 {
+	r := v % w
+	if r < 0 {
+		r += w
+	}
+	return v - r
+}
@@ -0,0 +1,110 @@
+package rangeidx
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// checkCover fails unless buckets are contiguous, cover [lo, hi] and stick
+// out by less than the smallest width at either edge.
+func checkCover(t *testing.T, x Index, lo, hi int64, buckets []Bucket) {
+	t.Helper()
+
+	smallest := x.widths[0]
+	if len(buckets) == 0 || len(buckets) > MaxCoverBuckets {
+		t.Fatalf("[%d, %d]: %d buckets", lo, hi, len(buckets))
+	}
+
+	first, last := buckets[0], buckets[len(buckets)-1]
+	if first.Start > lo || lo-first.Start >= smallest {
+		t.Fatalf("[%d, %d]: first bucket %v", lo, hi, first)
+	}
+	if end := last.Start + last.Width - 1; end < hi || end-hi >= smallest {
+		t.Fatalf("[%d, %d]: last bucket %v", lo, hi, last)
+	}
+
+	for i, b := range buckets {
+		if floor(b.Start, b.Width) != b.Start {
+			t.Fatalf("[%d, %d]: bucket %v is not aligned", lo, hi, b)
+		}
+		if i > 0 && buckets[i-1].Start+buckets[i-1].Width != b.Start {
+			t.Fatalf("[%d, %d]: gap before bucket %v", lo, hi, b)
+		}
+	}
+}
+
+func TestCover(t *testing.T) {
+	small, err := New(1, 10, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		x      Index
+		lo, hi int64
+		want   int
+	}{
+		{"one value", small, 7, 7, 1},
+		{"aligned widest", small, 100, 199, 1},
+		{"edges", small, 95, 205, 5 + 1 + 6},
+		{"negative", small, -15, -1, 5 + 1},
+		{"dates", DefaultTimes(), 0, 86400*16 - 1, 1},
+		{"near max", DefaultInts(), math.MaxInt64 - 20, math.MaxInt64, 21},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets, err := tt.x.Cover(tt.lo, tt.hi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkCover(t, tt.x, tt.lo, tt.hi, buckets)
+			if len(buckets) != tt.want {
+				t.Fatalf("got %d buckets %v, want %d", len(buckets), buckets, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoverBounds(t *testing.T) {
+	for _, x := range []Index{DefaultInts(), DefaultTimes()} {
+		span := x.MaxSpan()
+		if span <= 0 {
+			t.Fatalf("%v: no span", x.widths)
+		}
+
+		// the worst offsets start and end just past a widest bucket
+		widest := x.widths[len(x.widths)-1]
+		for _, lo := range []int64{0, 1, widest - 1, -widest + 1, 12345} {
+			buckets, err := x.Cover(lo, lo+span-1)
+			if err != nil {
+				t.Fatalf("%v: span %d at %d: %v", x.widths, span, lo, err)
+			}
+			checkCover(t, x, lo, lo+span-1, buckets)
+		}
+
+		if _, err := x.Cover(1, math.MaxInt64); !errors.Is(err, ErrRangeTooWide) {
+			t.Fatalf("%v: open range: got %v, want ErrRangeTooWide", x.widths, err)
+		}
+	}
+
+	if _, err := DefaultInts().Cover(2, 1); !errors.Is(err, ErrInvalidRange) {
+		t.Fatalf("reversed range: got %v, want ErrInvalidRange", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, widths := range [][]int64{nil, {0}, {-1, 10}, {10, 15}, {10, 10}} {
+		if _, err := New(widths...); !errors.Is(err, ErrInvalidWidths) {
+			t.Fatalf("New(%v): got %v, want ErrInvalidWidths", widths, err)
+		}
+	}
+
+	x, err := New(100, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := x.Buckets(-5); got[0] != (Bucket{1, -5}) || got[1] != (Bucket{10, -10}) || got[2] != (Bucket{100, -100}) {
+		t.Fatalf("Buckets(-5) = %v", got)
+	}
+}
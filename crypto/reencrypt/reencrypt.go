@@ -0,0 +1,202 @@
+// Package reencrypt moves encrypted columns to another AES key or algorithm,
+// e.g. from aesx.AesCBC to aesx.AesGCM, as a resumable job.
+//
+// Every value is decrypted with the source key and algorithm and encrypted
+// again with the target ones; NULLs are left alone. With a GCM target,
+// values the target key already opens are skipped, so a batch repeated after
+// a crash does not fail. CBC and CFB targets cannot be recognized and would
+// be decrypted as garbage, so they need checkpoints kept in the database,
+// job.TxCheckpoints committed with their batch, and a job name that is not
+// reused for another run over the same rows.
+package reencrypt
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/core"
+	"github.com/dyaksa/encryption-pii/crypto/job"
+)
+
+// Key is an AES key together with the algorithm its values use.
+type Key struct {
+	Alg aesx.AesAlg
+	Key []byte
+}
+
+func (k Key) validate(name string) error {
+	switch k.Alg {
+	case aesx.AesCBC, aesx.AesCFB, aesx.AesGCM:
+	default:
+		return fmt.Errorf("%s: unsupported algorithm %q", name, k.Alg)
+	}
+
+	if err := core.CheckAESKey(k.Key); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+type Config struct {
+	job.Config
+
+	// Columns are the encrypted columns of Table.
+	Columns []string
+	From    Key
+	To      Key
+
+	// Workers bounds how many values are re-encrypted at once, GOMAXPROCS
+	// by default. Rows are written one after another.
+	Workers int
+}
+
+type row struct {
+	key     string
+	values  [][]byte
+	changed bool
+}
+
+func (cfg Config) validate() error {
+	if len(cfg.Columns) == 0 {
+		return errors.New("no columns to re-encrypt")
+	}
+
+	if err := cfg.From.validate("source key"); err != nil {
+		return err
+	}
+	if err := cfg.To.validate("target key"); err != nil {
+		return err
+	}
+	if cfg.From.Alg == cfg.To.Alg && bytes.Equal(cfg.From.Key, cfg.To.Key) {
+		return errors.New("source and target key are the same")
+	}
+
+	if _, ok := cfg.Checkpoints.(job.TxCheckpoints); cfg.To.Alg != aesx.AesGCM && !cfg.DryRun && !ok {
+		return fmt.Errorf("%s targets need checkpoints saved in the database, as a repeated batch would corrupt its rows", cfg.To.Alg)
+	}
+
+	return nil
+}
+
+// Run re-encrypts the columns of every row of cfg.Table. With DryRun every
+// value is decrypted and encrypted but nothing is written.
+func Run(ctx context.Context, db *sql.DB, cfg Config) (job.Stats, error) {
+	if err := cfg.validate(); err != nil {
+		return job.Stats{}, err
+	}
+
+	key := cfg.Key
+	if key == "" {
+		key = job.DefaultKey
+	}
+
+	update, err := job.Update(cfg.Table, key, cfg.Columns)
+	if err != nil {
+		return job.Stats{}, err
+	}
+
+	return job.Run(ctx, db, cfg.Config, func(ctx context.Context, tx *sql.Tx, cursor string, limit int) (string, int, error) {
+		query, args, err := job.Page(cfg.Table, key, append([]string{key}, cfg.Columns...), cursor, limit)
+		if err != nil {
+			return "", 0, err
+		}
+
+		rows, err := scanRows(ctx, tx, query, args, len(cfg.Columns))
+		if err != nil || len(rows) == 0 {
+			return "", 0, err
+		}
+
+		err = job.Parallel(ctx, cfg.Workers, len(rows), func(i int) error {
+			return cfg.reencryptRow(&rows[i])
+		})
+		if err != nil {
+			return "", 0, err
+		}
+
+		if !cfg.DryRun {
+			for _, r := range rows {
+				if !r.changed {
+					continue
+				}
+
+				args := make([]interface{}, 0, len(r.values)+1)
+				for _, v := range r.values {
+					args = append(args, v)
+				}
+				if _, err := tx.ExecContext(ctx, update, append(args, r.key)...); err != nil {
+					return "", 0, fmt.Errorf("failed to update row %s: %w", r.key, err)
+				}
+			}
+		}
+
+		return rows[len(rows)-1].key, len(rows), nil
+	})
+}
+
+func (cfg Config) reencryptRow(r *row) error {
+	for i, v := range r.values {
+		out, changed, err := cfg.reencrypt(v)
+		if err != nil {
+			return fmt.Errorf("row %s column %s: %w", r.key, cfg.Columns[i], err)
+		}
+
+		if changed {
+			r.values[i], r.changed = out, true
+		}
+	}
+
+	return nil
+}
+
+// reencrypt returns v encrypted under the target key and whether it changed.
+func (cfg Config) reencrypt(v []byte) ([]byte, bool, error) {
+	if v == nil {
+		return nil, false, nil
+	}
+
+	if cfg.To.Alg == aesx.AesGCM {
+		if _, err := aesx.Decrypt(aesx.AesGCM, cfg.To.Key, v); err == nil {
+			return v, false, nil
+		}
+	}
+
+	plain, err := aesx.Decrypt(cfg.From.Alg, cfg.From.Key, v)
+	if err != nil {
+		return nil, false, err
+	}
+
+	out, err := aesx.Encrypt(cfg.To.Alg, cfg.To.Key, plain)
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+func scanRows(ctx context.Context, tx *sql.Tx, query string, args []interface{}, columns int) ([]row, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []row
+	for rows.Next() {
+		r := row{values: make([][]byte, columns)}
+		dest := make([]interface{}, columns+1)
+		dest[0] = &r.key
+		for i := range r.values {
+			dest[i+1] = &r.values[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,75 @@
+package reencrypt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/job"
+)
+
+func TestValidateTarget(t *testing.T) {
+	from := Key{Alg: aesx.AesCBC, Key: []byte("0123456789abcdef0123456789abcdef")}
+	to := []byte("fedcba9876543210fedcba9876543210")
+
+	postgres, err := job.NewPostgres("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name        string
+		alg         aesx.AesAlg
+		checkpoints job.Checkpoints
+		dryRun      bool
+		ok          bool
+	}{
+		{"gcm without checkpoints", aesx.AesGCM, nil, false, true},
+		{"gcm in memory", aesx.AesGCM, job.NewMemory(), false, true},
+		{"cbc without checkpoints", aesx.AesCBC, nil, false, false},
+		{"cbc in memory", aesx.AesCBC, job.NewMemory(), false, false},
+		{"cfb in memory", aesx.AesCFB, job.NewMemory(), false, false},
+		{"cbc in postgres", aesx.AesCBC, postgres, false, true},
+		{"cfb in postgres", aesx.AesCFB, postgres, false, true},
+		{"cbc dry run", aesx.AesCBC, nil, true, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				Config:  job.Config{Name: "reencrypt", Table: "people", Checkpoints: tt.checkpoints, DryRun: tt.dryRun},
+				Columns: []string{"email"},
+				From:    from,
+				To:      Key{Alg: tt.alg, Key: to},
+			}
+
+			err := cfg.validate()
+			if tt.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.ok && (err == nil || !strings.Contains(err.Error(), "checkpoints saved in the database")) {
+				t.Fatalf("got error %v, want the target rejected", err)
+			}
+		})
+	}
+}
+
+func TestReencryptSkipsGCMTarget(t *testing.T) {
+	cfg := Config{
+		From: Key{Alg: aesx.AesCBC, Key: []byte("0123456789abcdef0123456789abcdef")},
+		To:   Key{Alg: aesx.AesGCM, Key: []byte("fedcba9876543210fedcba9876543210")},
+	}
+
+	v, err := aesx.Encrypt(cfg.From.Alg, cfg.From.Key, []byte("budi@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	once, changed, err := cfg.reencrypt(v)
+	if err != nil || !changed {
+		t.Fatalf("first pass: changed %v, error %v", changed, err)
+	}
+
+	twice, changed, err := cfg.reencrypt(once)
+	if err != nil || changed || string(twice) != string(once) {
+		t.Fatalf("second pass: changed %v, error %v", changed, err)
+	}
+}
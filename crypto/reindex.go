@@ -0,0 +1,197 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/dyaksa/encryption-pii/crypto/job"
+	"github.com/lib/pq"
+)
+
+// derivedTags are the tags of the fields BindHeap computes from other fields.
+var derivedTags = []string{"full_text_search", "txt_heap_table", "ngram", "prefix", "phonetic", "compound", "range"}
+
+func isDerived(field reflect.StructField) bool {
+	for _, tag := range derivedTags {
+		if getTagField(field, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reindex recomputes the blind indexes and heap tokens of every row of
+// cfg.Table under the primary HMAC key of c, e.g. after rotating the key, and
+// writes them back batch by batch. T maps the table by db tag: the other
+// tagged columns are read, the blind index columns written. init prepares
+// the cipher fields of every row as for QueryContext.
+//
+// Heap tokens go where BindHeapTx writes them. Tokens of the old key stay
+// in the heap tables until GCHeap removes them, with WithHeapRefs. Until
+// the job is done, search with WithDualKeyLookup and the old key in the
+// keyset.
+func Reindex[T Entity](ctx context.Context, c *Crypto, db *sql.DB, cfg job.Config, init func(*T)) (stats job.Stats, err error) {
+	defer func() { c.audit(ctx, AuditReindex, cfg.Table, err) }()
+
+	entityType := reflect.TypeOf((*T)(nil)).Elem()
+	if entityType.Kind() != reflect.Struct {
+		return stats, fmt.Errorf("entity must be a struct, got %s", entityType)
+	}
+
+	key := cfg.Key
+	if key == "" {
+		key = job.DefaultKey
+	}
+
+	var (
+		columns, derivedColumns []string
+		fields, derived         []int
+		keyField                = -1
+	)
+	for i := 0; i < entityType.NumField(); i++ {
+		field := entityType.Field(i)
+		if field.Tag.Get("db") == "" {
+			continue
+		}
+
+		column := columnName(field)
+		if isDerived(field) {
+			derivedColumns = append(derivedColumns, column)
+			derived = append(derived, i)
+			continue
+		}
+
+		columns = append(columns, column)
+		fields = append(fields, i)
+		if column == key {
+			keyField = i
+		}
+	}
+
+	if keyField < 0 {
+		return stats, fmt.Errorf("entity %s has no field tagged db:%q", entityType, key)
+	}
+	if len(derived) == 0 {
+		return stats, fmt.Errorf("entity %s has no blind index fields", entityType)
+	}
+
+	update, err := job.Update(cfg.Table, key, derivedColumns)
+	if err != nil {
+		return stats, err
+	}
+
+	// A dry run must not write heap tokens or references outside the
+	// transaction rolled back.
+	binder := c
+	if cfg.DryRun {
+		dry := *c
+		dry.heapRefs = false
+		binder = &dry
+	}
+
+	return job.Run(ctx, db, cfg, func(ctx context.Context, tx *sql.Tx, cursor string, limit int) (string, int, error) {
+		query, args, err := job.Page(cfg.Table, key, columns, cursor, limit)
+		if err != nil {
+			return "", 0, err
+		}
+
+		entities, err := scanEntities(ctx, tx, query, args, fields, init)
+		if err != nil || len(entities) == 0 {
+			return "", 0, err
+		}
+
+		for i := range entities {
+			save := func(ctx context.Context, heaps []TextHeap) error {
+				if cfg.DryRun {
+					return nil
+				}
+				return c.writeHeapTx(ctx, tx, heaps)
+			}
+			if err := binder.bindHeap(ctx, tx, &entities[i], save); err != nil {
+				return "", 0, err
+			}
+
+			v := reflect.ValueOf(&entities[i]).Elem()
+			args := make([]interface{}, 0, len(derived)+1)
+			for _, f := range derived {
+				args = append(args, columnValue(v.Field(f)))
+			}
+			args = append(args, v.Field(keyField).Interface())
+
+			if _, err := tx.ExecContext(ctx, update, args...); err != nil {
+				return "", 0, fmt.Errorf("failed to update %s: %w", cfg.Table, err)
+			}
+		}
+
+		last, err := cursorOf(reflect.ValueOf(&entities[len(entities)-1]).Elem().Field(keyField))
+		return last, len(entities), err
+	})
+}
+
+// scanEntities reads the rows of query into the given fields of new entities.
+func scanEntities[T Entity](ctx context.Context, tx *sql.Tx, query string, args []interface{}, fields []int, init func(*T)) ([]T, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entities []T
+	for rows.Next() {
+		var e T
+		if init != nil {
+			init(&e)
+		}
+
+		v := reflect.ValueOf(&e).Elem()
+		dest := make([]interface{}, len(fields))
+		for j, f := range fields {
+			dest[j] = v.Field(f).Addr().Interface()
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		entities = append(entities, e)
+	}
+
+	return entities, rows.Err()
+}
+
+// columnValue returns the argument writing the field v, with plain string
+// slices wrapped for text[] columns.
+func columnValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String {
+		if _, ok := v.Interface().(driver.Valuer); !ok {
+			return pq.Array(v.Interface())
+		}
+	}
+	return v.Interface()
+}
+
+// cursorOf returns the text form of the key field v.
+func cursorOf(v reflect.Value) (string, error) {
+	value := v.Interface()
+	if valuer, ok := value.(driver.Valuer); ok {
+		var err error
+		if value, err = valuer.Value(); err != nil {
+			return "", err
+		}
+	}
+
+	switch value := value.(type) {
+	case nil:
+		return "", errors.New("row key is NULL")
+	case []byte:
+		return string(value), nil
+	case time.Time:
+		return value.Format(time.RFC3339Nano), nil
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
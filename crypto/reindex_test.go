@@ -0,0 +1,347 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/job"
+	"github.com/dyaksa/encryption-pii/crypto/keyfile"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+)
+
+type rotatedPerson struct {
+	ID        int64           `db:"id"`
+	Email     types.AESCipher `db:"email"`
+	EmailBidx string          `db:"email_bidx" full_text_search:"true"`
+}
+
+// peopleDriver keeps a people (id, email, email_bidx) table in memory for
+// the job and query tests. It answers the keyset pages of job.Page, the
+// updates of job.Update and any other SELECT with every row in id order.
+// Changes made in a transaction become visible on commit.
+type peopleDriver struct{}
+
+type personRow struct {
+	id        int64
+	email     []byte
+	emailBidx string
+}
+
+var people struct {
+	sync.Mutex
+	rows []personRow
+
+	// failAt makes reading the row at that position of a result fail, when
+	// positive.
+	failAt int
+	// read counts the rows read and closed the result sets closed.
+	read, closed int
+}
+
+type peopleConn struct {
+	staged []personRow
+	inTx   bool
+}
+
+type peopleStmt struct {
+	conn  *peopleConn
+	query string
+}
+
+type peopleRows struct {
+	rows    []personRow
+	columns int
+	read    int
+}
+
+func (peopleDriver) Open(string) (driver.Conn, error) { return &peopleConn{}, nil }
+
+func (c *peopleConn) Prepare(query string) (driver.Stmt, error) {
+	return &peopleStmt{conn: c, query: query}, nil
+}
+func (c *peopleConn) Close() error { return nil }
+func (c *peopleConn) Begin() (driver.Tx, error) {
+	people.Lock()
+	defer people.Unlock()
+	c.staged, c.inTx = slices.Clone(people.rows), true
+	return c, nil
+}
+func (c *peopleConn) Commit() error {
+	people.Lock()
+	defer people.Unlock()
+	people.rows, c.staged, c.inTx = c.staged, nil, false
+	return nil
+}
+func (c *peopleConn) Rollback() error {
+	c.staged, c.inTx = nil, false
+	return nil
+}
+
+func (c *peopleConn) visible() []personRow {
+	if c.inTx {
+		return slices.Clone(c.staged)
+	}
+	people.Lock()
+	defer people.Unlock()
+	return slices.Clone(people.rows)
+}
+
+func (s *peopleStmt) Close() error  { return nil }
+func (s *peopleStmt) NumInput() int { return -1 }
+
+func (s *peopleStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s.query != `UPDATE "people" SET "email_bidx" = $1 WHERE "id" = $2` || !s.conn.inTx {
+		return nil, errors.New("people test driver: unexpected exec " + s.query)
+	}
+
+	for i := range s.conn.staged {
+		if s.conn.staged[i].id == args[1].(int64) {
+			s.conn.staged[i].emailBidx = args[0].(string)
+			return driver.RowsAffected(1), nil
+		}
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (s *peopleStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := s.conn.visible()
+
+	switch {
+	case strings.HasPrefix(s.query, `SELECT "id", "email" FROM "people" WHERE "id" > $1`):
+		cursor, err := strconv.ParseInt(args[0].(string), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		rows = slices.DeleteFunc(rows, func(r personRow) bool { return r.id <= cursor })
+		args = args[1:]
+		fallthrough
+	case strings.HasPrefix(s.query, `SELECT "id", "email" FROM "people"`):
+		rows = rows[:min(int(args[0].(int64)), len(rows))]
+		return &peopleRows{rows: rows, columns: 2}, nil
+	case strings.HasPrefix(s.query, "SELECT "):
+		return &peopleRows{rows: rows, columns: 3}, nil
+	}
+	return nil, errors.New("people test driver: unexpected query " + s.query)
+}
+
+func (r *peopleRows) Columns() []string {
+	return []string{"id", "email", "email_bidx"}[:r.columns]
+}
+func (r *peopleRows) Close() error {
+	people.Lock()
+	defer people.Unlock()
+	people.closed++
+	return nil
+}
+func (r *peopleRows) Next(dest []driver.Value) error {
+	if r.read == len(r.rows) {
+		return io.EOF
+	}
+	r.read++
+
+	people.Lock()
+	failAt := people.failAt
+	people.read++
+	people.Unlock()
+	if r.read == failAt {
+		return errors.New("people test driver: connection lost")
+	}
+
+	row := r.rows[r.read-1]
+	values := []driver.Value{row.id, row.email, row.emailBidx}
+	copy(dest, values)
+	return nil
+}
+
+func init() { sql.Register("crypto_people_test", peopleDriver{}) }
+
+// openPeople returns a database whose people table holds one row per email,
+// with ids from 1, encrypted and indexed by c.
+func openPeople(t *testing.T, c *Crypto, emails ...string) *sql.DB {
+	t.Helper()
+
+	rows := make([]personRow, len(emails))
+	for i, email := range emails {
+		encrypted, err := c.Encrypt(email, aesx.AesGCM).Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := c.HashStringErr(strings.ToLower(email))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows[i] = personRow{id: int64(i + 1), email: encrypted.([]byte), emailBidx: hash}
+	}
+
+	people.Lock()
+	people.rows, people.failAt, people.read, people.closed = rows, 0, 0, 0
+	people.Unlock()
+
+	db, err := sql.Open("crypto_people_test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func peopleBidx() []string {
+	people.Lock()
+	defer people.Unlock()
+
+	bidx := make([]string, len(people.rows))
+	for i, r := range people.rows {
+		bidx[i] = r.emailBidx
+	}
+	return bidx
+}
+
+var (
+	oldHMACKey = keyfile.Key{ID: 1, Material: []byte(testHMACKey)}
+	newHMACKey = keyfile.Key{ID: 2, Material: []byte("0123456789abcdef0123456789abcdef")}
+)
+
+// newRotatedCrypto returns a Crypto whose keyfile holds the HMAC keys, the
+// first as primary.
+func newRotatedCrypto(t *testing.T, hmacKeys []keyfile.Key, opts ...Opts) *Crypto {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	ks := keyfile.Keyset{
+		AES:  keyfile.Ring{Primary: 1, Keys: []keyfile.Key{{ID: 1, Material: []byte(testAESKey)}}},
+		HMAC: keyfile.Ring{Primary: hmacKeys[0].ID, Keys: hmacKeys},
+	}
+	if err := keyfile.WriteFile(path, ks, []byte("passphrase"), testKDF); err != nil {
+		t.Fatal(err)
+	}
+
+	return newTestCrypto(t, append([]Opts{WithKeyFile(path, func() ([]byte, error) { return []byte("passphrase"), nil })}, opts...)...)
+}
+
+func TestDualKeyLookup(t *testing.T) {
+	old := newRotatedCrypto(t, []keyfile.Key{oldHMACKey})
+	rotated := newRotatedCrypto(t, []keyfile.Key{newHMACKey, oldHMACKey})
+	dual := newRotatedCrypto(t, []keyfile.Key{newHMACKey, oldHMACKey}, WithDualKeyLookup())
+
+	oldHash, err := old.CompoundHash("name_dob_bidx", "Budi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newHash, err := rotated.CompoundHash("name_dob_bidx", "Budi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldHash == newHash {
+		t.Fatal("both keys give the same hash")
+	}
+
+	cond, args, err := rotated.CompoundCondition("name_dob_bidx", 3, "Budi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"name_dob_bidx" = $3`; cond != want || !slices.Equal(args, []interface{}{newHash}) {
+		t.Errorf("single key: %s %q, want %s %q", cond, args, want, newHash)
+	}
+
+	cond, args, err = dual.CompoundCondition("name_dob_bidx", 3, "Budi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `("name_dob_bidx" = $3 OR "name_dob_bidx" = $4)`; cond != want || !slices.Equal(args, []interface{}{newHash, oldHash}) {
+		t.Errorf("dual keys: %s %q, want %s %q", cond, args, want, []string{newHash, oldHash})
+	}
+
+	hashes, err := dual.LookupHashes("budi@mail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldShort, _ := old.HashErr("budi@mail.com")
+	newShort, _ := rotated.HashErr("budi@mail.com")
+	if !slices.Equal(hashes, []string{newShort, oldShort}) {
+		t.Errorf("LookupHashes = %q, want %q", hashes, []string{newShort, oldShort})
+	}
+	if hashes, _ := rotated.LookupHashes("budi@mail.com"); !slices.Equal(hashes, []string{newShort}) {
+		t.Errorf("LookupHashes without dual lookup = %q", hashes)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	ctx := context.Background()
+	emails := []string{"a@mail.com", "b@mail.com", "c@mail.com", "d@mail.com", "e@mail.com"}
+
+	old := newRotatedCrypto(t, []keyfile.Key{oldHMACKey})
+	rotated := newRotatedCrypto(t, []keyfile.Key{newHMACKey, oldHMACKey})
+	init := func(p *rotatedPerson) { p.Email = rotated.Decrypt(aesx.AesGCM) }
+
+	hashes := func(c *Crypto) []string {
+		t.Helper()
+		var h []string
+		for _, email := range emails {
+			s, err := c.HashStringErr(email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h = append(h, s)
+		}
+		return h
+	}
+	oldHashes, newHashes := hashes(old), hashes(rotated)
+
+	db := openPeople(t, old, emails...)
+
+	t.Run("dry run", func(t *testing.T) {
+		checkpoints := job.NewMemory()
+		stats, err := Reindex(ctx, rotated, db, job.Config{Name: "rotate", Table: "people", BatchSize: 2, DryRun: true, Checkpoints: checkpoints}, init)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Rows != 5 || stats.Batches != 3 {
+			t.Errorf("stats %+v, want 5 rows in 3 batches", stats)
+		}
+		if got := peopleBidx(); !slices.Equal(got, oldHashes) {
+			t.Errorf("dry run wrote %q", got)
+		}
+		if cursor, _ := checkpoints.Load(ctx, nil, "rotate"); cursor != "" {
+			t.Errorf("dry run saved checkpoint %q", cursor)
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		checkpoints := job.NewMemory()
+		if err := checkpoints.Save(ctx, nil, "rotate", "2"); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := Reindex(ctx, rotated, db, job.Config{Name: "rotate", Table: "people", BatchSize: 2, Checkpoints: checkpoints}, init)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Rows != 3 || stats.Cursor != "5" {
+			t.Errorf("stats %+v, want 3 rows up to 5", stats)
+		}
+
+		want := append(slices.Clone(oldHashes[:2]), newHashes[2:]...)
+		if got := peopleBidx(); !slices.Equal(got, want) {
+			t.Errorf("email_bidx %q, want %q", got, want)
+		}
+		if cursor, _ := checkpoints.Load(ctx, nil, "rotate"); cursor != "5" {
+			t.Errorf("checkpoint %q, want 5", cursor)
+		}
+
+		// a finished job only visits rows added since
+		stats, err = Reindex(ctx, rotated, db, job.Config{Name: "rotate", Table: "people", BatchSize: 2, Checkpoints: checkpoints}, init)
+		if err != nil || stats.Rows != 0 {
+			t.Errorf("second run: stats %+v, err %v", stats, err)
+		}
+	})
+}
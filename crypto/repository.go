@@ -0,0 +1,419 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+	"github.com/dyaksa/encryption-pii/crypto/job"
+	"github.com/dyaksa/encryption-pii/crypto/ngram"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+	"github.com/dyaksa/encryption-pii/crypto/types"
+	"github.com/lib/pq"
+)
+
+// ErrNoIndex is returned by Repository.FindBy and Search for fields without
+// a blind index to look them up by.
+var ErrNoIndex = errors.New("field has no blind index")
+
+// Repository stores entities of type T in one table. Columns are mapped to
+// the db tagged fields, embedded ones included, the field tagged db:"id"
+// being the primary key.
+// Cipher fields are bound to the keys of the Crypto and the algorithm of the
+// repository, so entities need no init function, and blind indexes and heap
+// tokens are written with every insert and update, in the same transaction
+// as far as the HeapWriteMode allows.
+type Repository[T Entity] struct {
+	c     *Crypto
+	db    *sql.DB
+	table string
+	alg   aesx.AesAlg
+
+	columns []structColumn
+	key     int
+}
+
+// NewRepository returns the repository of T in table. alg encrypts the
+// cipher fields, aesx.AesGCM when empty.
+func NewRepository[T Entity](c *Crypto, db *sql.DB, table string, alg aesx.AesAlg) (*Repository[T], error) {
+	if _, err := sqlsafe.QuoteIdent(table); err != nil {
+		return nil, err
+	}
+
+	if alg == "" {
+		alg = aesx.AesGCM
+	}
+
+	entityType := reflect.TypeOf((*T)(nil)).Elem()
+	sc, err := columnsOf(entityType)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.registerHeapAnalyzers(entityType); err != nil {
+		return nil, err
+	}
+
+	r := &Repository[T]{c: c, db: db, table: table, alg: alg, key: -1}
+	for _, column := range sc.columns {
+		if !column.tagged {
+			continue
+		}
+
+		if _, err := sqlsafe.QuoteIdent(column.name); err != nil {
+			return nil, fmt.Errorf("field %s: %w", column.field.Name, err)
+		}
+
+		if column.name == "id" {
+			r.key = len(r.columns)
+		}
+		r.columns = append(r.columns, column)
+	}
+
+	if r.key < 0 {
+		return nil, fmt.Errorf("entity %s has no field tagged db:\"id\"", entityType)
+	}
+	return r, nil
+}
+
+func (r *Repository[T]) names(columns []structColumn) []string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.name
+	}
+	return names
+}
+
+// bindCiphers binds the cipher fields of v to the keys of the repository,
+// keeping their plaintext.
+func (r *Repository[T]) bindCiphers(v reflect.Value) {
+	for _, column := range r.columns {
+		f := v.FieldByIndex(column.field.Index)
+		switch value := f.Interface().(type) {
+		case types.AESCipher:
+			f.Set(reflect.ValueOf(r.c.Encrypt(value.To(), r.alg)))
+		case types.AESInt64:
+			f.Set(reflect.ValueOf(r.c.EncryptInt64(value.To(), r.alg)))
+		case types.AESTime:
+			f.Set(reflect.ValueOf(r.c.EncryptTime(value.To(), r.alg)))
+		case types.AESCipherJSON:
+			f.Set(reflect.ValueOf(aesx.AESCipherJSON(r.c.AESFunc(), value.To(), r.alg)))
+		}
+	}
+}
+
+func (r *Repository[T]) scan(rows *sql.Rows, m ColumnMap) (T, error) {
+	var entity T
+	r.bindCiphers(reflect.ValueOf(&entity).Elem())
+
+	err := m.Scan(rows, &entity)
+	return entity, err
+}
+
+func (r *Repository[T]) query(ctx context.Context, where string, args ...interface{}) ([]T, error) {
+	table, _ := sqlsafe.QuoteIdent(r.table)
+	columns, _ := sqlsafe.QuoteIdents(r.names(r.columns)...)
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+strings.Join(columns, ", ")+" FROM "+table+" WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	m, err := MapColumns[T](rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var entities []T
+	for rows.Next() {
+		entity, err := r.scan(rows, m)
+		if err != nil {
+			return nil, err
+		}
+		entities = append(entities, entity)
+	}
+
+	return entities, rows.Err()
+}
+
+// write derives the blind indexes of entity and runs write with the columns
+// to store, before writing its heap tokens and references.
+func (r *Repository[T]) write(ctx context.Context, entity *T, write func(tx *sql.Tx, v reflect.Value) error) (err error) {
+	v := reflect.ValueOf(entity).Elem()
+	r.bindCiphers(v)
+
+	heaps, heapColumns, err := r.c.deriveHeap(v)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = write(tx, v); err != nil {
+		return err
+	}
+
+	if r.c.heapRefs {
+		rowID, err := cursorOf(v.FieldByIndex(r.columns[r.key].field.Index))
+		if err != nil {
+			return err
+		}
+		if err = r.c.saveHeapRefs(ctx, tx, r.table, rowID, heapColumns); err != nil {
+			return err
+		}
+	}
+
+	if err = r.c.writeHeapTx(ctx, tx, heaps); err != nil {
+		return fmt.Errorf("failed to save to heap: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// Insert stores entity. Without id the database assigns it and it is set on
+// entity.
+func (r *Repository[T]) Insert(ctx context.Context, entity *T) error {
+	return r.write(ctx, entity, func(tx *sql.Tx, v reflect.Value) error {
+		key := v.FieldByIndex(r.columns[r.key].field.Index)
+
+		columns := r.columns
+		if key.IsZero() {
+			columns = append(append([]structColumn(nil), r.columns[:r.key]...), r.columns[r.key+1:]...)
+		}
+
+		args := make([]interface{}, len(columns))
+		placeholders := make([]string, len(columns))
+		for i, column := range columns {
+			args[i] = columnValue(v.FieldByIndex(column.field.Index))
+			placeholders[i] = sqlsafe.Placeholder(i + 1)
+		}
+
+		table, _ := sqlsafe.QuoteIdent(r.table)
+		quoted, _ := sqlsafe.QuoteIdents(r.names(columns)...)
+		query := "INSERT INTO " + table + " (" + strings.Join(quoted, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ") RETURNING id"
+
+		if err := tx.QueryRowContext(ctx, query, args...).Scan(scanDest(key)); err != nil {
+			return fmt.Errorf("failed to insert into %s: %w", r.table, err)
+		}
+		return nil
+	})
+}
+
+// Update stores every column of entity in the row with its id, reporting
+// sql.ErrNoRows when there is none.
+func (r *Repository[T]) Update(ctx context.Context, entity *T) error {
+	return r.write(ctx, entity, func(tx *sql.Tx, v reflect.Value) error {
+		columns := append(append([]structColumn(nil), r.columns[:r.key]...), r.columns[r.key+1:]...)
+
+		args := make([]interface{}, 0, len(columns)+1)
+		for _, column := range columns {
+			args = append(args, columnValue(v.FieldByIndex(column.field.Index)))
+		}
+		args = append(args, columnValue(v.FieldByIndex(r.columns[r.key].field.Index)))
+
+		query, err := job.Update(r.table, "id", r.names(columns))
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %w", r.table, err)
+		}
+
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// Delete deletes the row with id. Its heap references go in the same
+// transaction, or before it commits when the heap lives elsewhere; its tokens
+// are left to GCHeap.
+func (r *Repository[T]) Delete(ctx context.Context, id interface{}) (err error) {
+	if id == nil {
+		return errors.New("id is required")
+	}
+
+	rowID, err := cursorOf(reflect.ValueOf(id))
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	table, _ := sqlsafe.QuoteIdent(r.table)
+	res, err := tx.ExecContext(ctx, "DELETE FROM "+table+` WHERE "id" = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete from %s: %w", r.table, err)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if r.c.heapRefs {
+		store, err := r.c.refStore(tx)
+		if err != nil {
+			return err
+		}
+		if err = store.DeleteRowRefs(ctx, r.table, rowID); err != nil {
+			return fmt.Errorf("failed to delete heap references: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// FindByID returns the entity with id, or sql.ErrNoRows.
+func (r *Repository[T]) FindByID(ctx context.Context, id interface{}) (T, error) {
+	entities, err := r.query(ctx, `"id" = $1`, id)
+	if err != nil || len(entities) == 0 {
+		var zero T
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return zero, err
+	}
+
+	return entities[0], nil
+}
+
+// index returns the column of the first field derived from field by one of
+// the given tags, and its struct field.
+func (r *Repository[T]) index(field string, tags ...string) (structColumn, string, error) {
+	var source string
+	for _, column := range r.columns {
+		if column.field.Name == field || column.name == field {
+			source = column.field.Name
+		}
+	}
+	if source == "" {
+		return structColumn{}, "", fmt.Errorf("entity has no field %s", field)
+	}
+
+	for _, tag := range tags {
+		for _, column := range r.columns {
+			f := column.field
+			if len(f.Name) == len(source)+4 && strings.HasPrefix(f.Name, source) && getTagField(f, tag) {
+				return column, tag, nil
+			}
+		}
+	}
+
+	return structColumn{}, "", fmt.Errorf("%w: %s", ErrNoIndex, field)
+}
+
+// FindBy returns the entities whose field, named by struct field or
+// column, equals value, looked up through its full_text_search or
+// txt_heap_table blind index. Matches are exact up to case and the field's
+// analyzer.
+func (r *Repository[T]) FindBy(ctx context.Context, field, value string) ([]T, error) {
+	column, tag, err := r.index(field, "full_text_search", "txt_heap_table")
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	switch tag {
+	case "full_text_search":
+		hashes, err = r.c.LookupHashStrings(strings.ToLower(value))
+	default:
+		hashes, err = r.heapLookupHashes(value, column.field)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	quoted, _ := sqlsafe.QuoteIdent(column.name)
+	return r.query(ctx, quoted+" = ANY($1)", pq.Array(hashes))
+}
+
+// heapLookupHashes returns the bidx value of value under every lookup key.
+func (r *Repository[T]) heapLookupHashes(value string, field reflect.StructField) ([]string, error) {
+	keys, err := r.c.lookupKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(keys))
+	for i, k := range keys {
+		if hashes[i], _, err = k.buildHeap(value, field.Tag.Get("txt_heap_table"), field.Tag.Get("analyzer")); err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+// Search returns the entities whose field, named by struct field or column,
+// matches term through its first index of txt_heap_table (any word of
+// term), ngram (substring), prefix or phonetic.
+func (r *Repository[T]) Search(ctx context.Context, field, term string) ([]T, error) {
+	column, tag, err := r.index(field, "txt_heap_table", "ngram", "prefix", "phonetic")
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		cond string
+		args []interface{}
+	)
+	switch tag {
+	case "txt_heap_table":
+		table := column.field.Tag.Get("txt_heap_table")
+		hashes, err := r.c.SearchContents(ctx, table, func(p *FindTextHeapByContentParams) {
+			p.Content = term
+			p.Analyzer = column.field.Tag.Get("analyzer")
+		})
+		if err != nil || len(hashes) == 0 {
+			return nil, err
+		}
+
+		patterns := make([]string, len(hashes))
+		for i, hash := range hashes {
+			patterns[i] = sqlsafe.Contains(hash)
+		}
+		quoted, _ := sqlsafe.QuoteIdent(column.name)
+		cond, args = quoted+" LIKE ANY($1)", []interface{}{pq.Array(patterns)}
+	case "ngram":
+		t, err := ngram.Parse(column.field.Tag.Get("ngram"))
+		if err != nil {
+			return nil, err
+		}
+		cond, args, err = r.c.NgramCondition(column.name, term, t, 1)
+		if err != nil {
+			return nil, err
+		}
+	case "prefix":
+		cond, args, err = r.c.PrefixCondition(ctx, column.name, term, 1)
+	case "phonetic":
+		cond, args, err = r.c.PhoneticCondition(column.name, term, 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return r.query(ctx, cond, args...)
+}
@@ -0,0 +1,49 @@
+package crypto
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/types"
+	"github.com/google/uuid"
+)
+
+// TestDeleteRowKey checks that Delete looks heap references up by the key
+// they were written under.
+func TestDeleteRowKey(t *testing.T) {
+	id := uuid.MustParse("7d444840-9dc0-11d1-b245-5ffdce74fad2")
+
+	for _, tt := range []struct {
+		name string
+		id   any
+		want string
+	}{
+		{"NullUuid", types.NullUuid{UUID: id, Valid: true}, id.String()},
+		{"NullInt64", types.NullInt64{NullInt64: sql.NullInt64{Int64: 42, Valid: true}}, "42"},
+		{"NullString", types.NullString{NullString: sql.NullString{String: "u-1", Valid: true}}, "u-1"},
+		{"int64", int64(42), "42"},
+		{"string", "u-1", "u-1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cursorOf(reflect.ValueOf(tt.id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("row key %q, want %q", got, tt.want)
+			}
+
+			entity := reflect.New(reflect.StructOf([]reflect.StructField{{
+				Name: "ID",
+				Type: reflect.TypeOf(tt.id),
+				Tag:  `db:"id"`,
+			}})).Elem()
+			entity.Field(0).Set(reflect.ValueOf(tt.id))
+
+			if written, _ := entityRowID(entity); written != got {
+				t.Fatalf("refs written under %q, deleted under %q", written, got)
+			}
+		})
+	}
+}
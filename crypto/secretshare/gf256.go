@@ -0,0 +1,62 @@
+package secretshare
+
+// Arithmetic in GF(2^8) using the AES reduction polynomial x^8 + x^4 + x^3 + x + 1.
+
+func add(a, b uint8) uint8 {
+	return a ^ b
+}
+
+func mul(a, b uint8) uint8 {
+	var p uint8
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+// inv returns the multiplicative inverse of a, computed as a^254.
+func inv(a uint8) uint8 {
+	r := a
+	for i := 0; i < 6; i++ {
+		r = mul(r, r)
+		r = mul(r, a)
+	}
+	return mul(r, r)
+}
+
+func div(a, b uint8) uint8 {
+	return mul(a, inv(b))
+}
+
+// evaluate computes the polynomial with the given coefficients at x using Horner's method.
+func evaluate(coefficients []uint8, x uint8) uint8 {
+	var out uint8
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		out = add(mul(out, x), coefficients[i])
+	}
+	return out
+}
+
+// interpolate evaluates the Lagrange polynomial through the points (xs[i], ys[i]) at zero.
+func interpolate(xs, ys []uint8) uint8 {
+	var out uint8
+	for i := range xs {
+		basis := uint8(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			basis = mul(basis, div(xs[j], add(xs[i], xs[j])))
+		}
+		out = add(out, mul(ys[i], basis))
+	}
+	return out
+}
@@ -0,0 +1,141 @@
+// Package secretshare implements Shamir's secret sharing over GF(256).
+//
+// A secret is split into n shares so that any k of them reconstruct it and
+// fewer than k reveal nothing about it. Every share is the secret's length
+// plus one trailing byte holding the share's x coordinate.
+package secretshare
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	MinThreshold = 2
+	MaxShares    = 255
+)
+
+var (
+	ErrInvalidThreshold = errors.New("secretshare: threshold must be between 2 and the number of shares")
+	ErrInvalidShares    = errors.New("secretshare: number of shares must be between 2 and 255")
+	ErrEmptySecret      = errors.New("secretshare: secret is empty")
+	ErrNotEnoughShares  = errors.New("secretshare: at least two shares are required")
+	ErrShareLength      = errors.New("secretshare: shares have different lengths")
+	ErrDuplicateShare   = errors.New("secretshare: duplicate share")
+)
+
+// Split divides secret into parts shares, any threshold of which can rebuild it.
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	return split(rand.Reader, secret, parts, threshold)
+}
+
+func split(random io.Reader, secret []byte, parts, threshold int) ([][]byte, error) {
+	if parts < MinThreshold || parts > MaxShares {
+		return nil, ErrInvalidShares
+	}
+
+	if threshold < MinThreshold || threshold > parts {
+		return nil, ErrInvalidThreshold
+	}
+
+	if len(secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+
+	xs, err := coordinates(random, parts)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = xs[i]
+	}
+
+	coefficients := make([]uint8, threshold)
+	for idx, b := range secret {
+		coefficients[0] = b
+		if _, err := io.ReadFull(random, coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("secretshare: read random coefficients: %w", err)
+		}
+
+		for i := range shares {
+			shares[i][idx] = evaluate(coefficients, xs[i])
+		}
+	}
+
+	for i := range coefficients {
+		coefficients[i] = 0
+	}
+
+	return shares, nil
+}
+
+// coordinates returns parts distinct non-zero x coordinates in random order.
+func coordinates(random io.Reader, parts int) ([]uint8, error) {
+	perm := make([]uint8, MaxShares)
+	for i := range perm {
+		perm[i] = uint8(i + 1)
+	}
+
+	buf := make([]byte, 1)
+	for i := len(perm) - 1; i > 0; i-- {
+		// reject values that would bias the modulo towards small indexes
+		limit := 256 - 256%(i+1)
+		for {
+			if _, err := io.ReadFull(random, buf); err != nil {
+				return nil, fmt.Errorf("secretshare: read random coordinates: %w", err)
+			}
+			if int(buf[0]) < limit {
+				break
+			}
+		}
+		j := int(buf[0]) % (i + 1)
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+
+	return perm[:parts], nil
+}
+
+// Combine rebuilds the secret from shares produced by Split. Supplying fewer
+// shares than the threshold yields a wrong secret rather than an error, so
+// callers should verify the result, e.g. against a known key check value.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < MinThreshold {
+		return nil, ErrNotEnoughShares
+	}
+
+	length := len(shares[0])
+	if length < 2 {
+		return nil, ErrShareLength
+	}
+
+	xs := make([]uint8, len(shares))
+	seen := make(map[uint8]struct{}, len(shares))
+	for i, share := range shares {
+		if len(share) != length {
+			return nil, ErrShareLength
+		}
+
+		x := share[length-1]
+		if _, ok := seen[x]; ok || x == 0 {
+			return nil, ErrDuplicateShare
+		}
+		seen[x] = struct{}{}
+		xs[i] = x
+	}
+
+	secret := make([]byte, length-1)
+	ys := make([]uint8, len(shares))
+	for idx := range secret {
+		for i, share := range shares {
+			ys[i] = share[idx]
+		}
+		secret[idx] = interpolate(xs, ys)
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,130 @@
+package secretshare
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	for _, tt := range []struct {
+		parts, threshold int
+	}{
+		{2, 2},
+		{3, 2},
+		{5, 3},
+		{5, 5},
+		{255, 16},
+	} {
+		shares, err := Split(secret, tt.parts, tt.threshold)
+		if err != nil {
+			t.Fatalf("Split(%d, %d): %v", tt.parts, tt.threshold, err)
+		}
+		if len(shares) != tt.parts {
+			t.Fatalf("Split(%d, %d) returned %d shares", tt.parts, tt.threshold, len(shares))
+		}
+
+		for _, subset := range [][][]byte{
+			shares[:tt.threshold],
+			shares[tt.parts-tt.threshold:],
+			shares,
+		} {
+			got, err := Combine(subset)
+			if err != nil {
+				t.Fatalf("Combine %d of %d/%d: %v", len(subset), tt.threshold, tt.parts, err)
+			}
+			if !bytes.Equal(got, secret) {
+				t.Errorf("Combine %d of %d/%d = %x, want %x", len(subset), tt.threshold, tt.parts, got, secret)
+			}
+		}
+	}
+}
+
+func TestSplitFewerSharesRevealNothing(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 64)
+	shares, err := Split(secret, 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Combine(shares[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, secret) {
+		t.Error("two of three shares rebuilt the secret")
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name             string
+		secret           []byte
+		parts, threshold int
+		want             error
+	}{
+		{"one share", []byte("s"), 1, 1, ErrInvalidShares},
+		{"too many shares", []byte("s"), 256, 2, ErrInvalidShares},
+		{"threshold one", []byte("s"), 3, 1, ErrInvalidThreshold},
+		{"threshold above shares", []byte("s"), 3, 4, ErrInvalidThreshold},
+		{"empty secret", nil, 3, 2, ErrEmptySecret},
+	} {
+		if _, err := Split(tt.secret, tt.parts, tt.threshold); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCombineErrors(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zero := append([]byte(nil), shares[1]...)
+	zero[len(zero)-1] = 0
+
+	for _, tt := range []struct {
+		name   string
+		shares [][]byte
+		want   error
+	}{
+		{"one share", shares[:1], ErrNotEnoughShares},
+		{"different lengths", [][]byte{shares[0], shares[1][1:]}, ErrShareLength},
+		{"too short", [][]byte{{1}, {2}}, ErrShareLength},
+		{"duplicate", [][]byte{shares[0], shares[0]}, ErrDuplicateShare},
+		{"zero coordinate", [][]byte{shares[0], zero}, ErrDuplicateShare},
+	} {
+		if _, err := Combine(tt.shares); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := make([][]byte, len(shares))
+	for i, share := range shares {
+		if decoded[i], err = Decode(" " + Encode(share) + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := Combine(decoded[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "secret" {
+		t.Errorf("got %q, want %q", got, "secret")
+	}
+
+	if _, err := Decode("not hex"); err == nil {
+		t.Error("Decode accepted a share that is not hex")
+	}
+}
@@ -0,0 +1,104 @@
+package secretshare
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encode returns the textual form of a share as written to share files.
+func Encode(share []byte) string {
+	return hex.EncodeToString(share)
+}
+
+// Decode parses a share produced by Encode.
+func Decode(s string) ([]byte, error) {
+	share, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("secretshare: invalid share encoding: %w", err)
+	}
+
+	return share, nil
+}
+
+// Source supplies the shares used to rebuild a secret at startup.
+type Source func() ([][]byte, error)
+
+// FromFiles reads shares from files, one or more encoded shares per file.
+func FromFiles(paths ...string) Source {
+	return func() ([][]byte, error) {
+		var shares [][]byte
+		for _, path := range paths {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, fmt.Errorf("secretshare: open share file: %w", err)
+			}
+
+			s, err := readShares(f)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("secretshare: %s: %w", path, err)
+			}
+
+			shares = append(shares, s...)
+		}
+
+		return shares, nil
+	}
+}
+
+// FromReader reads encoded shares from r, one per line, until EOF or an empty
+// line. Lines starting with # are ignored. Use FromReader(os.Stdin) to let
+// custodians type or paste their shares during startup.
+func FromReader(r io.Reader) Source {
+	return func() ([][]byte, error) {
+		return readShares(r)
+	}
+}
+
+func readShares(r io.Reader) (shares [][]byte, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			if len(shares) > 0 {
+				break
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		share, err := Decode(line)
+		if err != nil {
+			return nil, err
+		}
+
+		shares = append(shares, share)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+// CombineFrom reads shares from every source and rebuilds the secret.
+func CombineFrom(sources ...Source) ([]byte, error) {
+	var shares [][]byte
+	for _, source := range sources {
+		s, err := source()
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, s...)
+	}
+
+	return Combine(shares)
+}
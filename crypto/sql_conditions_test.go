@@ -0,0 +1,163 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
+)
+
+// heapDriver answers every query with heapRows as content, hash rows.
+type heapDriver struct{}
+
+var heapRows [][2]string
+
+type heapConn struct{}
+type heapStmt struct{}
+type heapTx struct{}
+type heapResult struct{ rows [][2]string }
+
+func (heapDriver) Open(string) (driver.Conn, error)  { return heapConn{}, nil }
+func (heapConn) Prepare(string) (driver.Stmt, error) { return heapStmt{}, nil }
+func (heapConn) Close() error                        { return nil }
+func (heapConn) Begin() (driver.Tx, error)           { return heapTx{}, nil }
+func (heapTx) Commit() error                         { return nil }
+func (heapTx) Rollback() error                       { return nil }
+func (heapStmt) Close() error                        { return nil }
+func (heapStmt) NumInput() int                       { return -1 }
+func (heapStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (heapStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &heapResult{rows: heapRows}, nil
+}
+func (*heapResult) Columns() []string { return []string{"content", "hash"} }
+func (*heapResult) Close() error      { return nil }
+func (r *heapResult) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	dest[0], dest[1] = r.rows[0][0], r.rows[0][1]
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func init() { sql.Register("crypto_heap_test", heapDriver{}) }
+
+// taggedValue returns a struct value with one string field holding value and
+// tagged with tags, whose values are quoted.
+func taggedValue(value string, tags ...string) any {
+	var tag []string
+	for i := 0; i < len(tags); i += 2 {
+		tag = append(tag, tags[i]+":"+strconv.Quote(tags[i+1]))
+	}
+
+	t := reflect.StructOf([]reflect.StructField{{
+		Name: "Value",
+		Type: reflect.TypeOf(""),
+		Tag:  reflect.StructTag(strings.Join(tag, " ")),
+	}})
+
+	v := reflect.New(t).Elem()
+	v.Field(0).SetString(value)
+	return v.Interface()
+}
+
+var (
+	argCondition     = regexp.MustCompile(`^"[a-z_][a-z0-9_]*"(\."[a-z_][a-z0-9_]*")? ILIKE \$[0-9]+$`)
+	literalCondition = regexp.MustCompile(`^"[a-z_][a-z0-9_]*"(\."[a-z_][a-z0-9_]*")? ILIKE '(?s:[^']|'')*'$`)
+)
+
+func FuzzGenerateSQLConditionsArgs(f *testing.F) {
+	f.Add("email_bidx", "a1b2c3d4")
+	f.Add("email_bidx", "' OR 1=1 --")
+	f.Add(`email_bidx" OR 1=1 --`, "x")
+	f.Add("public.email_bidx", "50%_\\")
+	f.Add("email_bidx", "o'brien\x00")
+
+	f.Fuzz(func(t *testing.T, column, value string) {
+		data := taggedValue(value, "bidx_col", column)
+
+		strs, args, err := GenerateSQLConditionsArgs(data, 3)
+		if err != nil {
+			if !errors.Is(err, sqlsafe.ErrInvalidIdentifier) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if strs := GenerateSQLConditions(data); !slices.Equal(strs, []string{"FALSE"}) {
+				t.Fatalf("GenerateSQLConditions accepted column %q: %q", column, strs)
+			}
+			return
+		}
+
+		if len(strs) != 1 || len(args) != 1 || !argCondition.MatchString(strs[0]) || !strings.HasSuffix(strs[0], "$3") {
+			t.Fatalf("condition %q with args %q lets input into the SQL text", strs, args)
+		}
+		if args[0] != sqlsafe.Contains(value) {
+			t.Fatalf("arg %q, want %q", args[0], sqlsafe.Contains(value))
+		}
+
+		inlined := GenerateSQLConditions(data)
+		if _, err := sqlsafe.QuoteLiteral(args[0].(string)); err != nil {
+			if !slices.Equal(inlined, []string{"FALSE"}) {
+				t.Fatalf("condition %q for a value that cannot be inlined", inlined)
+			}
+			return
+		}
+		if len(inlined) != 1 || !literalCondition.MatchString(inlined[0]) {
+			t.Fatalf("condition %q lets input out of its string literal", inlined)
+		}
+	})
+}
+
+func FuzzBuildQueryLikeArgs(f *testing.F) {
+	f.Add("email_bidx", "email_heap", "budi", "a1b2c3d4", "AND")
+	f.Add("email_bidx", "email_heap; drop table x", "budi", "a1b2c3d4", "OR")
+	f.Add("email_bidx", "email_heap", "' OR 1=1 --", "' OR 1=1 --", "OR 1=1")
+	f.Add("email_bidx", "public.email_heap", "50%", "%", " or ")
+
+	db, err := sql.Open("crypto_heap_test", "")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, column, table, value, hash, op string) {
+		heapRows = [][2]string{{value, hash}, {value, "a1b2c3d4"}}
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+
+		data := taggedValue(value, "bidx_col", column, "txt_heap_table", table)
+		cond, args, err := BuildQueryLikeArgs(context.Background(), tx, data, op, 1)
+		if err != nil {
+			if !errors.Is(err, sqlsafe.ErrInvalidIdentifier) && !errors.Is(err, sqlsafe.ErrInvalidOperator) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			return
+		}
+
+		parts := regexp.MustCompile(` (AND|OR) `).Split(cond, -1)
+		if len(parts) != len(args) || len(args) != len(heapRows) {
+			t.Fatalf("condition %q has %d args, want one per heap row", cond, len(args))
+		}
+		for i, part := range parts {
+			if !argCondition.MatchString(part) || part[strings.LastIndex(part, "$"):] != sqlsafe.Placeholder(i+1) {
+				t.Fatalf("condition %q lets input into the SQL text", cond)
+			}
+		}
+		if args[0] != sqlsafe.Contains(hash) {
+			t.Fatalf("arg %q, want %q", args[0], sqlsafe.Contains(hash))
+		}
+	})
+}
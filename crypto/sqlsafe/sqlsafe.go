@@ -0,0 +1,178 @@
+// Package sqlsafe keeps caller controlled input out of SQL text.
+//
+// Identifiers such as heap table and bidx column names come from struct tags
+// or function arguments and cannot be bound as parameters, so they are
+// validated against a strict pattern, optionally an allow-list, and quoted.
+// Values are always bound as parameters; EscapeLike makes them match
+// literally inside LIKE patterns.
+package sqlsafe
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidIdentifier = errors.New("invalid sql identifier")
+	ErrUnknownIdentifier = errors.New("sql identifier not allowed")
+	ErrInvalidOperator   = errors.New("invalid sql logical operator")
+	ErrInvalidLiteral    = errors.New("invalid sql string literal")
+)
+
+const maxIdentifierLen = 63
+
+var identifierPart = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// QuoteIdent validates name, an identifier optionally qualified by a schema
+// ("schema.table"), and returns it double quoted. Names are lower-cased first
+// so the quoted form refers to the same object as the unquoted one did.
+func QuoteIdent(name string) (string, error) {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidIdentifier, name)
+	}
+
+	for i, part := range parts {
+		if len(part) > maxIdentifierLen || !identifierPart.MatchString(part) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidIdentifier, name)
+		}
+		parts[i] = `"` + strings.ToLower(part) + `"`
+	}
+
+	return strings.Join(parts, "."), nil
+}
+
+// QuoteIdents quotes every name with QuoteIdent.
+func QuoteIdents(names ...string) ([]string, error) {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		q, err := QuoteIdent(name)
+		if err != nil {
+			return nil, err
+		}
+		quoted[i] = q
+	}
+
+	return quoted, nil
+}
+
+// Registry is an allow-list of identifiers. An empty registry accepts every
+// valid identifier, a non-empty one only the registered names.
+type Registry struct {
+	mu    sync.RWMutex
+	names map[string]struct{}
+}
+
+func NewRegistry(names ...string) (*Registry, error) {
+	r := &Registry{names: make(map[string]struct{})}
+	if err := r.Register(names...); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Register adds names to the allow-list.
+func (r *Registry) Register(names ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names == nil {
+		r.names = make(map[string]struct{})
+	}
+
+	for _, name := range names {
+		if _, err := QuoteIdent(name); err != nil {
+			return err
+		}
+		r.names[strings.ToLower(name)] = struct{}{}
+	}
+
+	return nil
+}
+
+// Names returns the registered names in sorted order.
+func (r *Registry) Names() []string {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.names))
+	for name := range r.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Quote checks name against the allow-list and quotes it.
+func (r *Registry) Quote(name string) (string, error) {
+	if r != nil {
+		r.mu.RLock()
+		_, ok := r.names[strings.ToLower(name)]
+		restricted := len(r.names) > 0
+		r.mu.RUnlock()
+
+		if restricted && !ok {
+			return "", fmt.Errorf("%w: %q", ErrUnknownIdentifier, name)
+		}
+	}
+
+	return QuoteIdent(name)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// EscapeLike escapes the LIKE wildcards in s so it matches literally. The
+// result assumes backslash as escape character, the default in Postgres;
+// other databases need an explicit ESCAPE '\' clause.
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// Contains returns a LIKE pattern matching values that contain s literally.
+func Contains(s string) string {
+	return "%" + EscapeLike(s) + "%"
+}
+
+// QuoteLiteral returns s as a single quoted string literal, for the deprecated
+// APIs that cannot bind it as a parameter. It assumes standard conforming
+// strings, the default since Postgres 9.1, and rejects NUL bytes and invalid
+// UTF-8, which Postgres text cannot hold.
+func QuoteLiteral(s string) (string, error) {
+	if strings.IndexByte(s, 0) >= 0 || !utf8.ValidString(s) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidLiteral, s)
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'", nil
+}
+
+// LogicalOperator validates op as AND or OR and returns it upper-cased.
+func LogicalOperator(op string) (string, error) {
+	switch o := strings.ToUpper(strings.TrimSpace(op)); o {
+	case "AND", "OR":
+		return o, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidOperator, op)
+	}
+}
+
+// Placeholder returns the Postgres placeholder for the n-th argument, starting at 1.
+func Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+var hexToken = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// IsHexToken reports whether s is a lower-case hex string, the form of every
+// blind index token.
+func IsHexToken(s string) bool {
+	return hexToken.MatchString(s)
+}
@@ -0,0 +1,131 @@
+package sqlsafe
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var quotedIdent = regexp.MustCompile(`^"[a-z_][a-z0-9_]{0,62}"(\."[a-z_][a-z0-9_]{0,62}")?$`)
+
+func FuzzQuoteIdent(f *testing.F) {
+	for _, seed := range []string{"users", "public.users", "Email_Heap", `a"b`, "a;drop table x", "a.b.c", "", " users", strings.Repeat("a", 64)} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		quoted, err := QuoteIdent(name)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidIdentifier) {
+				t.Fatalf("QuoteIdent(%q) error %v is not ErrInvalidIdentifier", name, err)
+			}
+			return
+		}
+
+		if !quotedIdent.MatchString(quoted) {
+			t.Fatalf("QuoteIdent(%q) = %s, not a plain quoted identifier", name, quoted)
+		}
+		if strings.ReplaceAll(quoted, `"`, "") != strings.ToLower(name) {
+			t.Fatalf("QuoteIdent(%q) = %s, names another object", name, quoted)
+		}
+	})
+}
+
+func FuzzRegistryQuote(f *testing.F) {
+	for _, seed := range []string{"email_heap", "EMAIL_HEAP", "name_heap", "other", `email_heap"`, "email_heap;--"} {
+		f.Add(seed)
+	}
+
+	r, err := NewRegistry("email_heap", "public.name_heap")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		quoted, err := r.Quote(name)
+		if err != nil {
+			return
+		}
+
+		lower := strings.ToLower(name)
+		if lower != "email_heap" && lower != "public.name_heap" {
+			t.Fatalf("Quote(%q) accepted a name outside the allow-list", name)
+		}
+		if !quotedIdent.MatchString(quoted) {
+			t.Fatalf("Quote(%q) = %s, not a plain quoted identifier", name, quoted)
+		}
+	})
+}
+
+// unescapeLike returns the literal matched by pattern, and false when pattern
+// has an unescaped wildcard or a dangling escape.
+func unescapeLike(pattern string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i+1 == len(pattern) {
+				return "", false
+			}
+			i++
+			b.WriteByte(pattern[i])
+		case '%', '_':
+			return "", false
+		default:
+			b.WriteByte(pattern[i])
+		}
+	}
+	return b.String(), true
+}
+
+func FuzzEscapeLike(f *testing.F) {
+	for _, seed := range []string{"budi", "50%", "a_b", `c:\dir`, `\%_`, "%%", ""} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		literal, ok := unescapeLike(EscapeLike(s))
+		if !ok || literal != s {
+			t.Fatalf("EscapeLike(%q) = %q does not match it literally", s, EscapeLike(s))
+		}
+
+		contains := Contains(s)
+		if !strings.HasPrefix(contains, "%") || !strings.HasSuffix(contains, "%") {
+			t.Fatalf("Contains(%q) = %q is not wrapped in wildcards", s, contains)
+		}
+		if literal, ok := unescapeLike(contains[1 : len(contains)-1]); !ok || literal != s {
+			t.Fatalf("Contains(%q) = %q has wildcards inside", s, contains)
+		}
+	})
+}
+
+func FuzzQuoteLiteral(f *testing.F) {
+	for _, seed := range []string{"budi", "o'brien", "''", `\'`, "a\x00b", "\xff", ""} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		literal, err := QuoteLiteral(s)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidLiteral) {
+				t.Fatalf("QuoteLiteral(%q) error %v is not ErrInvalidLiteral", s, err)
+			}
+			return
+		}
+
+		if len(literal) < 2 || literal[0] != '\'' || literal[len(literal)-1] != '\'' {
+			t.Fatalf("QuoteLiteral(%q) = %s is not quoted", s, literal)
+		}
+
+		// Every quote inside the literal must be doubled, so the literal
+		// cannot end early.
+		inner := literal[1 : len(literal)-1]
+		if strings.Count(strings.ReplaceAll(inner, "''", ""), "'") != 0 {
+			t.Fatalf("QuoteLiteral(%q) = %s ends early", s, literal)
+		}
+		if strings.ReplaceAll(inner, "''", "'") != s {
+			t.Fatalf("QuoteLiteral(%q) = %s does not read back", s, literal)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"iter"
+	"reflect"
+
+	"github.com/dyaksa/encryption-pii/crypto/job"
+)
+
+// QuerySeq is like QueryContext but yields the entities one by one as the
+// rows are read, so result sets of any size are decrypted in constant memory.
+// An error is yielded once, after which the sequence ends; breaking out of
+// the loop closes the rows.
+//
+//	for person, err := range crypto.QuerySeq(ctx, db, query, args, initPerson, nil) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func QuerySeq[D Database, T Entity](ctx context.Context, db D, baseQuery string, queryParams []interface{}, iOptInitFunc func(*T), IOptInitValue func(T)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		rows, err := db.QueryContext(ctx, baseQuery, queryParams...)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer rows.Close()
+
+		m, err := MapColumns[T](rows)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		for rows.Next() {
+			var i T
+			if iOptInitFunc != nil {
+				iOptInitFunc(&i)
+			}
+
+			if err = m.Scan(rows, &i); err != nil {
+				yield(zero, err)
+				return
+			}
+
+			if IOptInitValue != nil {
+				IOptInitValue(i)
+			}
+
+			if !yield(i, nil) {
+				return
+			}
+		}
+
+		if err = rows.Err(); err != nil {
+			yield(zero, err)
+		}
+	}
+}
+
+// QuerySeqParallel is like QuerySeq but reads batchSize rows at a time,
+// job.DefaultBatchSize when not positive, and decrypts them on at most
+// workers goroutines, GOMAXPROCS when not positive. Entities are yielded in
+// the order of the rows. IOptInitValue runs on the iterating goroutine.
+//
+// Columns scanned by a sql.Scanner, such as the types.AES* ciphers, are
+// decrypted by the workers; the other columns are scanned while reading.
+func QuerySeqParallel[D Database, T Entity](ctx context.Context, db D, baseQuery string, queryParams []interface{}, iOptInitFunc func(*T), IOptInitValue func(T), batchSize, workers int) iter.Seq2[T, error] {
+	if batchSize <= 0 {
+		batchSize = job.DefaultBatchSize
+	}
+
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		rows, err := db.QueryContext(ctx, baseQuery, queryParams...)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer rows.Close()
+
+		m, err := MapColumns[T](rows)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		var (
+			batch = make([]T, 0, batchSize)
+			raw   = make([][]interface{}, 0, batchSize)
+		)
+		flush := func() bool {
+			errs := make([]error, len(batch))
+			done := make([]bool, len(batch))
+			perr := job.Parallel(ctx, workers, len(batch), func(i int) error {
+				errs[i] = m.decrypt(&batch[i], raw[i])
+				done[i] = true
+				return errs[i]
+			})
+
+			for i, entity := range batch {
+				if !done[i] || errs[i] != nil {
+					if errs[i] != nil {
+						perr = errs[i]
+					}
+					yield(zero, perr)
+					return false
+				}
+
+				if IOptInitValue != nil {
+					IOptInitValue(entity)
+				}
+
+				if !yield(entity, nil) {
+					return false
+				}
+			}
+
+			batch, raw = batch[:0], raw[:0]
+			return true
+		}
+
+		for rows.Next() {
+			var i T
+			if iOptInitFunc != nil {
+				iOptInitFunc(&i)
+			}
+
+			values, err := m.scanDeferred(rows, &i)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			batch = append(batch, i)
+			raw = append(raw, values)
+			if len(batch) == batchSize && !flush() {
+				return
+			}
+		}
+
+		if err = rows.Err(); err != nil {
+			yield(zero, err)
+			return
+		}
+
+		if len(batch) > 0 {
+			flush()
+		}
+	}
+}
+
+// scanDeferred scans the current row of rows into entity, except for the
+// columns scanned by a sql.Scanner, whose values it returns for decrypt.
+func (m ColumnMap) scanDeferred(rows *sql.Rows, entity interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(entity).Elem()
+
+	dest := make([]interface{}, len(m))
+	values := make([]interface{}, len(m))
+	for i, index := range m {
+		f := v.FieldByIndex(index)
+		if _, ok := f.Addr().Interface().(sql.Scanner); ok {
+			dest[i] = &values[i]
+			continue
+		}
+		dest[i] = scanDest(f)
+	}
+
+	return values, rows.Scan(dest...)
+}
+
+// decrypt scans the values returned by scanDeferred into entity.
+func (m ColumnMap) decrypt(entity interface{}, values []interface{}) error {
+	v := reflect.ValueOf(entity).Elem()
+
+	for i, index := range m {
+		scanner, ok := v.FieldByIndex(index).Addr().Interface().(sql.Scanner)
+		if !ok {
+			continue
+		}
+
+		if err := scanner.Scan(values[i]); err != nil {
+			return fmt.Errorf("failed to scan column %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
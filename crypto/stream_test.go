@@ -0,0 +1,159 @@
+package crypto
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/dyaksa/encryption-pii/crypto/aesx"
+)
+
+const peopleQuery = `SELECT id, email, email_bidx FROM people ORDER BY id`
+
+var streamEmails = []string{"a@mail.com", "b@mail.com", "c@mail.com", "d@mail.com", "e@mail.com"}
+
+// querySeqs returns the sequences of QuerySeq and of QuerySeqParallel in
+// batches of two over the people table.
+func querySeqs(c *Crypto, db Database) map[string]iter.Seq2[rotatedPerson, error] {
+	init := func(p *rotatedPerson) { p.Email = c.Decrypt(aesx.AesGCM) }
+	return map[string]iter.Seq2[rotatedPerson, error]{
+		"sequential": QuerySeq(context.Background(), db, peopleQuery, nil, init, nil),
+		"parallel":   QuerySeqParallel(context.Background(), db, peopleQuery, nil, init, nil, 2, 4),
+	}
+}
+
+func TestQuerySeqOrder(t *testing.T) {
+	c := newTestCrypto(t)
+	db := openPeople(t, c, streamEmails...)
+
+	for name, seq := range querySeqs(c, db) {
+		var got []string
+		var ids []int64
+		for p, err := range seq {
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			got = append(got, p.Email.To())
+			ids = append(ids, p.ID)
+		}
+		if !slices.Equal(got, streamEmails) || !slices.Equal(ids, []int64{1, 2, 3, 4, 5}) {
+			t.Errorf("%s: got %q %v, want %q in row order", name, got, ids, streamEmails)
+		}
+	}
+}
+
+func TestQuerySeqYieldsErrorOnce(t *testing.T) {
+	c := newTestCrypto(t)
+
+	collect := func(seq iter.Seq2[rotatedPerson, error]) (got []string, errs int, afterErr bool) {
+		for p, err := range seq {
+			if errs > 0 {
+				afterErr = true
+			}
+			if err != nil {
+				errs++
+				continue
+			}
+			got = append(got, p.Email.To())
+		}
+		return got, errs, afterErr
+	}
+
+	t.Run("read", func(t *testing.T) {
+		for _, name := range []string{"sequential", "parallel"} {
+			db := openPeople(t, c, streamEmails...)
+			people.Lock()
+			people.failAt = 3
+			people.Unlock()
+
+			got, errs, afterErr := collect(querySeqs(c, db)[name])
+			if errs != 1 || afterErr || !slices.Equal(got, streamEmails[:2]) {
+				t.Errorf("%s: got %q with %d errors, yielded after error %v", name, got, errs, afterErr)
+			}
+		}
+	})
+
+	t.Run("decrypt", func(t *testing.T) {
+		db := openPeople(t, c, streamEmails...)
+		people.Lock()
+		people.rows[3].email = []byte("not a ciphertext")
+		people.Unlock()
+
+		for name, seq := range querySeqs(c, db) {
+			got, errs, afterErr := collect(seq)
+			if errs != 1 || afterErr || !slices.Equal(got, streamEmails[:3]) {
+				t.Errorf("%s: got %q with %d errors, yielded after error %v", name, got, errs, afterErr)
+			}
+		}
+	})
+}
+
+func TestQuerySeqBreakClosesRows(t *testing.T) {
+	c := newTestCrypto(t)
+
+	for _, name := range []string{"sequential", "parallel"} {
+		db := openPeople(t, c, streamEmails...)
+
+		for p, err := range querySeqs(c, db)[name] {
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			people.Lock()
+			closed := people.closed
+			people.Unlock()
+			if closed != 0 {
+				t.Fatalf("%s: rows closed before break at %d", name, p.ID)
+			}
+			break
+		}
+
+		people.Lock()
+		closed := people.closed
+		people.Unlock()
+		if closed != 1 {
+			t.Errorf("%s: %d result sets closed after break, want 1", name, closed)
+		}
+	}
+}
+
+func TestQuerySeqParallelDecryptsInWorkers(t *testing.T) {
+	c := newTestCrypto(t)
+	db := openPeople(t, c, streamEmails...)
+
+	// the number of rows read when each row's email was decrypted
+	var (
+		mu     sync.Mutex
+		readAt = map[int64]int{}
+	)
+	init := func(p *rotatedPerson) {
+		p.Email = c.Decrypt(aesx.AesGCM).OnDone(func(error) {
+			people.Lock()
+			read := people.read
+			people.Unlock()
+
+			mu.Lock()
+			readAt[p.ID] = read
+			mu.Unlock()
+		})
+	}
+
+	var got []string
+	for p, err := range QuerySeqParallel(context.Background(), db, peopleQuery, nil, init, nil, 2, 4) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p.Email.To())
+	}
+	if !slices.Equal(got, streamEmails) {
+		t.Fatalf("got %q, want %q", got, streamEmails)
+	}
+
+	// each batch of two is read whole before its emails are decrypted
+	for id, want := range map[int64]int{1: 2, 2: 2, 3: 4, 4: 4, 5: 5} {
+		if readAt[id] < want {
+			t.Errorf("row %d decrypted after reading %d rows, want at least %d", id, readAt[id], want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -32,6 +33,13 @@ func (nu *NullUuid) Scan(value interface{}) error {
 	return nil
 }
 
+func (nu NullUuid) Value() (driver.Value, error) {
+	if !nu.Valid {
+		return nil, nil
+	}
+	return nu.UUID.Value()
+}
+
 // MarshalJSON for NullUuid
 func (nu *NullUuid) MarshalJSON() ([]byte, error) {
 	if !nu.Valid {
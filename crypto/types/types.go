@@ -1,6 +1,8 @@
 package types
 
 import (
+	"time"
+
 	"github.com/dyaksa/encryption-pii/crypto/aesx"
 	"github.com/dyaksa/encryption-pii/crypto/core"
 	"github.com/dyaksa/encryption-pii/crypto/hmacx"
@@ -9,5 +11,7 @@ import (
 type (
 	AESCipher     = aesx.AES[string, core.PrimitiveAES]
 	AESCipherJSON = aesx.AES[map[string]interface{}, core.PrimitiveAES]
+	AESInt64      = aesx.AES[int64, core.PrimitiveAES]
+	AESTime       = aesx.AES[time.Time, core.PrimitiveAES]
 	HMACHash      = hmacx.HMAC[string, core.PrimitiveHMAC]
 )
@@ -8,13 +8,15 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/dyaksa/encryption-pii/crypto/analyzer"
+	"github.com/dyaksa/encryption-pii/crypto/heapstore"
 	"github.com/dyaksa/encryption-pii/crypto/hmacx"
+	"github.com/dyaksa/encryption-pii/crypto/sqlsafe"
 	"github.com/dyaksa/encryption-pii/crypto/types"
 	"github.com/dyaksa/encryption-pii/validate/nik"
 	"github.com/dyaksa/encryption-pii/validate/npwp"
 	"github.com/dyaksa/encryption-pii/validate/phone"
 	"github.com/google/uuid"
-	"github.com/lib/pq"
 )
 
 type Entity interface{}
@@ -30,15 +32,18 @@ func QueryContext[D Database, T Entity](ctx context.Context, db D, baseQuery str
 	}
 	defer rows.Close()
 
+	m, err := MapColumns[T](rows)
+	if err != nil {
+		return
+	}
+
 	for rows.Next() {
 		var i T
 		if iOptInitFunc != nil {
 			iOptInitFunc(&i)
 		}
 
-		colums := StructToInterfaceScan(&i)
-		err = rows.Scan(colums...)
-
+		err = m.Scan(rows, &i)
 		if err != nil {
 			return
 		}
@@ -65,6 +70,10 @@ type FindTextHeapRow struct {
 type FindTextHeapByContentParams struct {
 	Content  string
 	Patterns []string
+
+	// Analyzer tokenizes Content. Empty uses the analyzer the table was
+	// registered or written with, see WithHeapAnalyzer.
+	Analyzer string
 }
 
 type TextHeap struct {
@@ -73,7 +82,64 @@ type TextHeap struct {
 	Hash    string
 }
 
+// Deprecated: use BuildQueryLikeArgs, which binds the matched tokens as
+// parameters instead of inlining them into the condition.
 func BuildQueryLike(ctx context.Context, tx *sql.Tx, data any, cond string) (str string, err error) {
+	op, err := sqlsafe.LogicalOperator(cond)
+	if err != nil {
+		return "", err
+	}
+
+	conds, err := heapLikeConditions(ctx, tx, data)
+	if err != nil {
+		return "", err
+	}
+
+	var like []string
+	for _, c := range conds {
+		for _, hash := range c.hashes {
+			if !sqlsafe.IsHexToken(hash) {
+				return "", fmt.Errorf("unexpected heap hash %q", hash)
+			}
+			like = append(like, c.column+" ILIKE "+"'%"+hash+"%'")
+		}
+	}
+
+	return strings.Join(like, " "+op+" "), nil
+}
+
+// BuildQueryLikeArgs looks up the heap tokens matching every field tagged with
+// bidx_col and txt_heap_table and returns a condition on the bidx columns
+// joined by cond (AND or OR). The tokens are returned as args, numbered from
+// $startAt.
+func BuildQueryLikeArgs(ctx context.Context, tx *sql.Tx, data any, cond string, startAt int) (str string, args []interface{}, err error) {
+	op, err := sqlsafe.LogicalOperator(cond)
+	if err != nil {
+		return "", nil, err
+	}
+
+	conds, err := heapLikeConditions(ctx, tx, data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var like []string
+	for _, c := range conds {
+		for _, hash := range c.hashes {
+			like = append(like, c.column+" ILIKE "+sqlsafe.Placeholder(startAt+len(args)))
+			args = append(args, sqlsafe.Contains(hash))
+		}
+	}
+
+	return strings.Join(like, " "+op+" "), args, nil
+}
+
+type heapLikeCondition struct {
+	column string
+	hashes []string
+}
+
+func heapLikeConditions(ctx context.Context, tx *sql.Tx, data any) (conds []heapLikeCondition, err error) {
 	entityValue := reflect.ValueOf(data)
 	entityType := entityValue.Type()
 
@@ -81,16 +147,33 @@ func BuildQueryLike(ctx context.Context, tx *sql.Tx, data any, cond string) (str
 		field := entityType.Field(i)
 		bidxCol := field.Tag.Get("bidx_col")
 		heapCol := field.Tag.Get("txt_heap_table")
-		value := entityValue.Field(i).Interface()
+		if bidxCol == "" || heapCol == "" {
+			continue
+		}
+
+		value, ok := entityValue.Field(i).Interface().(string)
+		if !ok {
+			return nil, fmt.Errorf("field %s must be a string", field.Name)
+		}
+
+		column, err := sqlsafe.QuoteIdent(bidxCol)
+		if err != nil {
+			return nil, err
+		}
+
+		table, err := sqlsafe.QuoteIdent(heapCol)
+		if err != nil {
+			return nil, err
+		}
 
 		var query = new(strings.Builder)
 		query.WriteString("SELECT content, hash FROM ")
-		query.WriteString(heapCol)
+		query.WriteString(table)
 		query.WriteString(" WHERE content ILIKE $1")
 
-		rows, err := tx.QueryContext(ctx, query.String(), "%"+value.(string)+"%")
+		rows, err := tx.QueryContext(ctx, query.String(), sqlsafe.Contains(value))
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		var heaps []string
@@ -98,39 +181,74 @@ func BuildQueryLike(ctx context.Context, tx *sql.Tx, data any, cond string) (str
 			var i FindTextHeapRow
 			err = rows.Scan(&i.Content, &i.Hash)
 			if err != nil {
-				return "", err
+				rows.Close()
+				return nil, err
 			}
 
 			heaps = append(heaps, i.Hash)
 		}
+		rows.Close()
 
-		var like []string
-		for _, heap := range heaps {
-			like = append(like, bidxCol+" ILIKE "+"'%"+heap+"%'")
+		if err := rows.Err(); err != nil {
+			return nil, err
 		}
 
-		str = strings.Join(like, " "+cond+" ")
+		conds = append(conds, heapLikeCondition{column: column, hashes: heaps})
 	}
-	return
+	return conds, nil
 }
 
+// GenerateSQLConditions returns the conditions of GenerateSQLConditionsArgs
+// with the values inlined as string literals. When a bidx_col is not a valid
+// identifier or a value cannot be inlined it returns the single condition
+// FALSE, so the query matches nothing.
+//
+// Deprecated: failures yield FALSE; use GenerateSQLConditionsArgs.
 func GenerateSQLConditions(data any) (strs []string) {
+	strs, args, err := GenerateSQLConditionsArgs(data, 1)
+	if err != nil {
+		return []string{"FALSE"}
+	}
+
+	for i, arg := range args {
+		literal, err := sqlsafe.QuoteLiteral(arg.(string))
+		if err != nil {
+			return []string{"FALSE"}
+		}
+		strs[i] = strings.TrimSuffix(strs[i], sqlsafe.Placeholder(i+1)) + literal
+	}
+
+	return strs
+}
+
+// GenerateSQLConditionsArgs returns one "bidx_col ILIKE $n" condition per
+// field tagged with bidx_col, with the field values as args numbered from $startAt.
+func GenerateSQLConditionsArgs(data any, startAt int) (strs []string, args []interface{}, err error) {
 	entityValue := reflect.ValueOf(data)
 	entityType := entityValue.Type()
 
 	for i := 0; i < entityType.NumField(); i++ {
 		field := entityType.Field(i)
 		bidxCol := field.Tag.Get("bidx_col")
-		value := entityValue.Field(i).Interface()
-
 		if bidxCol == "" {
 			continue
 		}
 
-		strs = append(strs, bidxCol+" ILIKE "+"'%"+value.(string)+"%'")
+		value, ok := entityValue.Field(i).Interface().(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("field %s must be a string", field.Name)
+		}
+
+		column, err := sqlsafe.QuoteIdent(bidxCol)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		strs = append(strs, column+" ILIKE "+sqlsafe.Placeholder(startAt+len(args)))
+		args = append(args, sqlsafe.Contains(value))
 	}
 
-	return
+	return strs, args, nil
 }
 
 type ResultHeap struct {
@@ -138,13 +256,58 @@ type ResultHeap struct {
 	Value  string `json:"value"`
 }
 
+// BindHeap is BindHeapContext with context.Background.
 func (c *Crypto) BindHeap(entity any) (err error) {
+	return c.BindHeapContext(context.Background(), entity)
+}
+
+// BindHeapContext fills the bidx fields of entity and writes its heap tokens
+// to the heap database, honouring the deadline and cancellation of ctx.
+func (c *Crypto) BindHeapContext(ctx context.Context, entity any) (err error) {
+	defer func() { c.audit(ctx, AuditBindHeap, "", err) }()
+
+	return c.bindHeap(ctx, nil, entity, func(ctx context.Context, heaps []TextHeap) error {
+		store, err := c.heapStore()
+		if err != nil {
+			return err
+		}
+		return c.saveToHeap(ctx, store, heaps)
+	})
+}
+
+// bindHeap fills the bidx fields of entity and hands all of its heap tokens to
+// save at once. tx is the caller's transaction, if any.
+func (c *Crypto) bindHeap(ctx context.Context, tx *sql.Tx, entity any, save func(context.Context, []TextHeap) error) error {
 	entityPtrValue := reflect.ValueOf(entity)
 	if entityPtrValue.Kind() != reflect.Ptr {
 		return fmt.Errorf("entity harus berupa pointer")
 	}
 
 	entityValue := entityPtrValue.Elem()
+	th, columns, err := c.deriveHeap(entityValue)
+	if err != nil {
+		return err
+	}
+
+	if c.heapRefs {
+		if err := c.bindHeapRefs(ctx, tx, entityValue, columns); err != nil {
+			return err
+		}
+	}
+
+	if len(th) == 0 {
+		return nil
+	}
+
+	if err := save(ctx, th); err != nil {
+		return fmt.Errorf("failed to save to heap: %w", err)
+	}
+	return nil
+}
+
+// deriveHeap fills the bidx fields of entityValue and returns its heap tokens
+// by column.
+func (c *Crypto) deriveHeap(entityValue reflect.Value) (th []TextHeap, columns []heapColumn, err error) {
 	entityType := entityValue.Type()
 
 	for i := 0; i < entityType.NumField(); i++ {
@@ -157,13 +320,37 @@ func (c *Crypto) BindHeap(entity any) (err error) {
 			if fullTextSearch == "true" {
 				switch originalValue := entityValue.FieldByName(plainTextFieldName).Interface().(type) {
 				case types.AESCipher:
-					hash := hmacx.HMACHash(c.HMACFunc(), strings.ToLower(originalValue.To())).Hash().ToString()
+					hash, err := c.HashStringErr(strings.ToLower(originalValue.To()))
 					if err != nil {
-						return fmt.Errorf("failed to encrypt: %w", err)
+						return nil, nil, fmt.Errorf("failed to hash: %w", err)
 					}
 					bidxField.SetString(hash)
 				}
-				return
+			}
+			continue
+		case getTagField(field, "range"):
+			if err := c.bindRange(entityValue, field); err != nil {
+				return nil, nil, err
+			}
+			continue
+		case getTagField(field, "phonetic"):
+			if err := c.bindPhonetic(entityValue, field); err != nil {
+				return nil, nil, err
+			}
+			continue
+		case getTagField(field, "compound"):
+			if err := c.bindCompound(entityValue, field); err != nil {
+				return nil, nil, err
+			}
+			continue
+		case getTagField(field, "prefix"):
+			if err := c.bindPrefix(entityValue, field); err != nil {
+				return nil, nil, err
+			}
+			continue
+		case getTagField(field, "ngram"):
+			if err := c.bindNgram(entityValue, field); err != nil {
+				return nil, nil, err
 			}
 			continue
 		case getTagField(field, "txt_heap_table"):
@@ -173,18 +360,20 @@ func (c *Crypto) BindHeap(entity any) (err error) {
 
 			switch originalValue := entityValue.FieldByName(plainTextFieldName).Interface().(type) {
 			case types.AESCipher:
-				str, heaps := c.buildHeap(originalValue.To(), txtHeapTable)
-				err = c.saveToHeap(context.Background(), c.dbHeapPsql, heaps)
+				str, heaps, err := c.buildHeap(originalValue.To(), txtHeapTable, field.Tag.Get("analyzer"))
 				if err != nil {
-					return fmt.Errorf("failed to save to heap: %w", err)
+					return nil, nil, fmt.Errorf("failed to build heap: %w", err)
 				}
+				th = append(th, heaps...)
 				bidxField.SetString(str)
+				columns = append(columns, heapColumn{name: columnName(field), heaps: heaps})
 			}
 		default:
 			continue
 		}
 	}
-	return nil
+
+	return th, columns, nil
 }
 
 func getTagField(ref reflect.StructField, key string) bool {
@@ -195,69 +384,114 @@ func getTagField(ref reflect.StructField, key string) bool {
 }
 
 func (c *Crypto) SearchContents(ctx context.Context, table string, args func(*FindTextHeapByContentParams)) (heaps []string, err error) {
-	var query = new(strings.Builder)
-	var rows = new(sql.Rows)
+	defer func() { c.audit(ctx, AuditSearchContents, table, err) }()
+
 	var params FindTextHeapByContentParams
 	if args != nil {
 		args(&params)
 	}
 
-	query.WriteString("SELECT content, hash FROM ")
-	query.WriteString(table)
-	query.WriteString(` WHERE content ILIKE ANY ($1::text[])`)
+	if _, err = c.heapTable(table); err != nil {
+		return nil, err
+	}
 
-	contents := strings.Split(strings.ToLower(params.Content), " ")
-	if params.Patterns != nil {
-		contents = append(contents, params.Patterns...)
+	store, err := c.heapStore()
+	if err != nil {
+		return nil, err
 	}
 
-	rows, err = c.dbHeapPsql.QueryContext(ctx, query.String(), pq.Array(contents))
+	name, err := c.heapAnalyzers.resolve(table, params.Analyzer)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	defer rows.Close()
+	words, err := analyze(params.Content, name)
+	if err != nil {
+		return nil, err
+	}
 
-	seen := make(map[string]interface{})
-	for rows.Next() {
-		var i FindTextHeapRow
-		err = rows.Scan(&i.Content, &i.Hash)
+	keys, err := c.lookupKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []heapstore.Token
+	for _, k := range keys {
+		found, err := k.searchHeap(ctx, store, table, words, params.Patterns)
 		if err != nil {
-			return
+			return nil, err
 		}
-		if _, exist := seen[i.Hash]; !exist {
-			heaps = append(heaps, i.Hash)
-			seen[i.Hash] = struct{}{}
+		tokens = append(tokens, found...)
+	}
+
+	seen := make(map[string]interface{})
+	for _, t := range tokens {
+		if _, exist := seen[t.Hash]; !exist {
+			heaps = append(heaps, t.Hash)
+			seen[t.Hash] = struct{}{}
 		}
 	}
-	return
+	return heaps, nil
 }
 
-func (c *Crypto) saveToHeap(ctx context.Context, db *sql.DB, textHeaps []TextHeap) (err error) {
-	for _, th := range textHeaps {
-		query := new(strings.Builder)
-		query.WriteString("INSERT INTO ")
-		query.WriteString(th.Type)
-		query.WriteString(" (content, hash) VALUES ($1, $2)")
-		if ok, _ := isHashExist(ctx, db, th.Type, FindTextHeapByHashParams{Hash: th.Hash}); !ok {
-			_, err = db.ExecContext(ctx, query.String(), th.Content, th.Hash)
+// analyze returns the heap tokens of value. Without analyzer name the legacy
+// split applies and the tokens are lower-cased.
+func analyze(value, name string) ([]string, error) {
+	if name == "" {
+		var tokens []string
+		for _, token := range split(value) {
+			tokens = append(tokens, strings.ToLower(token))
 		}
+		return tokens, nil
 	}
-	return
+
+	a, err := analyzer.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return a.Analyze(value), nil
 }
 
-func (c *Crypto) buildHeap(value string, typeHeap string) (s string, th []TextHeap) {
-	var values = split(value)
+func (c *Crypto) buildHeap(value, typeHeap, analyzerName string) (s string, th []TextHeap, err error) {
+	if err := c.heapAnalyzers.set(typeHeap, analyzerName); err != nil {
+		return "", nil, err
+	}
+
+	values, err := analyze(value, analyzerName)
+	if err != nil {
+		return "", nil, err
+	}
+
 	builder := new(strings.Builder)
 	for _, value := range values {
-		builder.WriteString(hmacx.HMACHash(c.HMACFunc(), strings.ToLower(value)).Hash().ToLast8DigitValue())
+		hash, err := c.heapHash(value)
+		if err != nil {
+			return "", nil, err
+		}
+
+		content, err := c.HeapContent(value)
+		if err != nil {
+			return "", nil, err
+		}
+
+		builder.WriteString(hash)
 		th = append(th, TextHeap{
-			Content: strings.ToLower(value),
+			Content: content,
 			Type:    typeHeap,
-			Hash:    hmacx.HMACHash(c.HMACFunc(), strings.ToLower(value)).Hash().ToLast8DigitValue(),
+			Hash:    hash,
 		})
 	}
-	return builder.String(), th
+	return builder.String(), th, nil
+}
+
+// heapHash returns the 8 digit token stored in bidx columns and heap tables.
+func (c *Crypto) heapHash(value string) (string, error) {
+	h, err := hmacx.HMACHash(c.HMACFunc(), value).HashErr()
+	if err != nil {
+		return "", err
+	}
+
+	return h.ToLast8DigitValue(), nil
 }
 
 // Deprecated: any is deprecated. Use interface{} instead.
@@ -268,7 +502,10 @@ func insertWithHeap[T Entity](c *Crypto, ctx context.Context, tx *sql.Tx, tableN
 	var args []interface{}
 	var placeholders []string
 
-	var th []TextHeap
+	var (
+		th          []TextHeap
+		heapColumns []heapColumn
+	)
 	for i := 0; i < entityType.NumField(); i++ {
 		field := entityType.Field(i)
 		fieldName := field.Tag.Get("db")
@@ -324,21 +561,30 @@ func insertWithHeap[T Entity](c *Crypto, ctx context.Context, tx *sql.Tx, tableN
 
 			switch fieldValue := entityValue.Field(i).Interface().(type) {
 			case types.AESCipher:
-				str, heaps := buildHeap(c, fieldValue.To(), field.Tag.Get("txt_heap_table"))
+				str, heaps, err := buildHeap(c, fieldValue.To(), field.Tag.Get("txt_heap_table"), field.Tag.Get("analyzer"))
+				if err != nil {
+					return a, fmt.Errorf("failed to build heap: %w", err)
+				}
 				th = append(th, heaps...)
+				heapColumns = append(heapColumns, heapColumn{name: bidxCol, heaps: heaps})
 				args = append(args, str)
 			}
 		}
 		placeholders = append(placeholders, "$"+fmt.Sprint(len(placeholders)+1))
 	}
 
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id", tableName, strings.Join(fieldNames, ", "), strings.Join(placeholders, ", "))
+	table, err := sqlsafe.QuoteIdent(tableName)
+	if err != nil {
+		return a, err
+	}
 
-	err = saveToHeap(ctx, c.dbHeapPsql, th)
+	columns, err := sqlsafe.QuoteIdents(fieldNames...)
 	if err != nil {
-		return a, fmt.Errorf("failed to save to heap please check heap db connection: %w", err)
+		return a, err
 	}
 
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id", table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+
 	stmt, err := tx.PrepareContext(ctx, query)
 	if err != nil {
 		return a, fmt.Errorf("failed to prepare statement: %w", err)
@@ -350,6 +596,17 @@ func insertWithHeap[T Entity](c *Crypto, ctx context.Context, tx *sql.Tx, tableN
 		return a, fmt.Errorf("failed to execute statement: %w", err)
 	}
 
+	if c.heapRefs {
+		if err = c.saveHeapRefs(ctx, tx, tableName, fmt.Sprint(a), heapColumns); err != nil {
+			return a, err
+		}
+	}
+
+	err = c.writeHeapTx(ctx, tx, th)
+	if err != nil {
+		return a, fmt.Errorf("failed to save to heap please check heap db connection: %w", err)
+	}
+
 	return a, nil
 }
 
@@ -362,7 +619,10 @@ func updateWithHeap(c *Crypto, ctx context.Context, tx *sql.Tx, tableName string
 	var placeholders []string
 	var args []interface{}
 
-	var th []TextHeap
+	var (
+		th          []TextHeap
+		heapColumns []heapColumn
+	)
 	for i := 0; i < entityType.NumField(); i++ {
 		field := entityType.Field(i)
 		fieldName := field.Tag.Get("db")
@@ -418,20 +678,35 @@ func updateWithHeap(c *Crypto, ctx context.Context, tx *sql.Tx, tableName string
 
 			switch fieldValue := entityValue.Field(i).Interface().(type) {
 			case types.AESCipher:
-				str, heaps := buildHeap(c, fieldValue.To(), field.Tag.Get("txt_heap_table"))
+				str, heaps, err := buildHeap(c, fieldValue.To(), field.Tag.Get("txt_heap_table"), field.Tag.Get("analyzer"))
+				if err != nil {
+					return fmt.Errorf("failed to build heap: %w", err)
+				}
 				th = append(th, heaps...)
+				heapColumns = append(heapColumns, heapColumn{name: bidxCol, heaps: heaps})
 				args = append(args, str)
 			}
 		}
 		placeholders = append(placeholders, "$"+fmt.Sprint(len(placeholders)+1))
 	}
 
-	query := "UPDATE " + tableName + " SET "
-	for i, field := range fieldNames {
-		query += field + " = " + placeholders[i] + ", "
+	table, err := sqlsafe.QuoteIdent(tableName)
+	if err != nil {
+		return err
+	}
+
+	columns, err := sqlsafe.QuoteIdents(fieldNames...)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE " + table + " SET "
+	for i, column := range columns {
+		query += column + " = " + placeholders[i] + ", "
 	}
 	query = strings.TrimSuffix(query, ", ")
-	query += " WHERE id = '" + id + "'"
+	query += " WHERE id = " + sqlsafe.Placeholder(len(args)+1)
+	args = append(args, id)
 
 	stmt, err := tx.PrepareContext(ctx, query)
 	if err != nil {
@@ -444,7 +719,13 @@ func updateWithHeap(c *Crypto, ctx context.Context, tx *sql.Tx, tableName string
 		return fmt.Errorf("failed to execute statement: %w", err)
 	}
 
-	err = saveToHeap(ctx, c.dbHeapPsql, th)
+	if c.heapRefs {
+		if err = c.saveHeapRefs(ctx, tx, tableName, id, heapColumns); err != nil {
+			return err
+		}
+	}
+
+	err = c.writeHeapTx(ctx, tx, th)
 	if err != nil {
 		return fmt.Errorf("failed to save to heap: %w", err)
 	}
@@ -462,7 +743,10 @@ func QueryLike[T Entity](ctx context.Context, basQuery string, tx *sql.Tx, iOpti
 	if iOptionalFilter != nil {
 		var likeParams ILikeParams
 		iOptionalFilter(&likeParams)
-		basQuery, args = buildLikeQuery(likeParams.ColumnHeap, basQuery, likeParams.Hash)
+		basQuery, args, err = buildLikeQuery(likeParams.ColumnHeap, basQuery, likeParams.Hash)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	rows, err := tx.QueryContext(ctx, basQuery, args...)
@@ -471,22 +755,18 @@ func QueryLike[T Entity](ctx context.Context, basQuery string, tx *sql.Tx, iOpti
 	}
 	defer rows.Close()
 
+	m, err := MapColumns[T](rows)
+	if err != nil {
+		return
+	}
+
 	for rows.Next() {
 		var i T
 		if iOptInitFunc != nil {
 			iOptInitFunc(&i)
 		}
-		entityValue := reflect.ValueOf(&i).Elem()
-		entityType := entityValue.Type()
-
-		scanArgs := make([]interface{}, entityType.NumField())
-
-		for i := 0; i < entityType.NumField(); i++ {
-			field := entityValue.Field(i).Addr().Interface()
-			scanArgs[i] = field
-		}
 
-		err = rows.Scan(scanArgs...)
+		err = m.Scan(rows, &i)
 		if err != nil {
 			return
 		}
@@ -497,27 +777,55 @@ func QueryLike[T Entity](ctx context.Context, basQuery string, tx *sql.Tx, iOpti
 }
 
 // deprecated function
-func buildHeap(c *Crypto, value string, typeHeap string) (s string, th []TextHeap) {
+func buildHeap(c *Crypto, value, typeHeap, analyzerName string) (s string, th []TextHeap, err error) {
+	if analyzerName != "" {
+		return c.buildHeap(value, typeHeap, analyzerName)
+	}
+
+	if err := c.heapAnalyzers.set(typeHeap, ""); err != nil {
+		return "", nil, err
+	}
+
 	var values = split(value)
 	builder := new(strings.Builder)
 	for _, value := range values {
-		builder.WriteString(hmacx.HMACHash(c.HMACFunc(), strings.ToLower(value)).Hash().ToLast8DigitValue())
+		token, err := c.heapHash(strings.ToLower(value))
+		if err != nil {
+			return "", nil, err
+		}
+
+		hash, err := c.heapHash(value)
+		if err != nil {
+			return "", nil, err
+		}
+
+		content, err := c.HeapContent(strings.ToLower(value))
+		if err != nil {
+			return "", nil, err
+		}
+
+		builder.WriteString(token)
 		th = append(th, TextHeap{
-			Content: strings.ToLower(value),
+			Content: content,
 			Type:    typeHeap,
-			Hash:    hmacx.HMACHash(c.HMACFunc(), value).Hash().ToLast8DigitValue(),
+			Hash:    hash,
 		})
 	}
-	return builder.String(), th
+	return builder.String(), th, nil
 }
 
 // deprecated function
 func searchContents(ctx context.Context, tx *sql.Tx, table string, args FindTextHeapByContentParams) (heaps []string, err error) {
+	quoted, err := sqlsafe.QuoteIdent(table)
+	if err != nil {
+		return nil, err
+	}
+
 	var query = new(strings.Builder)
 	query.WriteString("SELECT content, hash FROM ")
-	query.WriteString(table)
+	query.WriteString(quoted)
 	query.WriteString(" WHERE content ILIKE $1")
-	rows, err := tx.QueryContext(ctx, query.String(), "%"+args.Content+"%")
+	rows, err := tx.QueryContext(ctx, query.String(), sqlsafe.Contains(args.Content))
 	if err != nil {
 		return
 	}
@@ -538,37 +846,6 @@ func searchContents(ctx context.Context, tx *sql.Tx, table string, args FindText
 	return
 }
 
-// deprecated function
-func saveToHeap(ctx context.Context, db *sql.DB, textHeaps []TextHeap) (err error) {
-	for _, th := range textHeaps {
-		query := new(strings.Builder)
-		query.WriteString("INSERT INTO ")
-		query.WriteString(th.Type)
-		query.WriteString(" (content, hash) VALUES ($1, $2)")
-		if ok, _ := isHashExist(ctx, db, th.Type, FindTextHeapByHashParams{Hash: th.Hash}); !ok {
-			_, err = db.ExecContext(ctx, query.String(), th.Content, th.Hash)
-		}
-	}
-	return
-}
-
-func isHashExist(ctx context.Context, db *sql.DB, typeHeap string, args FindTextHeapByHashParams) (bool, error) {
-	var query = new(strings.Builder)
-	query.WriteString("SELECT hash FROM ")
-	query.WriteString(typeHeap)
-	query.WriteString(" WHERE hash = $1")
-	row := db.QueryRowContext(ctx, query.String(), args.Hash)
-	var i FindTextHeapRow
-	err := row.Scan(&i.Hash)
-	if err != nil {
-		return false, err
-	}
-	if i.Hash == args.Hash {
-		return true, nil
-	}
-	return false, nil
-}
-
 func split(value string) (s []string) {
 	var sep = " "
 	reg := "[a-zA-Z0-9]+"
@@ -612,20 +889,26 @@ func validateEmail(email string) bool {
 	return re.MatchString(email)
 }
 
-func buildLikeQuery(column, baseQuery string, terms []string) (string, []interface{}) {
+func buildLikeQuery(column, baseQuery string, terms []string) (string, []interface{}, error) {
 	var likeClauses []string
 	var args []interface{}
 
+	quoted, err := sqlsafe.QuoteIdent(column)
+	if err != nil {
+		return "", nil, err
+	}
+
 	for _, term := range terms {
-		likeClauses = append(likeClauses, column+" LIKE $"+fmt.Sprint(len(args)+1))
-		args = append(args, "%"+term+"%")
+		likeClauses = append(likeClauses, quoted+" LIKE "+sqlsafe.Placeholder(len(args)+1))
+		args = append(args, sqlsafe.Contains(term))
 	}
 
 	fullQuery := fmt.Sprintf("%s WHERE %s", baseQuery, strings.Join(likeClauses, " OR "))
 
-	return fullQuery, args
+	return fullQuery, args, nil
 }
 
+// Deprecated: scans by field order, use MapColumns to scan by column name.
 func StructToInterfaceScan(v interface{}) []interface{} {
 	s := reflect.ValueOf(v).Elem()
 	numCols := s.NumField()
@@ -1,6 +1,6 @@
 module github.com/dyaksa/encryption-pii
 
-go 1.23
+go 1.23.0
 
 require github.com/joho/godotenv v1.5.1
 
@@ -8,3 +8,12 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/lib/pq v1.10.9
 )
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require golang.org/x/text v0.28.0
+
+require (
+	golang.org/x/crypto v0.41.0
+	golang.org/x/sys v0.35.0 // indirect
+)